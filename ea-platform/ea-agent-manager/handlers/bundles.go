@@ -0,0 +1,366 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"ea-agent-manager/logger"
+	"ea-agent-manager/metrics"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+//-----------------------------------------------------------------------------
+// Agent Export & Import Bundles
+//-----------------------------------------------------------------------------
+
+// bundleFormatVersion is the version of the AgentBundle format written by exports.
+const bundleFormatVersion = 1
+
+// Statuses of a node definition in an import report.
+const (
+	importCreated  = "created"  // Published as a new definition owned by the importer
+	importReused   = "reused"   // An identical definition with the same type and version exists
+	importConflict = "conflict" // A different definition with the same type and version exists
+	importHidden   = "hidden"   // The type is published by another creator and not visible to the importer
+)
+
+// AgentBundle is a self-contained copy of an agent and the node definitions it
+// uses. Secret placeholders such as ((api_key)) are exported as they are.
+type AgentBundle struct {
+	BundleVersion    int              `json:"bundle_version"`
+	ExportedAt       time.Time        `json:"exported_at"`
+	Agent            Agent            `json:"agent"`
+	NodeDefinitions  []NodeDefinition `json:"node_definitions"`
+	MissingNodeTypes []string         `json:"missing_node_types,omitempty"` // Types with no visible definition at export time
+}
+
+// ImportedNodeDefinition reports what an import did with one bundled node definition.
+type ImportedNodeDefinition struct {
+	Type    string `json:"type"`
+	Version string `json:"version"`
+	Status  string `json:"status"`
+	NodeID  string `json:"node_id,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// HandleExportAgent returns an agent, or one of its versions with `?version=N`,
+// together with every node definition version it resolves to.
+func HandleExportAgent(c *gin.Context) {
+	path := c.FullPath()
+	agentID := c.Param("agent_id")
+	metrics.StepCounter.WithLabelValues(path, "api_hit", "success").Inc()
+
+	// 🔹 Extract authenticated user ID from Kong's `X-Consumer-Username` header
+	authenticatedUserID := c.GetHeader("X-Consumer-Username")
+	if authenticatedUserID == "" {
+		logger.Slog.Error("Missing X-Consumer-Username header")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	agent, ok := loadOwnedAgent(c, path, agentID, authenticatedUserID)
+	if !ok {
+		return
+	}
+
+	// 🔹 Export an earlier version if requested
+	if v := c.Query("version"); v != "" {
+		version, err := strconv.Atoi(v)
+		if err != nil || version < 1 {
+			metrics.StepCounter.WithLabelValues(path, "invalid_version", "error").Inc()
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Version must be a positive integer"})
+			return
+		}
+		agentVersion, ok := loadAgentVersion(c, path, agentID, version)
+		if !ok {
+			return
+		}
+		agent = *agentVersion.Agent
+	}
+
+	// 🔹 Load the visible definitions of every type the graph uses
+	nodes := collectNodeInstances(agent.Nodes)
+	types := make(map[string]bool)
+	collectNodeTypes(agent.Nodes, types)
+	typeList := make([]string, 0, len(types))
+	for nodeType := range types {
+		typeList = append(typeList, nodeType)
+	}
+
+	filter := bson.M{"$and": bson.A{
+		nodeVisibilityFilter(authenticatedUserID, consumerGroups(c)),
+		bson.M{"type": bson.M{"$in": typeList}},
+	}}
+	var defs []NodeDefinition
+	if err := dbClient.FindRecordsInto("nodeDefs", "nodes", filter, bson.M{"_id": 0}, &defs); err != nil {
+		metrics.StepCounter.WithLabelValues(path, "db_retrieval_error", "error").Inc()
+		logger.Slog.Error("Failed to load node definitions for export", "agent_id", agentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load node definitions"})
+		return
+	}
+	defsByType := make(map[string][]NodeDefinition)
+	for _, def := range defs {
+		defsByType[def.Type] = append(defsByType[def.Type], def)
+	}
+
+	// 🔹 Keep the version each node resolves to, once per type and version
+	bundle := AgentBundle{
+		BundleVersion:   bundleFormatVersion,
+		ExportedAt:      time.Now().UTC(),
+		Agent:           agent,
+		NodeDefinitions: []NodeDefinition{},
+	}
+	included := make(map[string]bool)
+	missing := make(map[string]bool)
+	for _, node := range nodes {
+		if isBuiltinNodeType(splitBase(node.Type)) {
+			continue
+		}
+		def, err := resolveNodeVersion(node.Type, defsByType[splitBase(node.Type)])
+		if err != nil {
+			if !missing[node.Type] {
+				missing[node.Type] = true
+				bundle.MissingNodeTypes = append(bundle.MissingNodeTypes, node.Type)
+			}
+			continue
+		}
+		key := def.Type + "@" + def.Version
+		if !included[key] {
+			included[key] = true
+			bundle.NodeDefinitions = append(bundle.NodeDefinitions, def)
+		}
+	}
+	if len(bundle.MissingNodeTypes) > 0 {
+		logger.Slog.Warn("Exported agent uses node types without a visible definition", "agent_id", agentID, "types", bundle.MissingNodeTypes)
+	}
+
+	metrics.StepCounter.WithLabelValues(path, "export_success", "success").Inc()
+	logger.Slog.Info("Agent exported", "agent_id", agentID, "version", agent.Version, "node_definitions", len(bundle.NodeDefinitions))
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"agent-%s-v%d.json\"", agent.ID, agent.Version))
+	c.JSON(http.StatusOK, bundle)
+}
+
+// HandleImportAgent creates a new agent owned by the caller from a bundle.
+// Bundled node definitions are reused when an identical type and version
+// exists and published otherwise; a different definition under the same type
+// and version is a conflict and nothing is imported. `?dry_run=true` only
+// reports what would happen.
+func HandleImportAgent(c *gin.Context) {
+	var bundle AgentBundle
+	path := c.FullPath()
+
+	metrics.StepCounter.WithLabelValues(path, "api_request_start", "success").Inc()
+	logger.Slog.Info("Agent import request received")
+
+	// 🔹 Extract authenticated user ID from Kong's `X-Consumer-Username` header
+	authenticatedUserID := c.GetHeader("X-Consumer-Username")
+	if authenticatedUserID == "" {
+		logger.Slog.Error("Missing X-Consumer-Username header")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// 🔹 Parse request body
+	if err := c.ShouldBindJSON(&bundle); err != nil {
+		metrics.StepCounter.WithLabelValues(path, "decode_error", "error").Inc()
+		logger.Slog.Error("Failed to parse request body", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse request body"})
+		return
+	}
+	if bundle.BundleVersion != bundleFormatVersion {
+		metrics.StepCounter.WithLabelValues(path, "invalid_bundle", "error").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Unsupported bundle_version %d", bundle.BundleVersion)})
+		return
+	}
+	dryRun := c.Query("dry_run") == "true"
+
+	// 🔹 The importer owns the new agent; internal services may name another creator
+	creator := authenticatedUserID
+	if authenticatedUserID == "internal" {
+		creator = c.DefaultQuery("creator", bundle.Agent.Creator)
+	}
+
+	// 🔹 Match the bundled definitions against the published ones the creator can see
+	var groups []string
+	if creator == authenticatedUserID {
+		groups = consumerGroups(c)
+	}
+	report, created, ok := planNodeDefinitionImport(c, path, bundle.NodeDefinitions, creator, groups)
+	if !ok {
+		return
+	}
+
+	// 🔹 Remap the agent to a fresh identity
+	agent := bundle.Agent
+	agent.ID = uuid.New().String()
+	agent.Creator = creator
+	agent.Version = 1
+	agent.Metadata = Metadata{CreatedAt: time.Now().UTC(), UpdatedAt: time.Now().UTC()}
+	for i, node := range agent.Nodes {
+		if node.Alias == "" {
+			agent.Nodes[i].Alias = fmt.Sprintf("node-%d", i)
+		}
+	}
+
+	// 🔹 Validate against the published definitions plus the ones being imported
+	result, err := validateAgent(agent, creator, groups, created...)
+	if err != nil {
+		metrics.StepCounter.WithLabelValues(path, "db_retrieval_error", "error").Inc()
+		logger.Slog.Error("Failed to load node definitions for validation", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load node definitions"})
+		return
+	}
+
+	conflicts := 0
+	for _, entry := range report {
+		if entry.Status == importConflict || entry.Status == importHidden {
+			conflicts++
+		}
+	}
+	switch {
+	case conflicts > 0:
+		metrics.StepCounter.WithLabelValues(path, "import_conflict", "error").Inc()
+		logger.Slog.Warn("Agent import has node definition conflicts", "conflicts", conflicts)
+		c.JSON(http.StatusConflict, gin.H{"error": "Bundle conflicts with published node definitions", "node_definitions": report, "errors": result.Errors})
+		return
+	case !result.Valid:
+		metrics.StepCounter.WithLabelValues(path, "validation_error", "error").Inc()
+		logger.Slog.Warn("Imported agent graph is invalid", "errors", len(result.Errors))
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Agent graph is invalid", "node_definitions": report, "errors": result.Errors})
+		return
+	case dryRun:
+		metrics.StepCounter.WithLabelValues(path, "dry_run_success", "success").Inc()
+		c.JSON(http.StatusOK, gin.H{"message": "Bundle can be imported", "node_definitions": report})
+		return
+	}
+
+	// 🔹 Publish the new node definitions, then the agent
+	for _, def := range created {
+		if !publishNodeVersion(c, path, def) {
+			return
+		}
+	}
+
+	if _, err := dbClient.InsertRecord("userAgents", "agents", agent); err != nil {
+		metrics.StepCounter.WithLabelValues(path, "db_insertion_error", "error").Inc()
+		logger.Slog.Error("Failed to insert imported agent", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert agent"})
+		return
+	}
+	summary := "Imported"
+	if bundle.Agent.ID != "" {
+		summary = fmt.Sprintf("Imported from agent %s version %d", bundle.Agent.ID, bundle.Agent.Version)
+	}
+	if err := recordAgentVersion(agent, nil, authenticatedUserID, summary); err != nil {
+		metrics.StepCounter.WithLabelValues(path, "db_insertion_error", "error").Inc()
+		logger.Slog.Error("Failed to record agent version", "agent_id", agent.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record agent version"})
+		return
+	}
+
+	metrics.StepCounter.WithLabelValues(path, "import_success", "success").Inc()
+	logger.Slog.Info("Agent imported", "agent_id", agent.ID, "source_agent_id", bundle.Agent.ID, "creator", creator, "node_definitions_created", len(created))
+	c.JSON(http.StatusCreated, gin.H{"message": "Agent imported", "agent_id": agent.ID, "creator": creator, "version": agent.Version, "node_definitions": report})
+}
+
+// planNodeDefinitionImport decides for each bundled definition whether it is
+// reused, created or conflicts, and returns the definitions to publish with
+// their new IDs. Published definitions the creator cannot see are reported as
+// hidden, without their ID. It writes the error response on failure.
+func planNodeDefinitionImport(c *gin.Context, path string, bundled []NodeDefinition, creator string, groups []string) ([]ImportedNodeDefinition, []NodeDefinition, bool) {
+	report := []ImportedNodeDefinition{}
+	var created []NodeDefinition
+	seen := make(map[string]bool)
+
+	for _, def := range bundled {
+		if def.Version == "" {
+			def.Version = defaultNodeVersion
+		}
+		entry := ImportedNodeDefinition{Type: def.Type, Version: def.Version}
+		if _, err := parseFullVersion(def.Version); err != nil || def.Type == "" {
+			metrics.StepCounter.WithLabelValues(path, "invalid_bundle", "error").Inc()
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid node definition %q version %q in bundle", def.Type, def.Version)})
+			return nil, nil, false
+		}
+
+		// Bundles list each type and version once, but tolerate repeats
+		key := def.Type + "@" + def.Version
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		var versions []NodeDefinition
+		if err := dbClient.FindRecordsInto("nodeDefs", "nodes", bson.M{"type": def.Type}, bson.M{"_id": 0}, &versions); err != nil {
+			metrics.StepCounter.WithLabelValues(path, "db_retrieval_error", "error").Inc()
+			logger.Slog.Error("Failed to look up node definition", "type", def.Type, "version", def.Version, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load node definitions"})
+			return nil, nil, false
+		}
+		var existing *NodeDefinition
+		for i := range versions {
+			if versions[i].Version == def.Version {
+				existing = &versions[i]
+			}
+		}
+		owner, owned := nodeTypeOwner(def.Type, versions)
+
+		switch {
+		case existing != nil && !canViewNodeDef(creator, groups, existing.Creator, existing.Visibility, existing.Org):
+			entry.Status = importHidden
+			entry.Message = "this type and version is published by another creator"
+		case existing == nil && owned && owner != creator && creator != "internal":
+			entry.Status = importHidden
+			entry.Message = "this type belongs to another creator"
+		case existing == nil:
+			def.ID = uuid.New().String()
+			def.Creator = creator
+			def.Visibility, def.Org = visibilityPrivate, ""
+			def.Metadata.CreatedAt = time.Now().UTC()
+			def.Metadata.UpdatedAt = time.Now().UTC()
+			created = append(created, def)
+			entry.Status, entry.NodeID = importCreated, def.ID
+		case sameNodeBehaviour(*existing, def):
+			entry.Status, entry.NodeID = importReused, existing.ID
+		default:
+			entry.Status, entry.NodeID = importConflict, existing.ID
+			entry.Message = "a different definition is already published under this type and version"
+		}
+		report = append(report, entry)
+	}
+	return report, created, true
+}
+
+// sameNodeBehaviour reports whether two definitions call the same API with the
+// same parameters, outputs and retry policy. Names, owners and docs may differ.
+func sameNodeBehaviour(a, b NodeDefinition) bool {
+	behaviour := func(def NodeDefinition) interface{} {
+		return normalizeValue(map[string]interface{}{
+			"api":        def.API,
+			"parameters": def.Parameters,
+			"outputs":    def.Outputs,
+			"retry":      def.Retry,
+		})
+	}
+	return reflect.DeepEqual(behaviour(a), behaviour(b))
+}
+
+// collectNodeInstances flattens nodes and the nodes of nested foreach graphs.
+func collectNodeInstances(nodes []NodeInstance) []NodeInstance {
+	var all []NodeInstance
+	for _, node := range nodes {
+		all = append(all, node)
+		if node.Type == "control.foreach" {
+			if nested, _, err := foreachGraph(node); err == nil {
+				all = append(all, collectNodeInstances(nested)...)
+			}
+		}
+	}
+	return all
+}
@@ -0,0 +1,358 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"ea-agent-manager/logger"
+	"ea-agent-manager/metrics"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+//-----------------------------------------------------------------------------
+// Node Definition Visibility & Catalog
+//-----------------------------------------------------------------------------
+
+// Visibility levels of a node definition. Definitions without a visibility are private.
+const (
+	visibilityPrivate = "private" // Only the creator
+	visibilityOrg     = "org"     // Members of the definition's org
+	visibilityPublic  = "public"  // Everyone
+)
+
+// systemCreator owns the node definitions imported from the presets directory.
+const systemCreator = "system"
+
+// presetNodeTypes holds the types in the presets directory. They belong to
+// systemCreator even if a user published a version of them first. It is filled
+// by ImportNodePresets at startup, before requests are served.
+var presetNodeTypes = map[string]bool{}
+
+// CatalogEntry is a node definition as listed in the catalog.
+type CatalogEntry struct {
+	ID          string   `json:"id"`
+	Type        string   `json:"type"`
+	Version     string   `json:"version"`
+	Name        string   `json:"name,omitempty"`
+	Creator     string   `json:"creator"`
+	Visibility  string   `json:"visibility"`
+	Org         string   `json:"org,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+// consumerGroups returns the orgs of the caller from Kong's `X-Consumer-Groups` header.
+func consumerGroups(c *gin.Context) []string {
+	var groups []string
+	for _, group := range strings.Split(c.GetHeader("X-Consumer-Groups"), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
+// nodeVisibilityFilter matches the node definitions a user may see.
+func nodeVisibilityFilter(userID string, groups []string) bson.M {
+	if userID == "internal" {
+		return bson.M{}
+	}
+	visible := bson.A{
+		bson.M{"creator": userID},
+		bson.M{"visibility": visibilityPublic},
+	}
+	if len(groups) > 0 {
+		visible = append(visible, bson.M{"visibility": visibilityOrg, "org": bson.M{"$in": groups}})
+	}
+	return bson.M{"$or": visible}
+}
+
+// canViewNodeDef reports whether a user may see a node definition.
+func canViewNodeDef(userID string, groups []string, creator, visibility, org string) bool {
+	switch {
+	case userID == "internal", creator == userID, visibility == visibilityPublic:
+		return true
+	case visibility == visibilityOrg:
+		for _, group := range groups {
+			if group == org {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// normalizeVisibility defaults and checks the visibility of a node definition.
+// Org definitions default to the first org of the caller, who must belong to it.
+func normalizeVisibility(def *NodeDefinition, userID string, groups []string) error {
+	switch def.Visibility {
+	case "":
+		def.Visibility = visibilityPrivate
+	case visibilityPrivate, visibilityPublic, visibilityOrg:
+	default:
+		return fmt.Errorf("invalid visibility %q (expected %s, %s or %s)", def.Visibility, visibilityPrivate, visibilityOrg, visibilityPublic)
+	}
+
+	if def.Visibility != visibilityOrg {
+		def.Org = ""
+		return nil
+	}
+	if def.Org == "" {
+		if len(groups) == 0 {
+			return errors.New("org visibility requires an org")
+		}
+		def.Org = groups[0]
+	}
+	if userID != "internal" && !canViewNodeDef(userID, groups, "", visibilityOrg, def.Org) {
+		return fmt.Errorf("not a member of org %q", def.Org)
+	}
+	return nil
+}
+
+// HandleSearchNodeCatalog lists the node definitions visible to the caller.
+// `q` searches the type, name and description, each `tag` must be present, and
+// only the latest version of each type is listed unless `all_versions=true`.
+func HandleSearchNodeCatalog(c *gin.Context) {
+	path := c.FullPath()
+	metrics.StepCounter.WithLabelValues(path, "api_hit", "success").Inc()
+
+	// 🔹 Extract the authenticated user from Kong's `X-Consumer-Username` header
+	authenticatedUserID := c.GetHeader("X-Consumer-Username")
+	if authenticatedUserID == "" {
+		logger.Slog.Error("Missing X-Consumer-Username header")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// 🔹 Build the search filter on top of the visibility filter
+	conditions := bson.A{nodeVisibilityFilter(authenticatedUserID, consumerGroups(c))}
+	if tags := c.QueryArray("tag"); len(tags) > 0 {
+		conditions = append(conditions, bson.M{"metadata.tags": bson.M{"$all": tags}})
+	}
+	if visibility := c.Query("visibility"); visibility != "" {
+		conditions = append(conditions, bson.M{"visibility": visibility})
+	}
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		pattern := bson.M{"$regex": regexp.QuoteMeta(q), "$options": "i"}
+		conditions = append(conditions, bson.M{"$or": bson.A{
+			bson.M{"type": pattern},
+			bson.M{"name": pattern},
+			bson.M{"metadata.description": pattern},
+		}})
+	}
+	filter := bson.M{"$and": conditions}
+	projection := bson.M{"_id": 0, "id": 1, "type": 1, "version": 1, "name": 1, "creator": 1, "visibility": 1, "org": 1, "metadata": 1}
+
+	var defs []NodeDefinition
+	if err := dbClient.FindRecordsInto("nodeDefs", "nodes", filter, projection, &defs); err != nil {
+		metrics.StepCounter.WithLabelValues(path, "db_retrieval_error", "error").Inc()
+		logger.Slog.Error("Failed to search node catalog", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve node definitions"})
+		return
+	}
+
+	// 🔹 Keep the latest version of each type unless all versions are requested
+	if c.Query("all_versions") != "true" {
+		defs = latestNodeVersions(defs)
+	}
+
+	entries := make([]CatalogEntry, 0, len(defs))
+	for _, def := range defs {
+		visibility := def.Visibility
+		if visibility == "" {
+			visibility = visibilityPrivate
+		}
+		entries = append(entries, CatalogEntry{
+			ID:          def.ID,
+			Type:        def.Type,
+			Version:     def.Version,
+			Name:        def.Name,
+			Creator:     def.Creator,
+			Visibility:  visibility,
+			Org:         def.Org,
+			Description: def.Metadata.Description,
+			Tags:        def.Metadata.Tags,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Type != entries[j].Type {
+			return entries[i].Type < entries[j].Type
+		}
+		return entries[i].Version < entries[j].Version
+	})
+
+	metrics.StepCounter.WithLabelValues(path, "retrieval_success", "success").Inc()
+	logger.Slog.Info("Node catalog searched", "user", authenticatedUserID, "count", len(entries))
+	c.JSON(http.StatusOK, entries)
+}
+
+// ResolvedNodeVersion is the definition version a node type resolves to.
+type ResolvedNodeVersion struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Version string `json:"version"`
+}
+
+// HandleResolveNodeVersions resolves each `type` query parameter, which may
+// carry a version constraint, to the highest matching version visible to the
+// caller. Types without a visible definition (such as built-in executor nodes)
+// are left out; types whose constraint no version satisfies are in `errors`.
+func HandleResolveNodeVersions(c *gin.Context) {
+	path := c.FullPath()
+	metrics.StepCounter.WithLabelValues(path, "api_hit", "success").Inc()
+
+	// 🔹 Extract the authenticated user from Kong's `X-Consumer-Username` header
+	authenticatedUserID := c.GetHeader("X-Consumer-Username")
+	if authenticatedUserID == "" {
+		logger.Slog.Error("Missing X-Consumer-Username header")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	nodeTypes := c.QueryArray("type")
+	bases := make([]string, 0, len(nodeTypes))
+	for _, nodeType := range nodeTypes {
+		bases = append(bases, splitBase(nodeType))
+	}
+
+	// 🔹 Load the visible versions of every requested type
+	filter := bson.M{"$and": bson.A{
+		nodeVisibilityFilter(authenticatedUserID, consumerGroups(c)),
+		bson.M{"type": bson.M{"$in": bases}},
+	}}
+	var defs []NodeDefinition
+	if err := dbClient.FindRecordsInto("nodeDefs", "nodes", filter, bson.M{"_id": 0, "id": 1, "type": 1, "version": 1}, &defs); err != nil {
+		metrics.StepCounter.WithLabelValues(path, "db_retrieval_error", "error").Inc()
+		logger.Slog.Error("Failed to load node definitions for resolution", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve node definitions"})
+		return
+	}
+	defsByType := make(map[string][]NodeDefinition)
+	for _, def := range defs {
+		defsByType[def.Type] = append(defsByType[def.Type], def)
+	}
+
+	resolved := make(map[string]ResolvedNodeVersion)
+	errs := make(map[string]string)
+	for _, nodeType := range nodeTypes {
+		versions := defsByType[splitBase(nodeType)]
+		if len(versions) == 0 {
+			continue
+		}
+		def, err := resolveNodeVersion(nodeType, versions)
+		if err != nil {
+			errs[nodeType] = err.Error()
+			continue
+		}
+		resolved[nodeType] = ResolvedNodeVersion{ID: def.ID, Type: def.Type, Version: nodeVersionOf(def).String()}
+	}
+
+	metrics.StepCounter.WithLabelValues(path, "resolve_success", "success").Inc()
+	logger.Slog.Info("Node versions resolved", "user", authenticatedUserID, "resolved", len(resolved), "errors", len(errs))
+	c.JSON(http.StatusOK, gin.H{"nodes": resolved, "errors": errs})
+}
+
+// latestNodeVersions keeps the highest version of each node type.
+func latestNodeVersions(defs []NodeDefinition) []NodeDefinition {
+	latest := make(map[string]NodeDefinition)
+	var order []string
+	for _, def := range defs {
+		current, ok := latest[def.Type]
+		if !ok {
+			order = append(order, def.Type)
+			latest[def.Type] = def
+			continue
+		}
+		if nodeVersionOf(def).compare(nodeVersionOf(current)) > 0 {
+			latest[def.Type] = def
+		}
+	}
+
+	result := make([]NodeDefinition, 0, len(order))
+	for _, nodeType := range order {
+		result = append(result, latest[nodeType])
+	}
+	return result
+}
+
+// nodeVersionOf returns the version of a definition; unversioned or invalid versions count as 1.0.0.
+func nodeVersionOf(def NodeDefinition) semver {
+	if v, err := parseFullVersion(def.Version); err == nil {
+		return v
+	}
+	return semver{1, 0, 0}
+}
+
+// ImportNodePresets publishes the node definitions in dir as public,
+// system-owned definitions. Versions that are already published are skipped,
+// so the import is safe to run on every startup.
+func ImportNodePresets(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		logger.Slog.Warn("No node presets found", "dir", dir)
+		return nil
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		var def NodeDefinition
+		if err := json.Unmarshal(data, &def); err != nil {
+			return fmt.Errorf("invalid node preset %s: %w", file, err)
+		}
+		if def.Type == "" {
+			return fmt.Errorf("node preset %s is missing a type", file)
+		}
+		if def.Version == "" {
+			def.Version = defaultNodeVersion
+		}
+		if _, err := parseFullVersion(def.Version); err != nil {
+			return fmt.Errorf("node preset %s: %w", file, err)
+		}
+		presetNodeTypes[def.Type] = true
+
+		// 🔹 Published versions are immutable; skip presets that are already imported
+		var existing []NodeDefinition
+		if err := dbClient.FindRecordsInto("nodeDefs", "nodes", bson.M{"type": def.Type, "version": def.Version}, bson.M{"_id": 0, "id": 1, "creator": 1}, &existing); err != nil {
+			return err
+		}
+		if len(existing) > 0 {
+			if existing[0].Creator == systemCreator {
+				logger.Slog.Info("Node preset already imported", "type", def.Type, "version", def.Version)
+			} else {
+				logger.Slog.Warn("Node preset version is already published by another creator", "type", def.Type, "version", def.Version, "creator", existing[0].Creator)
+			}
+			continue
+		}
+
+		def.ID = uuid.New().String()
+		def.Creator = systemCreator
+		def.Visibility = visibilityPublic
+		def.Org = ""
+		def.Metadata.CreatedAt = time.Now().UTC()
+		def.Metadata.UpdatedAt = time.Now().UTC()
+
+		if _, err := dbClient.InsertRecord("nodeDefs", "nodes", def); err != nil {
+			return fmt.Errorf("failed to import node preset %s: %w", file, err)
+		}
+		logger.Slog.Info("Imported node preset", "file", file, "type", def.Type, "version", def.Version, "node_id", def.ID)
+	}
+	return nil
+}
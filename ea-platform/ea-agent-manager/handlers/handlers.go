@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"regexp"
+	"strings"
 	"time"
 
 	"ea-agent-manager/logger"
@@ -13,11 +15,43 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // dbClient is the shared MongoDB client for handlers.
 var dbClient *mongo.MongoClient
 
+// EnsureIndexes creates the indexes the handlers rely on.
+func EnsureIndexes() error {
+	// Version numbers are unique per agent, so concurrent updates cannot both claim one
+	if err := dbClient.CreateIndex("userAgents", "agentVersions", bson.D{{Key: "agent_id", Value: 1}, {Key: "version", Value: -1}},
+		options.Index().SetUnique(true)); err != nil {
+		return err
+	}
+
+	// List endpoints page through these sort orders, scoped by creator for agents
+	listIndexes := []struct {
+		database, collection string
+		keys                 bson.D
+	}{
+		{"userAgents", "agents", bson.D{{Key: "creator", Value: 1}, {Key: "metadata.createdat", Value: 1}, {Key: "id", Value: 1}}},
+		{"userAgents", "agents", bson.D{{Key: "creator", Value: 1}, {Key: "metadata.updatedat", Value: 1}, {Key: "id", Value: 1}}},
+		{"userAgents", "agents", bson.D{{Key: "creator", Value: 1}, {Key: "name", Value: 1}, {Key: "id", Value: 1}}},
+		{"nodeDefs", "nodes", bson.D{{Key: "type", Value: 1}, {Key: "id", Value: 1}}},
+		{"nodeDefs", "nodes", bson.D{{Key: "metadata.createdat", Value: 1}, {Key: "id", Value: 1}}},
+		{"nodeDefs", "nodes", bson.D{{Key: "metadata.tags", Value: 1}}},
+	}
+	for _, index := range listIndexes {
+		if err := dbClient.CreateIndex(index.database, index.collection, index.keys, options.Index()); err != nil {
+			return err
+		}
+	}
+
+	// Each version of a node type is published once; records from before versioning have no version
+	return dbClient.CreateIndex("nodeDefs", "nodes", bson.D{{Key: "type", Value: 1}, {Key: "version", Value: 1}},
+		options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"version": bson.M{"$type": "string"}}))
+}
+
 // SetDBClient sets the MongoDB client for handlers.
 func SetDBClient(client *mongo.MongoClient) {
 	if client == nil {
@@ -39,13 +73,26 @@ type NodeAPI struct {
 	Headers  map[string]string `json:"headers,omitempty"`
 }
 
-// NodeParameter describes each parameter for a NodeDefinition.
+// NodeParameter describes each parameter (or output) for a NodeDefinition, and
+// each input an Agent declares.
 type NodeParameter struct {
 	Key         string        `json:"key"`
 	Type        string        `json:"type"`
 	Description string        `json:"description,omitempty"`
 	Default     interface{}   `json:"default,omitempty"`
 	Enum        []interface{} `json:"enum,omitempty"`
+	Required    bool          `json:"required,omitempty"` // Parameters only: must be set on every node instance
+	Path        string        `json:"path,omitempty"`     // Outputs only: where the value is in the node result
+	Optional    bool          `json:"optional,omitempty"` // Outputs only: may be missing from the result
+}
+
+// RetryPolicy controls how the job executor retries failed API calls for a node.
+type RetryPolicy struct {
+	MaxAttempts          int     `json:"max_attempts,omitempty" bson:"max_attempts,omitempty"`
+	InitialBackoff       string  `json:"initial_backoff,omitempty" bson:"initial_backoff,omitempty"`
+	MaxBackoff           string  `json:"max_backoff,omitempty" bson:"max_backoff,omitempty"`
+	Multiplier           float64 `json:"multiplier,omitempty" bson:"multiplier,omitempty"`
+	RetryableStatusCodes []int   `json:"retryable_status_codes,omitempty" bson:"retryable_status_codes,omitempty"`
 }
 
 // NodeDefinitionMetadata holds metadata about the node definition.
@@ -61,12 +108,16 @@ type NodeDefinitionMetadata struct {
 type NodeDefinition struct {
 	ID         string                 `json:"id"`
 	Type       string                 `json:"type"`
+	Version    string                 `json:"version"` // Semantic version; each version is a separate, immutable record
 	Alias      string                 `json:"alias"`
 	Name       string                 `json:"name,omitempty"`
 	Creator    string                 `json:"creator,omitempty"`
+	Visibility string                 `json:"visibility,omitempty"` // private (default), org or public
+	Org        string                 `json:"org,omitempty"`        // Org that can see an org-visible definition
 	API        *NodeAPI               `json:"api,omitempty"`
 	Parameters []NodeParameter        `json:"parameters,omitempty"`
 	Outputs    []NodeParameter        `json:"outputs,omitempty"`
+	Retry      *RetryPolicy           `json:"retry,omitempty" bson:"retry,omitempty"`
 	Metadata   NodeDefinitionMetadata `json:"metadata"`
 }
 
@@ -76,6 +127,7 @@ type NodeInstance struct {
 	Type       string                 `json:"type"`
 	Parameters map[string]interface{} `json:"parameters,omitempty"`
 	Position   map[string]interface{} `json:"position,omitempty"`
+	Retry      *RetryPolicy           `json:"retry,omitempty" bson:"retry,omitempty"` // Overrides the definition's retry policy
 }
 
 // Edge represents a connection between nodes in an agent workflow.
@@ -92,13 +144,15 @@ type Metadata struct {
 
 // Agent represents an AI workflow with interconnected nodes.
 type Agent struct {
-	ID          string         `json:"id"`
-	Name        string         `json:"name"`
-	Creator     string         `json:"creator"`
-	Description string         `json:"description"`
-	Nodes       []NodeInstance `json:"nodes"`
-	Edges       []Edge         `json:"edges"`
-	Metadata    Metadata       `json:"metadata"`
+	ID          string          `json:"id"`
+	Name        string          `json:"name"`
+	Creator     string          `json:"creator"`
+	Description string          `json:"description"`
+	Nodes       []NodeInstance  `json:"nodes"`
+	Edges       []Edge          `json:"edges"`
+	Inputs      []NodeParameter `json:"inputs,omitempty"` // Job inputs, referenced as {{inputs.key}}
+	Version     int             `json:"version"`          // Latest revision; see AgentVersion
+	Metadata    Metadata        `json:"metadata"`
 }
 
 //-----------------------------------------------------------------------------
@@ -140,26 +194,110 @@ func HandleCreateNodeDef(c *gin.Context) {
 		}
 	}
 
+	// 🔹 Default and validate the version
+	if input.Version == "" {
+		input.Version = defaultNodeVersion
+	}
+	if _, err := parseFullVersion(input.Version); err != nil {
+		metrics.StepCounter.WithLabelValues(path, "invalid_version", "error").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if input.Type == "" || strings.Contains(input.Type, "@") {
+		metrics.StepCounter.WithLabelValues(path, "invalid_type", "error").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Node definition type must be set and must not contain '@'"})
+		return
+	}
+	if err := normalizeVisibility(&input, authenticatedUserID, consumerGroups(c)); err != nil {
+		metrics.StepCounter.WithLabelValues(path, "invalid_visibility", "error").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// 🔹 Assign a unique ID and timestamps
 	input.ID = uuid.New().String()
 	input.Metadata.CreatedAt = time.Now().UTC()
 	input.Metadata.UpdatedAt = time.Now().UTC()
 
 	metrics.StepCounter.WithLabelValues(path, "valid_request_body", "success").Inc()
+	if !publishNodeVersion(c, path, input) {
+		return
+	}
+
+	metrics.StepCounter.WithLabelValues(path, "create_success", "success").Inc()
+	logger.Slog.Info("Node definition inserted successfully", "node_id", input.ID, "creator", input.Creator, "version", input.Version)
+	c.JSON(http.StatusCreated, gin.H{"message": "Node definition created", "node_id": input.ID, "creator": input.Creator, "version": input.Version})
+}
+
+// publishNodeVersion inserts a node definition version unless that version of
+// the type already exists. Only the owner of the type (see nodeTypeOwner) or
+// internal services may publish further versions of it. It writes the error
+// response on failure.
+func publishNodeVersion(c *gin.Context, path string, input NodeDefinition) bool {
+	var existing []NodeDefinition
+	if err := dbClient.FindRecordsInto("nodeDefs", "nodes", bson.M{"type": input.Type}, bson.M{"_id": 0, "id": 1, "version": 1, "creator": 1, "metadata": 1}, &existing); err != nil {
+		metrics.StepCounter.WithLabelValues(path, "db_retrieval_error", "error").Inc()
+		logger.Slog.Error("Failed to check existing node definition versions", "type", input.Type, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert node definition"})
+		return false
+	}
+	if owner, ok := nodeTypeOwner(input.Type, existing); ok && owner != input.Creator && c.GetHeader("X-Consumer-Username") != "internal" {
+		metrics.StepCounter.WithLabelValues(path, "type_owner_mismatch", "failure").Inc()
+		logger.Slog.Warn("Node type belongs to another creator", "type", input.Type, "owner", owner, "creator", input.Creator)
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Node type %s belongs to another creator", input.Type)})
+		return false
+	}
+	for _, def := range existing {
+		if def.Version == input.Version {
+			metrics.StepCounter.WithLabelValues(path, "version_exists", "error").Inc()
+			logger.Slog.Warn("Node definition version already published", "type", input.Type, "version", input.Version)
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Version %s of %s is already published", input.Version, input.Type)})
+			return false
+		}
+	}
+
 	result, err := dbClient.InsertRecord("nodeDefs", "nodes", input)
 	if err != nil {
+		if mongo.IsDuplicateKey(err) {
+			metrics.StepCounter.WithLabelValues(path, "version_exists", "error").Inc()
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Version %s of %s is already published", input.Version, input.Type)})
+			return false
+		}
 		metrics.StepCounter.WithLabelValues(path, "db_insertion_error", "error").Inc()
-		logger.Slog.Error("Failed to insert node definition", "mongo_id", result.InsertedID, "input_id", input.ID, "error", err)
+		logger.Slog.Error("Failed to insert node definition", "mongo_id", result, "input_id", input.ID, "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert node definition"})
-		return
+		return false
 	}
+	return true
+}
 
-	metrics.StepCounter.WithLabelValues(path, "create_success", "success").Inc()
-	logger.Slog.Info("Node definition inserted successfully", "node_id", input.ID, "creator", input.Creator)
-	c.JSON(http.StatusCreated, gin.H{"message": "Node definition created", "node_id": input.ID, "creator": input.Creator})
+// nodeTypeOwner returns the owner of a type. Preset types and types with a
+// system-published version belong to systemCreator, so users cannot publish
+// versions of them; other types belong to the creator of their first version.
+func nodeTypeOwner(nodeType string, versions []NodeDefinition) (string, bool) {
+	if presetNodeTypes[nodeType] {
+		return systemCreator, true
+	}
+	for _, def := range versions {
+		if def.Creator == systemCreator {
+			return systemCreator, true
+		}
+	}
+	if len(versions) == 0 {
+		return "", false
+	}
+	first := versions[0]
+	for _, def := range versions[1:] {
+		if def.Metadata.CreatedAt.Before(first.Metadata.CreatedAt) ||
+			(def.Metadata.CreatedAt.Equal(first.Metadata.CreatedAt) && nodeVersionOf(def).compare(nodeVersionOf(first)) < 0) {
+			first = def
+		}
+	}
+	return first.Creator, true
 }
 
-// HandleGetAllNodeDefs retrieves all node definitions for the authenticated user or internal services.
+// HandleGetAllNodeDefs retrieves the node definitions visible to the authenticated user:
+// their own, public ones and those shared with their orgs. Internal services see all.
 func HandleGetAllNodeDefs(c *gin.Context) {
 	path := c.FullPath()
 	metrics.StepCounter.WithLabelValues(path, "api_hit", "success").Inc()
@@ -175,34 +313,35 @@ func HandleGetAllNodeDefs(c *gin.Context) {
 	// 🔹 Extract optional creator ID from query params
 	requestedCreatorID := c.Query("creator_id")
 
-	var filter bson.M
-
-	// 🔹 Allow internal services unrestricted access
+	// 🔹 Restrict non-internal users to the definitions they can see
 	if authenticatedUserID == "internal" {
 		logger.Slog.Info("Internal service access granted for fetching all node definitions")
-		filter = bson.M{} // No filtering; fetch all node definitions
-	} else {
-		// 🔹 Enforce creator filtering for non-internal users:
-		// - If `creator_id` is provided, ensure it matches the authenticated user.
-		// - If `creator_id` is absent, default to fetching the authenticated user's node definitions.
-		if requestedCreatorID != "" && requestedCreatorID != authenticatedUserID {
-			logger.Slog.Error("User spoofing attempt detected", "authenticated", authenticatedUserID, "requested", requestedCreatorID)
-			metrics.StepCounter.WithLabelValues(path, "user_spoofing_attempt", "failure").Inc()
-			c.JSON(http.StatusForbidden, gin.H{"error": "Creator ID does not match authenticated user"})
-			return
-		}
-		filter = bson.M{"creator": authenticatedUserID}
+	}
+	filter := nodeVisibilityFilter(authenticatedUserID, consumerGroups(c))
+	if requestedCreatorID != "" {
+		filter = bson.M{"$and": bson.A{filter, bson.M{"creator": requestedCreatorID}}}
+	}
+
+	// 🔹 Parse pagination, sorting and filters
+	query, err := parseListQuery(c, nodeDefSortFields, "type", "metadata.createdat", "name")
+	if err != nil {
+		metrics.StepCounter.WithLabelValues(path, "invalid_query", "error").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if tags := c.QueryArray("tag"); len(tags) > 0 {
+		query.addFilter(bson.M{"metadata.tags": bson.M{"$all": tags}})
+	}
+	if typePrefix := c.Query("type"); typePrefix != "" {
+		query.addFilter(prefixFilter("type", typePrefix))
 	}
 
 	// 🔹 Define projection to limit returned fields
-	projection := bson.M{"id": 1, "type": 1, "creator": 1, "_id": 0}
+	projection := bson.M{"id": 1, "type": 1, "version": 1, "creator": 1, "visibility": 1, "_id": 0}
 
-	// 🔹 Retrieve records from MongoDB
-	nodeDefs, err := dbClient.FindRecordsWithProjection("nodeDefs", "nodes", filter, projection)
-	if err != nil {
-		metrics.StepCounter.WithLabelValues(path, "db_retrieval_error", "error").Inc()
-		logger.Slog.Error("Failed to retrieve node definitions", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve node definitions"})
+	// 🔹 Retrieve one page of records from MongoDB
+	nodeDefs, ok := query.findPage(c, path, "nodeDefs", "nodes", filter, projection)
+	if !ok {
 		return
 	}
 
@@ -262,11 +401,13 @@ func HandleGetNodeDef(c *gin.Context) {
 	if authenticatedUserID == "internal" {
 		logger.Slog.Info("Internal service access granted for fetching node definition", "node_id", nodeID)
 	} else {
-		// 🔹 Enforce creator verification for non-internal users
-		if creatorID != authenticatedUserID {
-			logger.Slog.Error("User spoofing attempt detected", "authenticated", authenticatedUserID, "creator", creatorID)
+		// 🔹 Enforce visibility for non-internal users
+		visibility, _ := nodeDef["visibility"].(string)
+		org, _ := nodeDef["org"].(string)
+		if !canViewNodeDef(authenticatedUserID, consumerGroups(c), creatorID, visibility, org) {
+			logger.Slog.Error("Access to node definition denied", "authenticated", authenticatedUserID, "creator", creatorID, "visibility", visibility)
 			metrics.StepCounter.WithLabelValues(path, "user_spoofing_attempt", "failure").Inc()
-			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied: You do not own this node definition"})
+			c.JSON(http.StatusForbidden, gin.H{"error": "Access denied: This node definition is not shared with you"})
 			return
 		}
 	}
@@ -276,7 +417,8 @@ func HandleGetNodeDef(c *gin.Context) {
 	c.JSON(http.StatusOK, nodeDef)
 }
 
-// HandleUpdateNodeDef updates an existing node definition by ID.
+// HandleUpdateNodeDef publishes a new version of an existing node definition.
+// Published versions are immutable, so agents pinned to them keep working.
 func HandleUpdateNodeDef(c *gin.Context) {
 	path := c.FullPath()
 	nodeID := c.Param("node_id")
@@ -305,8 +447,6 @@ func HandleUpdateNodeDef(c *gin.Context) {
 		return
 	}
 
-	input.Metadata.UpdatedAt = time.Now().UTC()
-
 	// 🔹 Allow internal services unrestricted access
 	var filter bson.M
 	if authenticatedUserID == "internal" {
@@ -315,30 +455,75 @@ func HandleUpdateNodeDef(c *gin.Context) {
 	} else {
 		// 🔹 Enforce ownership validation for non-internal users
 		filter = bson.M{"id": nodeID, "creator": authenticatedUserID}
-		input.Creator = authenticatedUserID // Force creator field to match authenticated user
 	}
 
-	update := bson.M{"$set": input}
-
-	// 🔹 Attempt to update the record
-	result, err := dbClient.UpdateRecord("nodeDefs", "nodes", filter, update)
-	if err != nil {
-		metrics.StepCounter.WithLabelValues(path, "db_update_error", "error").Inc()
-		logger.Slog.Error("Failed to update node definition", "node_id", nodeID, "error", err)
+	// 🔹 Load the version being updated
+	var existing []NodeDefinition
+	if err := dbClient.FindRecordsInto("nodeDefs", "nodes", filter, bson.M{"_id": 0}, &existing); err != nil {
+		metrics.StepCounter.WithLabelValues(path, "db_retrieval_error", "error").Inc()
+		logger.Slog.Error("Failed to retrieve node definition", "node_id", nodeID, "error", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update node definition"})
 		return
 	}
-
-	if result.MatchedCount == 0 {
+	if len(existing) == 0 {
 		metrics.StepCounter.WithLabelValues(path, "node_not_found_or_unauthorized", "error").Inc()
 		logger.Slog.Warn("Node definition not found or unauthorized update attempt", "node_id", nodeID, "user", authenticatedUserID)
 		c.JSON(http.StatusForbidden, gin.H{"error": "Node definition not found or unauthorized update attempt"})
 		return
 	}
+	previous := existing[0]
+
+	// 🔹 A new version belongs to the same type and owner
+	if input.Type == "" {
+		input.Type = previous.Type
+	}
+	if input.Type != previous.Type {
+		metrics.StepCounter.WithLabelValues(path, "invalid_type", "error").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "The type of a node definition cannot be changed"})
+		return
+	}
+	input.Creator = previous.Creator
+
+	// 🔹 New versions keep the visibility of the previous one unless it is changed
+	if input.Visibility == "" {
+		input.Visibility, input.Org = previous.Visibility, previous.Org
+	}
+	if err := normalizeVisibility(&input, authenticatedUserID, consumerGroups(c)); err != nil {
+		metrics.StepCounter.WithLabelValues(path, "invalid_visibility", "error").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// 🔹 Without a new version number, the patch version is bumped
+	previousVersion := previous.Version
+	if previousVersion == "" {
+		previousVersion = defaultNodeVersion
+	}
+	if input.Version == "" || input.Version == previousVersion {
+		v, err := parseFullVersion(previousVersion)
+		if err != nil {
+			v = semver{1, 0, 0}
+		}
+		v.Patch++
+		input.Version = v.String()
+	}
+	if _, err := parseFullVersion(input.Version); err != nil {
+		metrics.StepCounter.WithLabelValues(path, "invalid_version", "error").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	input.ID = uuid.New().String()
+	input.Metadata.CreatedAt = time.Now().UTC()
+	input.Metadata.UpdatedAt = time.Now().UTC()
+
+	if !publishNodeVersion(c, path, input) {
+		return
+	}
 
 	metrics.StepCounter.WithLabelValues(path, "update_success", "success").Inc()
-	logger.Slog.Info("Node definition updated successfully", "node_id", nodeID, "user", authenticatedUserID)
-	c.JSON(http.StatusOK, gin.H{"message": "Node definition updated successfully", "node_id": nodeID})
+	logger.Slog.Info("Node definition version published", "node_id", input.ID, "previous_node_id", nodeID, "version", input.Version, "user", authenticatedUserID)
+	c.JSON(http.StatusOK, gin.H{"message": "Node definition version published", "node_id": input.ID, "previous_node_id": nodeID, "version": input.Version})
 }
 
 // HandleDeleteNodeDef deletes a node definition by ID.
@@ -374,6 +559,30 @@ func HandleDeleteNodeDef(c *gin.Context) {
 		filter = bson.M{"id": nodeID, "creator": authenticatedUserID}
 	}
 
+	// 🔹 Refuse to delete a version that saved agents or agent versions still resolve to
+	var defs []NodeDefinition
+	if err := dbClient.FindRecordsInto("nodeDefs", "nodes", filter, bson.M{"_id": 0}, &defs); err != nil {
+		metrics.StepCounter.WithLabelValues(path, "db_retrieval_error", "error").Inc()
+		logger.Slog.Error("Failed to load node definition", "node_id", nodeID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete node definition"})
+		return
+	}
+	if len(defs) > 0 {
+		agentIDs, err := agentsPinningNodeVersion(defs[0])
+		if err != nil {
+			metrics.StepCounter.WithLabelValues(path, "db_retrieval_error", "error").Inc()
+			logger.Slog.Error("Failed to check agents using node definition", "node_id", nodeID, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete node definition"})
+			return
+		}
+		if len(agentIDs) > 0 {
+			metrics.StepCounter.WithLabelValues(path, "node_version_in_use", "error").Inc()
+			logger.Slog.Warn("Node definition version is used by agents", "node_id", nodeID, "agents", len(agentIDs))
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Version %s of %s is used by saved agents", defs[0].Version, defs[0].Type), "agent_ids": agentIDs})
+			return
+		}
+	}
+
 	// 🔹 Attempt to delete the record
 	deleteResult, err := dbClient.DeleteRecord("nodeDefs", "nodes", filter)
 	if err != nil {
@@ -395,6 +604,64 @@ func HandleDeleteNodeDef(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Node definition deleted successfully", "node_id": nodeID})
 }
 
+// agentsPinningNodeVersion returns the IDs of the agents whose latest or earlier
+// versions have a node that resolves to def and to no other published version
+// of its type.
+func agentsPinningNodeVersion(def NodeDefinition) ([]string, error) {
+	var others []NodeDefinition
+	if err := dbClient.FindRecordsInto("nodeDefs", "nodes", bson.M{"type": def.Type, "id": bson.M{"$ne": def.ID}}, bson.M{"_id": 0, "id": 1, "type": 1, "version": 1}, &others); err != nil {
+		return nil, err
+	}
+
+	pins := func(nodes []NodeInstance) bool {
+		for _, node := range collectNodeInstances(nodes) {
+			if splitBase(node.Type) != def.Type {
+				continue
+			}
+			if _, err := resolveNodeVersion(node.Type, []NodeDefinition{def}); err != nil {
+				continue
+			}
+			if _, err := resolveNodeVersion(node.Type, others); err != nil {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Nested foreach nodes are stored in parameters, so load every agent with a foreach node too
+	usesType := func(field string) bson.M {
+		return bson.M{"$or": bson.A{
+			bson.M{field: bson.M{"$regex": "^" + regexp.QuoteMeta(def.Type) + "(@|$)"}},
+			bson.M{field: "control.foreach"},
+		}}
+	}
+
+	seen := make(map[string]bool)
+	var agentIDs []string
+	var agents []Agent
+	if err := dbClient.FindRecordsInto("userAgents", "agents", usesType("nodes.type"), bson.M{"_id": 0, "id": 1, "nodes": 1}, &agents); err != nil {
+		return nil, err
+	}
+	for _, agent := range agents {
+		if !seen[agent.ID] && pins(agent.Nodes) {
+			seen[agent.ID] = true
+			agentIDs = append(agentIDs, agent.ID)
+		}
+	}
+
+	versions, err := findAgentVersions(usesType("agent.nodes.type"), bson.M{"_id": 0, "agent_id": 1, "agent.nodes": 1})
+	if err != nil {
+		return nil, err
+	}
+	for _, version := range versions {
+		if version.Agent != nil && !seen[version.AgentID] && pins(version.Agent.Nodes) {
+			seen[version.AgentID] = true
+			agentIDs = append(agentIDs, version.AgentID)
+		}
+	}
+	return agentIDs, nil
+}
+
 //-----------------------------------------------------------------------------
 // Agent Handlers
 //-----------------------------------------------------------------------------
@@ -442,8 +709,14 @@ func HandleCreateAgent(c *gin.Context) {
 		}
 	}
 
+	// 🔹 Reject graphs the executor would fail on
+	if !checkAgentGraph(c, path, input) {
+		return
+	}
+
 	// 🔹 Set metadata and generate ID
 	input.ID = uuid.New().String()
+	input.Version = 1
 	input.Metadata = Metadata{CreatedAt: time.Now().UTC(), UpdatedAt: time.Now().UTC()}
 
 	// 🔹 Insert the agent into the database
@@ -455,9 +728,17 @@ func HandleCreateAgent(c *gin.Context) {
 		return
 	}
 
+	// 🔹 Record the first immutable revision
+	if err := recordAgentVersion(input, nil, authenticatedUserID, "Initial version"); err != nil {
+		metrics.StepCounter.WithLabelValues(path, "db_insertion_error", "error").Inc()
+		logger.Slog.Error("Failed to record agent version", "agent_id", input.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record agent version"})
+		return
+	}
+
 	metrics.StepCounter.WithLabelValues(path, "create_success", "success").Inc()
 	logger.Slog.Info("Agent inserted successfully", "ID", input.ID, "creator", input.Creator)
-	c.JSON(http.StatusCreated, gin.H{"message": "Agent created", "agent_id": input.ID, "creator": input.Creator})
+	c.JSON(http.StatusCreated, gin.H{"message": "Agent created", "agent_id": input.ID, "creator": input.Creator, "version": input.Version})
 }
 
 // HandleGetAllAgents retrieves all agents.
@@ -492,15 +773,23 @@ func HandleGetAllAgents(c *gin.Context) {
 		filter = bson.M{"creator": authenticatedUserID}
 	}
 
+	// 🔹 Parse pagination, sorting and filters
+	query, err := parseListQuery(c, agentSortFields, "created_at", "metadata.createdat", "name")
+	if err != nil {
+		metrics.StepCounter.WithLabelValues(path, "invalid_query", "error").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if nodeType := c.Query("node_type"); nodeType != "" {
+		query.addFilter(prefixFilter("nodes.type", nodeType))
+	}
+
 	// 🔹 Define projection to limit returned fields
-	projection := bson.M{"creator": 1, "id": 1, "name": 1, "_id": 0}
+	projection := bson.M{"creator": 1, "id": 1, "name": 1, "metadata": 1, "_id": 0}
 
-	// 🔹 Retrieve records from MongoDB
-	agents, err := dbClient.FindRecordsWithProjection("userAgents", "agents", filter, projection)
-	if err != nil {
-		metrics.StepCounter.WithLabelValues(path, "db_retrieval_error", "error").Inc()
-		logger.Slog.Error("Failed to retrieve agents", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve agents"})
+	// 🔹 Retrieve one page of records from MongoDB
+	agents, ok := query.findPage(c, path, "userAgents", "agents", filter, projection)
+	if !ok {
 		return
 	}
 
@@ -544,7 +833,7 @@ func HandleGetAgent(c *gin.Context) {
 	}
 
 	// 🔹 Define projection to limit returned fields
-	projection := bson.M{"id": 1, "name": 1, "creator": 1, "description": 1, "nodes": 1, "edges": 1, "_id": 0}
+	projection := bson.M{"id": 1, "name": 1, "creator": 1, "description": 1, "nodes": 1, "edges": 1, "inputs": 1, "version": 1, "_id": 0}
 
 	// 🔹 Retrieve agent from MongoDB
 	agents, err := dbClient.FindRecordsWithProjection("userAgents", "agents", filter, projection)
@@ -597,33 +886,6 @@ func HandleUpdateAgent(c *gin.Context) {
 		return
 	}
 
-	// 🔹 Allow internal services unrestricted access
-	var filter bson.M
-	if authenticatedUserID == "internal" {
-		logger.Slog.Info("Internal service access granted for updating agent", "agent_id", agentID)
-		filter = bson.M{"id": agentID} // No ownership filtering for internal services
-	} else {
-		// 🔹 Enforce creator validation for non-internal users
-		filter = bson.M{"id": agentID, "creator": authenticatedUserID}
-
-		// 🔹 Verify the user is the creator of the agent
-		existingAgent, err := dbClient.FindRecordByID("userAgents", "agents", agentID)
-		if err != nil {
-			metrics.StepCounter.WithLabelValues(path, "db_retrieval_error", "error").Inc()
-			logger.Slog.Error("Failed to retrieve agent for update", "agent_id", agentID, "error", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve agent"})
-			return
-		}
-
-		// 🔹 If no matching agent is found, return 404
-		if existingAgent == nil || existingAgent["creator"] != authenticatedUserID {
-			metrics.StepCounter.WithLabelValues(path, "agent_not_found_or_unauthorized", "error").Inc()
-			logger.Slog.Warn("Agent not found or user does not have permission", "agent_id", agentID, "user_id", authenticatedUserID)
-			c.JSON(http.StatusNotFound, gin.H{"error": "Agent not found"})
-			return
-		}
-	}
-
 	// 🔹 Parse request body for update
 	var input Agent
 	if err := c.ShouldBindJSON(&input); err != nil {
@@ -633,31 +895,39 @@ func HandleUpdateAgent(c *gin.Context) {
 		return
 	}
 
-	// 🔹 Ensure the updated agent maintains the correct creator
-	input.Metadata.UpdatedAt = time.Now().UTC()
-	input.Creator = authenticatedUserID
+	// 🔹 Load the latest revision; non-internal users may only update their own agents
+	current, ok := loadOwnedAgent(c, path, agentID, authenticatedUserID)
+	if !ok {
+		return
+	}
 
-	update := bson.M{"$set": input}
+	// 🔹 Ensure each node has an alias
+	for i, node := range input.Nodes {
+		if node.Alias == "" {
+			logger.Slog.Warn("Missing alias in node, assigning default alias", "node_type", node.Type)
+			input.Nodes[i].Alias = fmt.Sprintf("node-%d", i) // Assign a default alias if missing
+		}
+	}
 
-	// 🔹 Attempt to update the agent in MongoDB
-	result, err := dbClient.UpdateRecord("userAgents", "agents", filter, update)
-	if err != nil {
-		metrics.StepCounter.WithLabelValues(path, "db_update_error", "error").Inc()
-		logger.Slog.Error("Failed to update agent", "agent_id", agentID, "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update agent"})
+	// 🔹 Reject graphs the executor would fail on
+	if !checkAgentGraph(c, path, input) {
 		return
 	}
 
-	if result.MatchedCount == 0 {
-		metrics.StepCounter.WithLabelValues(path, "agent_not_found", "error").Inc()
-		logger.Slog.Warn("Agent not found or user is unauthorized", "agent_id", agentID, "user_id", authenticatedUserID)
-		c.JSON(http.StatusNotFound, gin.H{"error": "Agent not found"})
+	// 🔹 Identity, owner and creation time are kept from the stored agent
+	input.ID = current.ID
+	input.Creator = current.Creator
+	input.Metadata = Metadata{CreatedAt: current.Metadata.CreatedAt, UpdatedAt: time.Now().UTC()}
+
+	// 🔹 Store the new revision and make it the latest
+	version, ok := saveAgentRevision(c, path, current, input, authenticatedUserID, "")
+	if !ok {
 		return
 	}
 
 	metrics.StepCounter.WithLabelValues(path, "update_success", "success").Inc()
-	logger.Slog.Info("Agent updated successfully", "agent_id", agentID, "creator", authenticatedUserID)
-	c.JSON(http.StatusOK, gin.H{"message": "Agent updated successfully", "agent_id": agentID})
+	logger.Slog.Info("Agent updated successfully", "agent_id", agentID, "user", authenticatedUserID, "version", version)
+	c.JSON(http.StatusOK, gin.H{"message": "Agent updated successfully", "agent_id": agentID, "version": version})
 }
 
 // HandleDeleteAgent deletes an agent by ID.
@@ -727,6 +997,11 @@ func HandleDeleteAgent(c *gin.Context) {
 		return
 	}
 
+	// 🔹 Remove the agent's version history
+	if _, err := dbClient.DeleteRecords("userAgents", "agentVersions", bson.M{"agent_id": agentID}); err != nil {
+		logger.Slog.Error("Failed to delete agent versions", "agent_id", agentID, "error", err)
+	}
+
 	metrics.StepCounter.WithLabelValues(path, "delete_success", "success").Inc()
 	logger.Slog.Info("Agent deleted successfully", "agent_id", agentID, "creator", authenticatedUserID)
 	c.JSON(http.StatusOK, gin.H{"message": "Agent deleted successfully", "agent_id": agentID})
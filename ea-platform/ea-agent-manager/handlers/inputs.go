@@ -0,0 +1,95 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+)
+
+//-----------------------------------------------------------------------------
+// Agent Inputs
+//-----------------------------------------------------------------------------
+
+// agentInputsAlias is the reserved alias under which job inputs are referenced,
+// e.g. {{inputs.topic}}. No node may use it.
+const agentInputsAlias = "inputs"
+
+// inputTypes are the declarable input types; an empty type accepts any value.
+var inputTypes = map[string]bool{
+	"": true, "string": true, "number": true, "integer": true,
+	"bool": true, "boolean": true, "object": true, "array": true,
+}
+
+// validateAgentInputs checks the declared inputs and that every {{inputs.key}}
+// placeholder, including those in foreach graphs, references a declared input.
+func validateAgentInputs(agent Agent) []ValidationError {
+	var errs []ValidationError
+
+	declared := make(map[string]bool, len(agent.Inputs))
+	for i, input := range agent.Inputs {
+		field := fmt.Sprintf("inputs[%d]", i)
+		switch {
+		case input.Key == "":
+			errs = append(errs, ValidationError{Field: field + ".key", Code: "invalid_input", Message: "input has no key"})
+			continue
+		case declared[input.Key]:
+			errs = append(errs, ValidationError{Field: field + ".key", Code: "duplicate_input", Message: fmt.Sprintf("input %q is declared more than once", input.Key)})
+			continue
+		}
+		declared[input.Key] = true
+
+		if !inputTypes[input.Type] {
+			errs = append(errs, ValidationError{Field: field + ".type", Code: "invalid_input", Message: fmt.Sprintf("input %q has unknown type %q", input.Key, input.Type)})
+			continue
+		}
+		if input.Default != nil {
+			if !inputTypeMatches(input.Type, input.Default) {
+				errs = append(errs, ValidationError{Field: field + ".default", Code: "invalid_input", Message: fmt.Sprintf("default of input %q does not have type %s", input.Key, input.Type)})
+			} else if len(input.Enum) > 0 && !enumContains(input.Enum, input.Default) {
+				errs = append(errs, ValidationError{Field: field + ".default", Code: "invalid_input", Message: fmt.Sprintf("default of input %q is not one of its enum values", input.Key)})
+			}
+		}
+	}
+
+	for i, node := range collectNodeInstances(agent.Nodes) {
+		for _, ref := range nodeReferences(node) {
+			if ref.expr == nil {
+				continue
+			}
+			for _, operand := range ref.expr.operands {
+				if operand.isLiteral || operand.alias != agentInputsAlias || len(operand.steps) == 0 || operand.steps[0].isIndex {
+					continue
+				}
+				if key := operand.steps[0].key; !declared[key] {
+					errs = append(errs, ValidationError{Node: nodeAlias(node, i), Field: ref.field, Code: "unknown_input", Message: fmt.Sprintf("placeholder {{%s}} references undeclared input %q", ref.text, key)})
+				}
+			}
+		}
+	}
+
+	return errs
+}
+
+// inputTypeMatches reports whether a JSON-decoded value has the declared input type.
+func inputTypeMatches(inputType string, value interface{}) bool {
+	switch inputType {
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f)
+	case "bool", "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	}
+	return true
+}
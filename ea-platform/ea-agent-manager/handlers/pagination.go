@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"ea-agent-manager/logger"
+	"ea-agent-manager/metrics"
+	"ea-agent-manager/mongo"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+//-----------------------------------------------------------------------------
+// List Pagination, Sorting & Filtering
+//-----------------------------------------------------------------------------
+
+// maxPageLimit caps the `limit` query parameter of list endpoints.
+const maxPageLimit = 500
+
+// defaultPageLimit is the page size of list requests without a `limit`.
+const defaultPageLimit = 100
+
+// nextCursorHeader carries the cursor of the next page; it is absent on the last page.
+const nextCursorHeader = "X-Next-Cursor"
+
+// Sort options of the list endpoints and the fields they sort on.
+var (
+	agentSortFields = map[string]string{
+		"created_at": "metadata.createdat",
+		"updated_at": "metadata.updatedat",
+		"name":       "name",
+	}
+	nodeDefSortFields = map[string]string{
+		"type":       "type",
+		"name":       "name",
+		"created_at": "metadata.createdat",
+		"updated_at": "metadata.updatedat",
+	}
+)
+
+// listQuery holds the parsed pagination, sort and filter query parameters of a list endpoint.
+type listQuery struct {
+	mongo.PageQuery
+	Filters []bson.M
+}
+
+// parseListQuery reads `limit`, `cursor`, `sort` and the created/name filters.
+// sortFields maps the public sort names to document fields; `-name` sorts descending.
+// Without a limit, pages hold defaultPageLimit records; clients that need every
+// record follow the next cursor header until it is absent.
+func parseListQuery(c *gin.Context, sortFields map[string]string, defaultSort string, createdField, nameField string) (listQuery, error) {
+	q := listQuery{PageQuery: mongo.PageQuery{Limit: defaultPageLimit}}
+
+	if limit := c.Query("limit"); limit != "" {
+		n, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || n < 1 || n > maxPageLimit {
+			return q, fmt.Errorf("limit must be between 1 and %d", maxPageLimit)
+		}
+		q.Limit = n
+	}
+	q.Cursor = c.Query("cursor")
+
+	sort := c.DefaultQuery("sort", defaultSort)
+	name := strings.TrimPrefix(sort, "-")
+	field, ok := sortFields[name]
+	if !ok {
+		return q, fmt.Errorf("invalid sort %q", sort)
+	}
+	q.SortField = field
+	q.Descending = strings.HasPrefix(sort, "-")
+
+	// 🔹 Created range, as RFC 3339 timestamps
+	created := bson.M{}
+	for param, op := range map[string]string{"created_after": "$gte", "created_before": "$lt"} {
+		value := c.Query(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return q, fmt.Errorf("%s must be an RFC 3339 timestamp", param)
+		}
+		created[op] = t.UTC()
+	}
+	if len(created) > 0 {
+		q.Filters = append(q.Filters, bson.M{createdField: created})
+	}
+
+	// 🔹 Case-insensitive name search
+	if name := strings.TrimSpace(c.Query("name")); name != "" {
+		q.Filters = append(q.Filters, bson.M{nameField: bson.M{"$regex": regexp.QuoteMeta(name), "$options": "i"}})
+	}
+
+	return q, nil
+}
+
+// addFilter adds a condition that every listed record must match.
+func (q *listQuery) addFilter(filter bson.M) {
+	q.Filters = append(q.Filters, filter)
+}
+
+// findPage runs the query on top of base and writes the next cursor header.
+// It writes the error response and returns false on failure.
+func (q listQuery) findPage(c *gin.Context, path, database, collection string, base, projection bson.M) ([]map[string]interface{}, bool) {
+	q.Filter = base
+	if len(q.Filters) > 0 {
+		conditions := bson.A{base}
+		for _, filter := range q.Filters {
+			conditions = append(conditions, filter)
+		}
+		q.Filter = bson.M{"$and": conditions}
+	}
+
+	// Cursors are built from the sort field and id, so both must be returned
+	q.Projection = bson.M{"id": 1}
+	for key, value := range projection {
+		q.Projection[key] = value
+	}
+	if !projectionIncludes(q.Projection, q.SortField) {
+		q.Projection[q.SortField] = 1
+	}
+
+	records, next, err := dbClient.FindPage(database, collection, q.PageQuery)
+	if err == mongo.ErrInvalidCursor {
+		metrics.StepCounter.WithLabelValues(path, "invalid_cursor", "error").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+		return nil, false
+	}
+	if err != nil {
+		metrics.StepCounter.WithLabelValues(path, "db_retrieval_error", "error").Inc()
+		logger.Slog.Error("Failed to retrieve page", "collection", collection, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve " + collection})
+		return nil, false
+	}
+
+	// Browsers only let the front end read the cursor if it is exposed
+	c.Header("Access-Control-Expose-Headers", nextCursorHeader)
+	if next != "" {
+		c.Header(nextCursorHeader, next)
+	}
+	return records, true
+}
+
+// projectionIncludes reports whether field or one of its parents is projected.
+// Projecting both "metadata" and "metadata.createdat" is a path collision in MongoDB.
+func projectionIncludes(projection bson.M, field string) bool {
+	for key := range projection {
+		if key == field || strings.HasPrefix(field, key+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// prefixFilter matches string fields that start with prefix.
+func prefixFilter(field, prefix string) bson.M {
+	return bson.M{field: bson.M{"$regex": "^" + regexp.QuoteMeta(prefix)}}
+}
@@ -0,0 +1,543 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"ea-agent-manager/logger"
+	"ea-agent-manager/metrics"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+//-----------------------------------------------------------------------------
+// Agent Graph Validation
+//-----------------------------------------------------------------------------
+
+// ValidationError describes one problem found in an agent graph. Node is the
+// alias of the offending node, empty for graph-level problems.
+type ValidationError struct {
+	Node    string `json:"node,omitempty"`
+	Field   string `json:"field,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ValidationResult is the outcome of validating an agent graph.
+type ValidationResult struct {
+	Valid  bool              `json:"valid"`
+	Errors []ValidationError `json:"errors"`
+}
+
+// builtinNodeTypes are executed by the job executor itself and have no node definition.
+var builtinNodeTypes = map[string]bool{
+	"control.condition":  true,
+	"control.foreach":    true,
+	"transform.template": true,
+	"transform.jsonpath": true,
+	"transform.map":      true,
+	"transform.coerce":   true,
+}
+
+// builtinNodeTypePrefixes are type prefixes the job executor runs without a definition.
+var builtinNodeTypePrefixes = []string{"internal."}
+
+// placeholderRegex matches {{ ... }} references, as the job executor does.
+var placeholderRegex = regexp.MustCompile(`{{\s*([^{}]+?)\s*}}`)
+
+// HandleValidateAgent validates an agent graph without storing it.
+func HandleValidateAgent(c *gin.Context) {
+	var input Agent
+	path := c.FullPath()
+
+	metrics.StepCounter.WithLabelValues(path, "api_request_start", "success").Inc()
+
+	// 🔹 Extract authenticated user ID from Kong's `X-Consumer-Username` header
+	authenticatedUserID := c.GetHeader("X-Consumer-Username")
+	if authenticatedUserID == "" {
+		logger.Slog.Error("Missing X-Consumer-Username header")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// 🔹 Parse request body
+	if err := c.ShouldBindJSON(&input); err != nil {
+		metrics.StepCounter.WithLabelValues(path, "decode_error", "error").Inc()
+		logger.Slog.Error("Failed to parse request body", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to parse request body"})
+		return
+	}
+
+	viewer, groups := agentViewer(c, input)
+	result, err := validateAgent(input, viewer, groups)
+	if err != nil {
+		metrics.StepCounter.WithLabelValues(path, "db_retrieval_error", "error").Inc()
+		logger.Slog.Error("Failed to load node definitions for validation", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load node definitions"})
+		return
+	}
+
+	if !result.Valid {
+		metrics.StepCounter.WithLabelValues(path, "validation_failed", "success").Inc()
+	} else {
+		metrics.StepCounter.WithLabelValues(path, "validation_passed", "success").Inc()
+	}
+	logger.Slog.Info("Agent validated", "user", authenticatedUserID, "valid", result.Valid, "errors", len(result.Errors))
+	c.JSON(http.StatusOK, result)
+}
+
+// checkAgentGraph validates an agent before it is stored and writes the error
+// response if it is invalid. It returns true when the agent may be stored.
+func checkAgentGraph(c *gin.Context, path string, agent Agent) bool {
+	viewer, groups := agentViewer(c, agent)
+	result, err := validateAgent(agent, viewer, groups)
+	if err != nil {
+		metrics.StepCounter.WithLabelValues(path, "db_retrieval_error", "error").Inc()
+		logger.Slog.Error("Failed to load node definitions for validation", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load node definitions"})
+		return false
+	}
+	if !result.Valid {
+		metrics.StepCounter.WithLabelValues(path, "validation_error", "error").Inc()
+		logger.Slog.Warn("Agent graph is invalid", "agent_id", agent.ID, "errors", len(result.Errors))
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": "Agent graph is invalid", "errors": result.Errors})
+		return false
+	}
+	return true
+}
+
+// agentViewer returns the user and orgs whose node definitions an agent may
+// use: its creator, with the caller's orgs when the caller is the creator. The
+// job executor applies the same rule when the agent runs.
+func agentViewer(c *gin.Context, agent Agent) (string, []string) {
+	caller := c.GetHeader("X-Consumer-Username")
+	if agent.Creator == "" || agent.Creator == caller {
+		return caller, consumerGroups(c)
+	}
+	return agent.Creator, nil
+}
+
+// validateAgent loads the node definitions referenced by the agent that the
+// viewer can see and checks its graph. extra definitions, such as those of an
+// import, count as published.
+func validateAgent(agent Agent, viewer string, groups []string, extra ...NodeDefinition) (ValidationResult, error) {
+	types := make(map[string]bool)
+	collectNodeTypes(agent.Nodes, types)
+
+	typeList := make([]string, 0, len(types))
+	for nodeType := range types {
+		typeList = append(typeList, nodeType)
+	}
+
+	var defs []NodeDefinition
+	if len(typeList) > 0 {
+		filter := bson.M{"$and": bson.A{
+			nodeVisibilityFilter(viewer, groups),
+			bson.M{"type": bson.M{"$in": typeList}},
+		}}
+		if err := dbClient.FindRecordsInto("nodeDefs", "nodes", filter, nil, &defs); err != nil {
+			return ValidationResult{}, err
+		}
+	}
+
+	// All published versions of each type; the constraint of a node picks one
+	nodeDefs := make(map[string][]NodeDefinition, len(defs))
+	for _, def := range append(defs, extra...) {
+		nodeDefs[def.Type] = append(nodeDefs[def.Type], def)
+	}
+
+	// Job inputs are visible from every graph level under the reserved alias
+	errs := validateGraph(agent.Nodes, agent.Edges, nodeDefs, map[string]bool{agentInputsAlias: true})
+	errs = append(errs, validateAgentInputs(agent)...)
+	return ValidationResult{Valid: len(errs) == 0, Errors: errs}, nil
+}
+
+// collectNodeTypes gathers the node types used by nodes, including nested foreach nodes.
+func collectNodeTypes(nodes []NodeInstance, types map[string]bool) {
+	for _, node := range nodes {
+		types[splitBase(node.Type)] = true
+		if node.Type == "control.foreach" {
+			if nested, _, err := foreachGraph(node); err == nil {
+				collectNodeTypes(nested, types)
+			}
+		}
+	}
+}
+
+// validateGraph checks one graph level. outer holds the aliases visible from an
+// enclosing graph, or the job inputs at the top level.
+func validateGraph(nodes []NodeInstance, edges []Edge, nodeDefs map[string][]NodeDefinition, outer map[string]bool) []ValidationError {
+	errs := []ValidationError{}
+
+	// 🔹 Aliases must be unique; missing aliases get the default the executor assigns
+	aliases := make(map[string]bool, len(nodes))
+	for i, node := range nodes {
+		alias := nodeAlias(node, i)
+		if aliases[alias] {
+			errs = append(errs, ValidationError{Node: alias, Field: "alias", Code: "duplicate_alias", Message: fmt.Sprintf("alias %q is used by more than one node", alias)})
+		}
+		if alias == agentInputsAlias {
+			errs = append(errs, ValidationError{Node: alias, Field: "alias", Code: "reserved_alias", Message: fmt.Sprintf("alias %q is reserved for job inputs", alias)})
+		}
+		aliases[alias] = true
+	}
+
+	visible := make(map[string]bool, len(aliases)+len(outer))
+	for alias := range outer {
+		visible[alias] = true
+	}
+	for alias := range aliases {
+		visible[alias] = true
+	}
+
+	// 🔹 Explicit edges must connect existing nodes
+	deps := make(map[string]map[string]bool, len(nodes))
+	addDep := func(from, to string) {
+		if deps[to] == nil {
+			deps[to] = make(map[string]bool)
+		}
+		deps[to][from] = true
+	}
+	for i, edge := range edges {
+		for _, from := range edge.From {
+			if !aliases[from] {
+				errs = append(errs, ValidationError{Field: fmt.Sprintf("edges[%d].from", i), Code: "unknown_alias", Message: fmt.Sprintf("edge references unknown node %q", from)})
+			}
+		}
+		for _, to := range edge.To {
+			if !aliases[to] {
+				errs = append(errs, ValidationError{Field: fmt.Sprintf("edges[%d].to", i), Code: "unknown_alias", Message: fmt.Sprintf("edge references unknown node %q", to)})
+			}
+		}
+		for _, from := range edge.From {
+			for _, to := range edge.To {
+				if aliases[from] && aliases[to] {
+					addDep(from, to)
+				}
+			}
+		}
+	}
+
+	for i, node := range nodes {
+		alias := nodeAlias(node, i)
+
+		// 🔹 Resolve the node type and version constraint
+		baseType := splitBase(node.Type)
+		versions, hasDef := nodeDefs[baseType]
+		switch {
+		case baseType == "":
+			errs = append(errs, ValidationError{Node: alias, Field: "type", Code: "missing_type", Message: "node has no type"})
+		case hasDef:
+			def, err := resolveNodeVersion(node.Type, versions)
+			if err != nil {
+				errs = append(errs, ValidationError{Node: alias, Field: "type", Code: "unknown_version", Message: err.Error()})
+				break
+			}
+			errs = append(errs, validateNodeParameters(alias, node, def)...)
+		case !isBuiltinNodeType(baseType):
+			errs = append(errs, ValidationError{Node: alias, Field: "type", Code: "unknown_type", Message: fmt.Sprintf("node type %q has no node definition", baseType)})
+		}
+
+		// 🔹 Placeholders must parse and reference visible aliases; each one is an implicit edge
+		for _, ref := range nodeReferences(node) {
+			for _, refAlias := range ref.aliases {
+				if !visible[refAlias] {
+					errs = append(errs, ValidationError{Node: alias, Field: ref.field, Code: "unknown_reference", Message: fmt.Sprintf("placeholder {{%s}} references unknown node %q", ref.text, refAlias)})
+				} else if aliases[refAlias] && refAlias != alias {
+					addDep(refAlias, alias)
+				} else if refAlias == alias {
+					errs = append(errs, ValidationError{Node: alias, Field: ref.field, Code: "self_reference", Message: fmt.Sprintf("placeholder {{%s}} references the node itself", ref.text)})
+				}
+			}
+			if ref.err != "" {
+				errs = append(errs, ValidationError{Node: alias, Field: ref.field, Code: "invalid_placeholder", Message: ref.err})
+			}
+		}
+
+		switch node.Type {
+		case "control.condition":
+			// 🔹 Branch targets must exist and run after the condition
+			for _, branch := range []string{"then", "else"} {
+				targets, err := aliasParameter(node.Parameters[branch])
+				if err != nil {
+					errs = append(errs, ValidationError{Node: alias, Field: "parameters." + branch, Code: "invalid_parameter", Message: err.Error()})
+					continue
+				}
+				for _, target := range targets {
+					if !aliases[target] {
+						errs = append(errs, ValidationError{Node: alias, Field: "parameters." + branch, Code: "unknown_alias", Message: fmt.Sprintf("branch target %q does not exist", target)})
+						continue
+					}
+					addDep(alias, target)
+				}
+			}
+		case "control.foreach":
+			// 🔹 Validate the nested graph with the iteration variable and outer aliases in scope
+			nested, nestedEdges, err := foreachGraph(node)
+			if err != nil {
+				errs = append(errs, ValidationError{Node: alias, Field: "parameters.nodes", Code: "invalid_parameter", Message: err.Error()})
+				break
+			}
+			scope := make(map[string]bool, len(visible)+1)
+			for a := range visible {
+				scope[a] = true
+			}
+			as, _ := node.Parameters["as"].(string)
+			if as == "" {
+				as = "item"
+			}
+			scope[as] = true
+			for _, nestedErr := range validateGraph(nested, nestedEdges, nodeDefs, scope) {
+				if nestedErr.Node != "" {
+					nestedErr.Node = alias + "." + nestedErr.Node
+				} else {
+					nestedErr.Node = alias
+					nestedErr.Field = strings.TrimSuffix("parameters."+nestedErr.Field, ".")
+				}
+				errs = append(errs, nestedErr)
+			}
+			// References from nested nodes to this graph are dependencies of the foreach node
+			for _, nestedNode := range nested {
+				for _, ref := range nodeReferences(nestedNode) {
+					for _, refAlias := range ref.aliases {
+						if aliases[refAlias] && refAlias != alias {
+							addDep(refAlias, alias)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	// 🔹 The combined explicit, implicit and branch edges must form a DAG
+	if cycle := findCycle(nodes, deps); len(cycle) > 0 {
+		errs = append(errs, ValidationError{Code: "cycle", Message: fmt.Sprintf("graph contains a cycle: %s", strings.Join(cycle, " -> "))})
+	}
+
+	return errs
+}
+
+// validateNodeParameters checks required parameters and enum values against the definition.
+func validateNodeParameters(alias string, node NodeInstance, def NodeDefinition) []ValidationError {
+	var errs []ValidationError
+	for _, param := range def.Parameters {
+		value, present := node.Parameters[param.Key]
+		if !present || value == nil || value == "" {
+			if param.Required {
+				errs = append(errs, ValidationError{Node: alias, Field: "parameters." + param.Key, Code: "missing_parameter", Message: fmt.Sprintf("required parameter %q is missing", param.Key)})
+			}
+			continue
+		}
+
+		// Placeholder values are only known at runtime
+		if s, ok := value.(string); ok && placeholderRegex.MatchString(s) {
+			continue
+		}
+		if len(param.Enum) > 0 && !enumContains(param.Enum, value) {
+			errs = append(errs, ValidationError{Node: alias, Field: "parameters." + param.Key, Code: "invalid_enum", Message: fmt.Sprintf("value %v is not one of %v", value, param.Enum)})
+		}
+	}
+	return errs
+}
+
+// enumContains compares values by their JSON encoding, so 1 and 1.0 match.
+func enumContains(enum []interface{}, value interface{}) bool {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return false
+	}
+	for _, option := range enum {
+		if optionJSON, err := json.Marshal(option); err == nil && string(optionJSON) == string(encoded) {
+			return true
+		}
+	}
+	return false
+}
+
+// placeholderRef is a {{ }} placeholder found in a node's parameters.
+type placeholderRef struct {
+	field   string
+	text    string
+	expr    *refExpr // Parsed reference; nil when err is set
+	aliases []string
+	err     string
+}
+
+// nodeReferences returns the placeholders in a node's parameters. The raw
+// template of transform.template and the nested graph of control.foreach are
+// not placeholders of the node itself.
+func nodeReferences(node NodeInstance) []placeholderRef {
+	var refs []placeholderRef
+	keys := make([]string, 0, len(node.Parameters))
+	for key := range node.Parameters {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if (node.Type == "transform.template" && key == "template") || (node.Type == "control.foreach" && (key == "nodes" || key == "edges")) {
+			continue
+		}
+		collectReferences("parameters."+key, node.Parameters[key], &refs)
+	}
+	return refs
+}
+
+func collectReferences(field string, value interface{}, refs *[]placeholderRef) {
+	switch v := value.(type) {
+	case string:
+		if strings.Count(v, "{{") != strings.Count(v, "}}") {
+			*refs = append(*refs, placeholderRef{field: field, text: v, err: fmt.Sprintf("unbalanced braces in %q", v)})
+		}
+		for _, match := range placeholderRegex.FindAllStringSubmatch(v, -1) {
+			ref := placeholderRef{field: field, text: match[1]}
+			expr, err := parseReference(match[1])
+			if err != nil {
+				ref.err = fmt.Sprintf("invalid placeholder {{%s}}: %v", match[1], err)
+			} else {
+				ref.expr, ref.aliases = expr, expr.aliases()
+			}
+			*refs = append(*refs, ref)
+		}
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			collectReferences(field+"."+key, v[key], refs)
+		}
+	case []interface{}:
+		for i, item := range v {
+			collectReferences(fmt.Sprintf("%s[%d]", field, i), item, refs)
+		}
+	}
+}
+
+// findCycle returns the aliases of a dependency cycle, or nil if there is none.
+// deps maps each alias to the aliases it depends on.
+func findCycle(nodes []NodeInstance, deps map[string]map[string]bool) []string {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	state := make(map[string]int)
+	var stack []string
+	var cycle []string
+
+	var visit func(alias string) bool
+	visit = func(alias string) bool {
+		state[alias] = visiting
+		stack = append(stack, alias)
+
+		next := make([]string, 0, len(deps[alias]))
+		for dep := range deps[alias] {
+			next = append(next, dep)
+		}
+		sort.Strings(next)
+
+		for _, dep := range next {
+			switch state[dep] {
+			case visiting:
+				// The stack runs against dependencies, so walk it backwards for execution order
+				for i := len(stack) - 1; i >= 0; i-- {
+					cycle = append(cycle, stack[i])
+					if stack[i] == dep {
+						break
+					}
+				}
+				cycle = append(cycle, cycle[0])
+				return true
+			case unvisited:
+				if visit(dep) {
+					return true
+				}
+			}
+		}
+
+		stack = stack[:len(stack)-1]
+		state[alias] = done
+		return false
+	}
+
+	for i, node := range nodes {
+		alias := nodeAlias(node, i)
+		if state[alias] == unvisited && visit(alias) {
+			return cycle
+		}
+	}
+	return nil
+}
+
+// foreachGraph decodes the nested "nodes"/"edges" parameters of a foreach node.
+func foreachGraph(node NodeInstance) ([]NodeInstance, []Edge, error) {
+	nestedJSON, err := json.Marshal(map[string]interface{}{
+		"nodes": node.Parameters["nodes"],
+		"edges": node.Parameters["edges"],
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	var nested struct {
+		Nodes []NodeInstance `json:"nodes"`
+		Edges []Edge         `json:"edges"`
+	}
+	if err := json.Unmarshal(nestedJSON, &nested); err != nil {
+		return nil, nil, fmt.Errorf("invalid nested graph: %w", err)
+	}
+	if len(nested.Nodes) == 0 {
+		return nil, nil, fmt.Errorf("foreach node has no nested nodes")
+	}
+	return nested.Nodes, nested.Edges, nil
+}
+
+// aliasParameter reads a parameter holding an alias or a list of aliases.
+func aliasParameter(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if v == "" {
+			return nil, nil
+		}
+		return []string{v}, nil
+	case []interface{}:
+		aliases := make([]string, 0, len(v))
+		for _, item := range v {
+			alias, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected alias string, got %T", item)
+			}
+			aliases = append(aliases, alias)
+		}
+		return aliases, nil
+	}
+	return nil, fmt.Errorf("expected alias or list of aliases, got %T", value)
+}
+
+// nodeAlias returns the alias of the i-th node, defaulting as on create.
+func nodeAlias(node NodeInstance, i int) string {
+	if node.Alias == "" {
+		return fmt.Sprintf("node-%d", i)
+	}
+	return node.Alias
+}
+
+func isBuiltinNodeType(nodeType string) bool {
+	if builtinNodeTypes[nodeType] {
+		return true
+	}
+	for _, prefix := range builtinNodeTypePrefixes {
+		if strings.HasPrefix(nodeType, prefix) {
+			return true
+		}
+	}
+	return false
+}
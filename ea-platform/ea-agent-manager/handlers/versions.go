@@ -0,0 +1,406 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ea-agent-manager/logger"
+	"ea-agent-manager/metrics"
+	"ea-agent-manager/mongo"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+//-----------------------------------------------------------------------------
+// Agent Versions
+//-----------------------------------------------------------------------------
+
+// Every create, update and rollback of an agent stores an immutable snapshot in
+// the agentVersions collection. The agent document itself always holds the
+// latest revision and its version number.
+
+// AgentVersion is an immutable revision of an agent.
+type AgentVersion struct {
+	AgentID   string    `json:"agent_id" bson:"agent_id"`
+	Version   int       `json:"version" bson:"version"`
+	Author    string    `json:"author" bson:"author"`
+	CreatedAt time.Time `json:"created_at" bson:"created_at"`
+	Summary   string    `json:"summary" bson:"summary"`
+	Diff      AgentDiff `json:"diff" bson:"diff"`
+	Agent     *Agent    `json:"agent,omitempty" bson:"agent,omitempty"`
+}
+
+// AgentDiff summarizes what changed compared to the previous version.
+type AgentDiff struct {
+	NodesAdded   []string `json:"nodes_added,omitempty" bson:"nodes_added,omitempty"`
+	NodesRemoved []string `json:"nodes_removed,omitempty" bson:"nodes_removed,omitempty"`
+	NodesChanged []string `json:"nodes_changed,omitempty" bson:"nodes_changed,omitempty"`
+	EdgesAdded   int      `json:"edges_added,omitempty" bson:"edges_added,omitempty"`
+	EdgesRemoved int      `json:"edges_removed,omitempty" bson:"edges_removed,omitempty"`
+	Fields       []string `json:"fields,omitempty" bson:"fields,omitempty"` // Changed top-level fields such as name or description
+}
+
+// RollbackRequest is the body of POST /agents/:agent_id/rollback.
+type RollbackRequest struct {
+	Version int `json:"version"`
+}
+
+// HandleGetAgentVersions lists the versions of an agent, newest first, without snapshots.
+func HandleGetAgentVersions(c *gin.Context) {
+	path := c.FullPath()
+	agentID := c.Param("agent_id")
+	metrics.StepCounter.WithLabelValues(path, "api_hit", "success").Inc()
+
+	// 🔹 Extract authenticated user ID from Kong's `X-Consumer-Username` header
+	authenticatedUserID := c.GetHeader("X-Consumer-Username")
+	if authenticatedUserID == "" {
+		logger.Slog.Error("Missing X-Consumer-Username header")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	if _, ok := loadOwnedAgent(c, path, agentID, authenticatedUserID); !ok {
+		return
+	}
+
+	// 🔹 Retrieve the versions, omitting the snapshots
+	versions, err := findAgentVersions(bson.M{"agent_id": agentID}, bson.M{"agent": 0, "_id": 0})
+	if err != nil {
+		metrics.StepCounter.WithLabelValues(path, "db_retrieval_error", "error").Inc()
+		logger.Slog.Error("Failed to retrieve agent versions", "agent_id", agentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve agent versions"})
+		return
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version > versions[j].Version })
+
+	metrics.StepCounter.WithLabelValues(path, "retrieval_success", "success").Inc()
+	logger.Slog.Info("Agent versions retrieved successfully", "agent_id", agentID, "count", len(versions))
+	c.JSON(http.StatusOK, versions)
+}
+
+// HandleGetAgentVersion retrieves one version of an agent, including its snapshot.
+func HandleGetAgentVersion(c *gin.Context) {
+	path := c.FullPath()
+	agentID := c.Param("agent_id")
+	metrics.StepCounter.WithLabelValues(path, "api_hit", "success").Inc()
+
+	// 🔹 Extract authenticated user ID from Kong's `X-Consumer-Username` header
+	authenticatedUserID := c.GetHeader("X-Consumer-Username")
+	if authenticatedUserID == "" {
+		logger.Slog.Error("Missing X-Consumer-Username header")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	version, err := strconv.Atoi(c.Param("version"))
+	if err != nil || version < 1 {
+		metrics.StepCounter.WithLabelValues(path, "invalid_version", "error").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Version must be a positive integer"})
+		return
+	}
+
+	if _, ok := loadOwnedAgent(c, path, agentID, authenticatedUserID); !ok {
+		return
+	}
+
+	agentVersion, ok := loadAgentVersion(c, path, agentID, version)
+	if !ok {
+		return
+	}
+
+	metrics.StepCounter.WithLabelValues(path, "retrieval_success", "success").Inc()
+	logger.Slog.Info("Agent version retrieved successfully", "agent_id", agentID, "version", version)
+	c.JSON(http.StatusOK, agentVersion)
+}
+
+// HandleRollbackAgent restores the graph of an earlier version as a new version.
+func HandleRollbackAgent(c *gin.Context) {
+	path := c.FullPath()
+	agentID := c.Param("agent_id")
+	metrics.StepCounter.WithLabelValues(path, "api_request_start", "success").Inc()
+
+	// 🔹 Extract authenticated user ID from Kong's `X-Consumer-Username` header
+	authenticatedUserID := c.GetHeader("X-Consumer-Username")
+	if authenticatedUserID == "" {
+		logger.Slog.Error("Missing X-Consumer-Username header")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req RollbackRequest
+	if err := c.ShouldBindJSON(&req); err != nil || req.Version < 1 {
+		metrics.StepCounter.WithLabelValues(path, "decode_error", "error").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Request body must contain a positive 'version'"})
+		return
+	}
+
+	current, ok := loadOwnedAgent(c, path, agentID, authenticatedUserID)
+	if !ok {
+		return
+	}
+
+	target, ok := loadAgentVersion(c, path, agentID, req.Version)
+	if !ok {
+		return
+	}
+	if target.Agent == nil {
+		logger.Slog.Error("Agent version has no snapshot", "agent_id", agentID, "version", req.Version)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Agent version has no snapshot"})
+		return
+	}
+
+	// 🔹 The restored revision keeps the agent's identity and owner
+	restored := *target.Agent
+	restored.ID = current.ID
+	restored.Creator = current.Creator
+	restored.Metadata = Metadata{CreatedAt: current.Metadata.CreatedAt, UpdatedAt: time.Now().UTC()}
+
+	// 🔹 Node definitions may have changed since; validate like any update
+	if !checkAgentGraph(c, path, restored) {
+		return
+	}
+
+	newVersion, ok := saveAgentRevision(c, path, current, restored, authenticatedUserID, fmt.Sprintf("Rolled back to version %d", req.Version))
+	if !ok {
+		return
+	}
+
+	metrics.StepCounter.WithLabelValues(path, "rollback_success", "success").Inc()
+	logger.Slog.Info("Agent rolled back successfully", "agent_id", agentID, "to_version", req.Version, "new_version", newVersion)
+	c.JSON(http.StatusOK, gin.H{"message": "Agent rolled back successfully", "agent_id": agentID, "version": newVersion, "restored_version": req.Version})
+}
+
+//-----------------------------------------------------------------------------
+// Version Helpers
+//-----------------------------------------------------------------------------
+
+// loadOwnedAgent retrieves the latest revision of an agent the user may access
+// and writes the error response if there is none.
+func loadOwnedAgent(c *gin.Context, path string, agentID string, authenticatedUserID string) (Agent, bool) {
+	filter := bson.M{"id": agentID}
+	if authenticatedUserID != "internal" {
+		filter["creator"] = authenticatedUserID
+	}
+
+	var agents []Agent
+	if err := dbClient.FindRecordsInto("userAgents", "agents", filter, bson.M{"_id": 0}, &agents); err != nil {
+		metrics.StepCounter.WithLabelValues(path, "db_retrieval_error", "error").Inc()
+		logger.Slog.Error("Failed to retrieve agent", "agent_id", agentID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve agent"})
+		return Agent{}, false
+	}
+	if len(agents) == 0 {
+		metrics.StepCounter.WithLabelValues(path, "agent_not_found", "error").Inc()
+		logger.Slog.Warn("Agent not found or user does not have access", "agent_id", agentID, "user_id", authenticatedUserID)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Agent not found"})
+		return Agent{}, false
+	}
+	return agents[0], true
+}
+
+// loadAgentVersion retrieves one version of an agent and writes the error
+// response if it does not exist.
+func loadAgentVersion(c *gin.Context, path string, agentID string, version int) (AgentVersion, bool) {
+	versions, err := findAgentVersions(bson.M{"agent_id": agentID, "version": version}, bson.M{"_id": 0})
+	if err != nil {
+		metrics.StepCounter.WithLabelValues(path, "db_retrieval_error", "error").Inc()
+		logger.Slog.Error("Failed to retrieve agent version", "agent_id", agentID, "version", version, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve agent version"})
+		return AgentVersion{}, false
+	}
+	if len(versions) == 0 {
+		metrics.StepCounter.WithLabelValues(path, "version_not_found", "error").Inc()
+		logger.Slog.Warn("Agent version not found", "agent_id", agentID, "version", version)
+		c.JSON(http.StatusNotFound, gin.H{"error": "Agent version not found"})
+		return AgentVersion{}, false
+	}
+	return versions[0], true
+}
+
+func findAgentVersions(filter, projection bson.M) ([]AgentVersion, error) {
+	versions := []AgentVersion{}
+	err := dbClient.FindRecordsInto("userAgents", "agentVersions", filter, projection, &versions)
+	return versions, err
+}
+
+// recordAgentVersion stores an immutable snapshot of agent as the given version.
+func recordAgentVersion(agent Agent, previous *Agent, author string, summary string) error {
+	snapshot := agent
+	diff := AgentDiff{}
+	if previous != nil {
+		diff = diffAgents(*previous, agent)
+	}
+	if summary == "" {
+		summary = diff.String()
+	} else if previous != nil {
+		summary = summary + ": " + diff.String()
+	}
+
+	_, err := dbClient.InsertRecord("userAgents", "agentVersions", AgentVersion{
+		AgentID:   agent.ID,
+		Version:   agent.Version,
+		Author:    author,
+		CreatedAt: time.Now().UTC(),
+		Summary:   summary,
+		Diff:      diff,
+		Agent:     &snapshot,
+	})
+	return err
+}
+
+// saveAgentRevision stores next as a new version of current and makes it the
+// latest revision. It writes the error response on failure and returns the new
+// version number.
+func saveAgentRevision(c *gin.Context, path string, current Agent, next Agent, author string, summary string) (int, bool) {
+	// 🔹 Agents created before versioning get their current state recorded as version 1
+	if current.Version == 0 {
+		current.Version = 1
+		if err := recordAgentVersion(current, nil, current.Creator, "Initial version"); err != nil && !mongo.IsDuplicateKey(err) {
+			metrics.StepCounter.WithLabelValues(path, "db_insertion_error", "error").Inc()
+			logger.Slog.Error("Failed to record initial agent version", "agent_id", current.ID, "error", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record agent version"})
+			return 0, false
+		}
+	}
+
+	next.Version = current.Version + 1
+	if err := recordAgentVersion(next, &current, author, summary); err != nil {
+		if mongo.IsDuplicateKey(err) {
+			metrics.StepCounter.WithLabelValues(path, "version_conflict", "error").Inc()
+			logger.Slog.Warn("Concurrent agent update detected", "agent_id", current.ID, "version", next.Version)
+			c.JSON(http.StatusConflict, gin.H{"error": "Agent was modified concurrently, please retry"})
+			return 0, false
+		}
+		metrics.StepCounter.WithLabelValues(path, "db_insertion_error", "error").Inc()
+		logger.Slog.Error("Failed to record agent version", "agent_id", current.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record agent version"})
+		return 0, false
+	}
+
+	result, err := dbClient.UpdateRecord("userAgents", "agents", bson.M{"id": current.ID}, bson.M{"$set": next})
+	if err != nil {
+		metrics.StepCounter.WithLabelValues(path, "db_update_error", "error").Inc()
+		logger.Slog.Error("Failed to update agent", "agent_id", current.ID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update agent"})
+		return 0, false
+	}
+	if result.MatchedCount == 0 {
+		metrics.StepCounter.WithLabelValues(path, "agent_not_found", "error").Inc()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Agent not found"})
+		return 0, false
+	}
+
+	return next.Version, true
+}
+
+// diffAgents compares two revisions of an agent by node alias and edge.
+func diffAgents(previous, next Agent) AgentDiff {
+	var diff AgentDiff
+
+	if previous.Name != next.Name {
+		diff.Fields = append(diff.Fields, "name")
+	}
+	if previous.Description != next.Description {
+		diff.Fields = append(diff.Fields, "description")
+	}
+	if !reflect.DeepEqual(normalizeValue(previous.Inputs), normalizeValue(next.Inputs)) {
+		diff.Fields = append(diff.Fields, "inputs")
+	}
+
+	oldNodes := make(map[string]NodeInstance, len(previous.Nodes))
+	for _, node := range previous.Nodes {
+		oldNodes[node.Alias] = node
+	}
+	newNodes := make(map[string]bool, len(next.Nodes))
+	for _, node := range next.Nodes {
+		newNodes[node.Alias] = true
+		old, existed := oldNodes[node.Alias]
+		switch {
+		case !existed:
+			diff.NodesAdded = append(diff.NodesAdded, node.Alias)
+		case old.Type != node.Type || !reflect.DeepEqual(normalizeValue(old.Parameters), normalizeValue(node.Parameters)) || !reflect.DeepEqual(normalizeValue(old.Retry), normalizeValue(node.Retry)):
+			diff.NodesChanged = append(diff.NodesChanged, node.Alias)
+		}
+	}
+	for _, node := range previous.Nodes {
+		if !newNodes[node.Alias] {
+			diff.NodesRemoved = append(diff.NodesRemoved, node.Alias)
+		}
+	}
+
+	oldEdges := edgeSet(previous.Edges)
+	newEdges := edgeSet(next.Edges)
+	for edge := range newEdges {
+		if !oldEdges[edge] {
+			diff.EdgesAdded++
+		}
+	}
+	for edge := range oldEdges {
+		if !newEdges[edge] {
+			diff.EdgesRemoved++
+		}
+	}
+
+	return diff
+}
+
+// String renders the diff as a one-line summary.
+func (d AgentDiff) String() string {
+	var parts []string
+	if len(d.Fields) > 0 {
+		parts = append(parts, "changed "+strings.Join(d.Fields, ", "))
+	}
+	if len(d.NodesAdded) > 0 {
+		parts = append(parts, "added nodes "+strings.Join(d.NodesAdded, ", "))
+	}
+	if len(d.NodesRemoved) > 0 {
+		parts = append(parts, "removed nodes "+strings.Join(d.NodesRemoved, ", "))
+	}
+	if len(d.NodesChanged) > 0 {
+		parts = append(parts, "changed nodes "+strings.Join(d.NodesChanged, ", "))
+	}
+	if d.EdgesAdded > 0 {
+		parts = append(parts, fmt.Sprintf("added %d edge(s)", d.EdgesAdded))
+	}
+	if d.EdgesRemoved > 0 {
+		parts = append(parts, fmt.Sprintf("removed %d edge(s)", d.EdgesRemoved))
+	}
+	if len(parts) == 0 {
+		return "no changes"
+	}
+	return strings.Join(parts, "; ")
+}
+
+// normalizeValue round-trips a value through JSON so that values decoded from
+// MongoDB compare equal to values decoded from a request.
+func normalizeValue(value interface{}) interface{} {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return value
+	}
+	var normalized interface{}
+	if err := json.Unmarshal(encoded, &normalized); err != nil {
+		return value
+	}
+	return normalized
+}
+
+// edgeSet expands edges into a set of "from->to" pairs.
+func edgeSet(edges []Edge) map[string]bool {
+	set := make(map[string]bool)
+	for _, edge := range edges {
+		for _, from := range edge.From {
+			for _, to := range edge.To {
+				set[from+"->"+to] = true
+			}
+		}
+	}
+	return set
+}
@@ -28,6 +28,17 @@ func main() {
 	// Set the initialized MongoDB client in handlers
 	handlers.SetDBClient(dbClient)
 
+	// Create the indexes the handlers rely on
+	if err := handlers.EnsureIndexes(); err != nil {
+		logger.Slog.Error("Failed to create MongoDB indexes", "error", err)
+		log.Fatalf("Failed to create MongoDB indexes: %v", err)
+	}
+
+	// Publish the bundled node presets as public definitions
+	if err := handlers.ImportNodePresets(config.NodePresetsDir); err != nil {
+		logger.Slog.Error("Failed to import node presets", "dir", config.NodePresetsDir, "error", err)
+	}
+
 	// Initialize Gin router
 	router := routes.RegisterRoutes()
 
@@ -26,20 +26,29 @@ func RegisterRoutes() *gin.Engine {
 	agents := router.Group("/api/v1/agents")
 	{
 		agents.POST("", handlers.HandleCreateAgent)             // Create new Agent
+		agents.POST("/validate", handlers.HandleValidateAgent)  // Validate an Agent graph without saving it
+		agents.POST("/import", handlers.HandleImportAgent)      // Create an Agent from an export bundle
 		agents.GET("", handlers.HandleGetAllAgents)             // List all Agents
 		agents.GET("/:agent_id", handlers.HandleGetAgent)       // Get Agent by ID
 		agents.PUT("/:agent_id", handlers.HandleUpdateAgent)    // Update Agent by ID
 		agents.DELETE("/:agent_id", handlers.HandleDeleteAgent) // Delete Agent by ID
+
+		agents.GET("/:agent_id/versions", handlers.HandleGetAgentVersions)         // List versions of an Agent
+		agents.GET("/:agent_id/versions/:version", handlers.HandleGetAgentVersion) // Get one version of an Agent
+		agents.POST("/:agent_id/rollback", handlers.HandleRollbackAgent)           // Restore an earlier version
+		agents.GET("/:agent_id/export", handlers.HandleExportAgent)                // Export an Agent with its node definitions
 	}
 
 	// Nodes routes
 	nodes := router.Group("/api/v1/nodes")
 	{
-		nodes.POST("", handlers.HandleCreateNodeDef)            // Create new node
-		nodes.GET("", handlers.HandleGetAllNodeDefs)            // List all nodes
-		nodes.GET("/:node_id", handlers.HandleGetNodeDef)       // Get node by ID
-		nodes.PUT("/:node_id", handlers.HandleUpdateNodeDef)    // Update Node Definition by ID
-		nodes.DELETE("/:node_id", handlers.HandleDeleteNodeDef) // Delete node by ID
+		nodes.POST("", handlers.HandleCreateNodeDef)              // Create new node
+		nodes.GET("", handlers.HandleGetAllNodeDefs)              // List all nodes
+		nodes.GET("/catalog", handlers.HandleSearchNodeCatalog)   // Search the shared node catalog
+		nodes.GET("/resolve", handlers.HandleResolveNodeVersions) // Resolve node types to published versions
+		nodes.GET("/:node_id", handlers.HandleGetNodeDef)         // Get node by ID
+		nodes.PUT("/:node_id", handlers.HandleUpdateNodeDef)      // Update Node Definition by ID
+		nodes.DELETE("/:node_id", handlers.HandleDeleteNodeDef)   // Delete node by ID
 	}
 
 	return router
@@ -51,6 +60,7 @@ func corsMiddleware() gin.HandlerFunc {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
 		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Writer.Header().Set("Access-Control-Expose-Headers", "X-Next-Cursor")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(200)
@@ -12,6 +12,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 // dbClient is the shared MongoDB client for handlers.
@@ -26,6 +27,20 @@ func SetDBClient(client *mongo.MongoClient) {
 	logger.Slog.Info("Database client successfully initialized in handlers")
 }
 
+// EnsureIndexes creates the indexes the handlers rely on.
+func EnsureIndexes() error {
+	// The user list pages through these sort orders
+	for _, keys := range []bson.D{
+		{{Key: "name", Value: 1}, {Key: "id", Value: 1}},
+		{{Key: "created_time", Value: 1}, {Key: "id", Value: 1}},
+	} {
+		if err := dbClient.CreateIndex("ainuUsers", "users", keys, options.Index()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // ---- MODELS ----
 
 type UserDefinition struct {
@@ -88,14 +103,19 @@ func HandleGetAllUsers(c *gin.Context) {
 		}
 	}
 
+	// 🔹 Parse pagination, sorting and filters
+	query, err := parseListQuery(c, userSortFields, "name", "created_time", "name")
+	if err != nil {
+		metrics.StepCounter.WithLabelValues(path, "invalid_query", "error").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	// 🔹 Define which fields to return
 	projection := bson.M{"name": 1, "id": 1, "_id": 0}
 
-	users, err := dbClient.FindRecordsWithProjection("ainuUsers", "users", bson.M{}, projection)
-	if err != nil {
-		metrics.StepCounter.WithLabelValues(path, "db_retrieval_error", "error").Inc()
-		logger.Slog.Error("Failed to retrieve users", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve users"})
+	users, ok := query.findPage(c, path, "ainuUsers", "users", bson.M{}, projection)
+	if !ok {
 		return
 	}
 
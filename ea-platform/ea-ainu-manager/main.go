@@ -29,6 +29,12 @@ func main() {
 	handlers.SetDBClient(dbClient)
 	logger.Slog.Info("MongoDB client successfully passed to handlers")
 
+	// Create the indexes the handlers rely on
+	if err := handlers.EnsureIndexes(); err != nil {
+		logger.Slog.Error("Failed to create MongoDB indexes", "error", err)
+		log.Fatalf("Failed to create MongoDB indexes: %v", err)
+	}
+
 	// Initialize Gin router
 	router := routes.RegisterRoutes()
 
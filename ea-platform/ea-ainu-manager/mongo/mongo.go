@@ -2,9 +2,13 @@ package mongo
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
@@ -95,6 +99,16 @@ func (m *MongoClient) FindRecordsWithProjection(database, collection string, fil
 	return results, nil
 }
 
+// CreateIndex creates an index on keys if it does not exist yet.
+func (m *MongoClient) CreateIndex(database, collection string, keys bson.D, opts *options.IndexOptions) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	coll := m.client.Database(database).Collection(collection)
+	_, err := coll.Indexes().CreateOne(ctx, mongo.IndexModel{Keys: keys, Options: opts})
+	return err
+}
+
 // UpdateRecord updates a record in the specified collection using a filter.
 func (m *MongoClient) UpdateRecord(database, collection string, filter, update interface{}) (*mongo.UpdateResult, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -108,3 +122,118 @@ func (m *MongoClient) UpdateRecord(database, collection string, filter, update i
 
 	return result, nil
 }
+
+// ErrInvalidCursor is returned by FindPage for a cursor it did not issue for the same sort.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// PageQuery describes one page of a keyset-paginated query. Records are ordered
+// by SortField and then by id, so the id breaks ties between equal sort values.
+type PageQuery struct {
+	Filter     bson.M
+	Projection bson.M // Must include SortField and id when set
+	SortField  string
+	Descending bool
+	Limit      int64  // 0 returns all remaining records
+	Cursor     string // Cursor returned for the previous page; empty for the first page
+}
+
+// FindPage returns one page of records and the cursor of the next page, which
+// is empty on the last page.
+func (m *MongoClient) FindPage(database, collection string, q PageQuery) ([]map[string]interface{}, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	order, op := 1, "$gt"
+	if q.Descending {
+		order, op = -1, "$lt"
+	}
+
+	filter := q.Filter
+	if filter == nil {
+		filter = bson.M{}
+	}
+	if q.Cursor != "" {
+		value, id, err := decodeCursor(q.Cursor, q.SortField)
+		if err != nil {
+			return nil, "", err
+		}
+		// Records after the cursor: a later sort value, or the same value and a later id
+		after := bson.M{"$or": bson.A{
+			bson.M{q.SortField: bson.M{op: value}},
+			bson.M{q.SortField: value, "id": bson.M{op: id}},
+		}}
+		filter = bson.M{"$and": bson.A{filter, after}}
+	}
+
+	opts := options.Find().SetSort(bson.D{{Key: q.SortField, Value: order}, {Key: "id", Value: order}})
+	if q.Projection != nil {
+		opts.SetProjection(q.Projection)
+	}
+	if q.Limit > 0 {
+		// One extra record tells whether there is a next page
+		opts.SetLimit(q.Limit + 1)
+	}
+
+	coll := m.client.Database(database).Collection(collection)
+	cursor, err := coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, "", err
+	}
+	defer cursor.Close(ctx)
+
+	var raws []bson.Raw
+	if err := cursor.All(ctx, &raws); err != nil {
+		return nil, "", err
+	}
+
+	next := ""
+	if q.Limit > 0 && int64(len(raws)) > q.Limit {
+		raws = raws[:q.Limit]
+		if next, err = encodeCursor(raws[len(raws)-1], q.SortField); err != nil {
+			return nil, "", err
+		}
+	}
+
+	results := make([]map[string]interface{}, 0, len(raws))
+	for _, raw := range raws {
+		var record map[string]interface{}
+		if err := bson.Unmarshal(raw, &record); err != nil {
+			return nil, "", err
+		}
+		results = append(results, record)
+	}
+	return results, next, nil
+}
+
+// encodeCursor stores the sort value and id of the last record of a page. The
+// value is kept as BSON so dates and numbers compare correctly on the next page.
+func encodeCursor(record bson.Raw, sortField string) (string, error) {
+	value, err := record.LookupErr(strings.Split(sortField, ".")...)
+	if err != nil {
+		value = bson.RawValue{Type: bsontype.Null}
+	}
+	id, _ := record.Lookup("id").StringValueOK()
+
+	data, err := bson.Marshal(bson.D{{Key: "s", Value: sortField}, {Key: "v", Value: value}, {Key: "id", Value: id}})
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+// decodeCursor returns the sort value and id stored by encodeCursor.
+func decodeCursor(cursor, sortField string) (interface{}, string, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, "", ErrInvalidCursor
+	}
+	var decoded struct {
+		SortField string        `bson:"s"`
+		Value     bson.RawValue `bson:"v"`
+		ID        string        `bson:"id"`
+	}
+	if err := bson.Unmarshal(data, &decoded); err != nil || decoded.SortField != sortField {
+		return nil, "", ErrInvalidCursor
+	}
+	return decoded.Value, decoded.ID, nil
+}
@@ -46,6 +46,7 @@ var (
 	completedAgentJobQueue = workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
 	errorAgentJobQueue     = workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
 	nodeStatusQueue        = workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	cancelledAgentJobQueue = workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
 )
 
 // StartOperators initializes informers and starts watching resources
@@ -105,6 +106,11 @@ func StartOperators(stopCh <-chan struct{}) {
 		go processNodeStatusQueue(stopCh)
 	}
 
+	if cfg.FeatureCancelledAgentJobs == "true" {
+		go watchCancelledAgentJobs(dynamicFactory, stopCh)
+		go processCancelledAgentJobQueue(stopCh)
+	}
+
 	// Start and sync factories
 	dynamicFactory.Start(stopCh)
 	k8sFactory.Start(stopCh)
@@ -233,6 +239,33 @@ func watchErrorAgentJobs(factory dynamicinformer.DynamicSharedInformerFactory, s
 	informer.Run(stopCh)
 }
 
+// watchCancelledAgentJobs detects cancelled AgentJobs TO CANCELLED
+func watchCancelledAgentJobs(factory dynamicinformer.DynamicSharedInformerFactory, stopCh <-chan struct{}) {
+	logger.Slog.Info("Starting Cancelled AgentJob Informer")
+
+	informer := factory.ForResource(agentJobGVR).Informer()
+
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldJob, _ := oldObj.(*unstructured.Unstructured)
+			job, ok := newObj.(*unstructured.Unstructured)
+			if !ok || oldJob == nil {
+				logger.Slog.Error("Failed to parse AgentJob object")
+				return
+			}
+
+			// Only queue the transition; node events keep updating cancelled jobs
+			oldStatus, _, _ := unstructured.NestedString(oldJob.Object, "status", "state")
+			status, _, _ := unstructured.NestedString(job.Object, "status", "state")
+			if status == "cancelled" && oldStatus != "cancelled" {
+				cancelledAgentJobQueue.Add(job)
+			}
+		},
+	})
+
+	informer.Run(stopCh)
+}
+
 func watchNodeStatus(factory dynamicinformer.DynamicSharedInformerFactory, stopCh <-chan struct{}) {
 	logger.Slog.Info("Starting Node Update Informer")
 
@@ -506,6 +539,62 @@ func processErrorAgentJobQueue(stopCh <-chan struct{}) {
 	}, time.Second, stopCh) // Runs continuously while stopCh is open
 }
 
+func processCancelledAgentJobQueue(stopCh <-chan struct{}) {
+	wait.Until(func() {
+		if cancelledAgentJobQueue.Len() == 0 {
+			return
+		}
+
+		batchSize := min(10, cancelledAgentJobQueue.Len())
+
+		for i := 0; i < batchSize; i++ {
+			item, shutdown := cancelledAgentJobQueue.Get()
+			if shutdown {
+				return
+			}
+
+			job := item.(*unstructured.Unstructured)
+			jobName := job.GetName()
+
+			go func() {
+				defer cancelledAgentJobQueue.Done(job) // Mark as processed
+
+				userID, found, _ := unstructured.NestedString(job.Object, "spec", "user")
+				if !found || userID == "" {
+					logger.Slog.Error("User ID not found in AgentJob spec", "job", jobName)
+					return
+				}
+
+				jobID := string(job.GetUID())
+				if jobID == "" {
+					logger.Slog.Error("Job ID not found in AgentJob spec", "job", jobName)
+					return
+				}
+
+				filter := map[string]interface{}{
+					"id":      userID,
+					"jobs.id": jobID,
+				}
+
+				update := map[string]interface{}{
+					"$set": map[string]interface{}{
+						"jobs.$.status":      "Cancelled",
+						"jobs.$.last_active": time.Now(),
+					},
+				}
+
+				_, err := dbClient.UpdateRecord("ainuUsers", "users", filter, update)
+				if err != nil {
+					logger.Slog.Error("Failed to update job status in MongoDB", "user_id", userID, "job_id", jobID, "error", err)
+					cancelledAgentJobQueue.AddAfter(job, 10*time.Second) // Retry
+				} else {
+					logger.Slog.Info("Updated job to Cancelled in user's job array", "user_id", userID, "job_id", jobID)
+				}
+			}()
+		}
+	}, time.Second, stopCh) // Runs continuously while stopCh is open
+}
+
 func processNodeStatusQueue(stopCh <-chan struct{}) {
 	wait.Until(func() {
 		if nodeStatusQueue.Len() == 0 {
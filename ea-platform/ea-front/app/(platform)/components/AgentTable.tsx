@@ -38,20 +38,29 @@ export default function AgentTable({ userId }: { userId: string | null }) {
 
     setLoading(true);
     try {
-      // 🔥 Step 1: Get basic agent list (IDs)
-      const response = await fetch(AGENT_MANAGER_URL, {
-        method: "GET",
-        headers: {
-          "Authorization": `Bearer ${token}`, // ✅ Attach JWT token
-          "Content-Type": "application/json",
-        },
-        credentials: "include",
-      });
-
-      if (!response.ok) throw new Error("Failed to fetch agents");
-
-      const agentList = await response.json();
-      if (!Array.isArray(agentList)) throw new Error("Invalid agent data received");
+      // 🔥 Step 1: Get basic agent list (IDs), following the page cursors
+      const agentList: any[] = [];
+      let cursor: string | null = null;
+      do {
+        const url: string = cursor
+          ? `${AGENT_MANAGER_URL}?limit=500&cursor=${encodeURIComponent(cursor)}`
+          : `${AGENT_MANAGER_URL}?limit=500`;
+        const response: Response = await fetch(url, {
+          method: "GET",
+          headers: {
+            "Authorization": `Bearer ${token}`, // ✅ Attach JWT token
+            "Content-Type": "application/json",
+          },
+          credentials: "include",
+        });
+
+        if (!response.ok) throw new Error("Failed to fetch agents");
+
+        const page = await response.json();
+        if (!Array.isArray(page)) throw new Error("Invalid agent data received");
+        agentList.push(...page);
+        cursor = response.headers.get("X-Next-Cursor");
+      } while (cursor);
 
       // 🔥 Step 2: Fetch full details for each agent
       const detailedAgents = await Promise.all(
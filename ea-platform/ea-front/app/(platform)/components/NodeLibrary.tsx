@@ -55,18 +55,27 @@ export default function NodeLibrary({
           return;
         }
 
-        // Fetch list of node IDs
-        const response = await fetch(API_BASE_URL, {
-          method: "GET",
-          headers: {
-            "Content-Type": "application/json",
-            "Authorization": `Bearer ${token}`,
-          },
-          credentials: "include",
-        });
-
-        if (!response.ok) throw new Error(`Failed to fetch node IDs: ${response.status}`);
-        const nodeList: { id: string }[] = await response.json();
+        // Fetch list of node IDs, following the page cursors
+        const nodeList: { id: string }[] = [];
+        let cursor: string | null = null;
+        do {
+          const url: string = cursor
+            ? `${API_BASE_URL}?limit=500&cursor=${encodeURIComponent(cursor)}`
+            : `${API_BASE_URL}?limit=500`;
+          const response: Response = await fetch(url, {
+            method: "GET",
+            headers: {
+              "Content-Type": "application/json",
+              "Authorization": `Bearer ${token}`,
+            },
+            credentials: "include",
+          });
+
+          if (!response.ok) throw new Error(`Failed to fetch node IDs: ${response.status}`);
+          const page: { id: string }[] = await response.json();
+          nodeList.push(...page);
+          cursor = response.headers.get("X-Next-Cursor");
+        } while (cursor);
 
         // Fetch node details in parallel
         const nodeDetails = await Promise.all(
@@ -4,15 +4,17 @@ import "os"
 
 // Config holds application configuration.
 type Config struct {
-	Port            string
-	AgentManagerUrl string
+	Port                 string
+	AgentManagerUrl      string
+	AgentManagerNodesUrl string
 }
 
 // LoadConfig initializes the configuration from environment variables.
 func LoadConfig() Config {
 	return Config{
-		Port:            getEnv("PORT", "8080"),
-		AgentManagerUrl: getEnv("AGENT_MANAGER_URL", "http://ea-agent-manager:8080/api/v1/agents/"),
+		Port:                 getEnv("PORT", "8080"),
+		AgentManagerUrl:      getEnv("AGENT_MANAGER_URL", "http://ea-agent-manager:8080/api/v1/agents/"),
+		AgentManagerNodesUrl: getEnv("AGENT_MANAGER_NODES_URL", "http://ea-agent-manager:8080/api/v1/nodes/"),
 	}
 }
 
@@ -0,0 +1,396 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"time"
+
+	"ea-job-api/logger"
+	"ea-job-api/metrics"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+)
+
+// keepaliveInterval is how often an idle stream is pinged so proxies keep it open.
+const keepaliveInterval = 15 * time.Second
+
+// Event types of the job event stream.
+const (
+	jobEventState   = "state"   // The job state or message changed
+	jobEventNode    = "node"    // A node reported a new status or output
+	jobEventDeleted = "deleted" // The AgentJob was deleted
+)
+
+// JobEvent is one message of the job event stream. ID is the resourceVersion of
+// the AgentJob the event was read from. One update of the AgentJob can produce
+// several events; only the last carries the ID, so a client that resumes from
+// it has seen the whole update.
+type JobEvent struct {
+	ID    string      `json:"id,omitempty"`
+	Event string      `json:"event"`
+	Data  interface{} `json:"data"`
+}
+
+// JobStateEvent is the data of a state event.
+type JobStateEvent struct {
+	Job     string `json:"job"`
+	State   string `json:"state"`
+	Message string `json:"message,omitempty"`
+}
+
+// JobNodeEvent is the data of a node event.
+type JobNodeEvent struct {
+	Job string `json:"job"`
+	JobNodeStatus
+}
+
+// jobEventSink writes stream events to a client.
+type jobEventSink interface {
+	send(event JobEvent) error
+	keepalive() error
+}
+
+var websocketUpgrader = websocket.Upgrader{
+	// Origins are enforced by the API gateway, as for the other endpoints
+	CheckOrigin: func(r *http.Request) bool { return true },
+}
+
+// HandleJobEvents streams the state transitions and node updates of an AgentJob
+// as Server-Sent Events, or as JSON WebSocket messages when the request is a
+// WebSocket upgrade. A client that reconnects with `Last-Event-ID` (or the
+// `last_event_id` query parameter) is sent the changes since that event, or a
+// snapshot if that version is no longer available. The stream ends once the
+// job finishes or is deleted.
+func HandleJobEvents(c *gin.Context) {
+	path := c.FullPath()
+	metrics.StepCounter.WithLabelValues(path, "api_hit", "success").Inc()
+
+	job, dynamicClient, ok := loadOwnedAgentJob(c, path)
+	if !ok {
+		return
+	}
+
+	lastEventID := c.GetHeader("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = c.Query("last_event_id")
+	}
+
+	ctx := c.Request.Context()
+	var sink jobEventSink
+	if websocket.IsWebSocketUpgrade(c.Request) {
+		conn, err := websocketUpgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			// The upgrader has already written the error response
+			metrics.StepCounter.WithLabelValues(path, "websocket_upgrade_error", "error").Inc()
+			logger.Slog.Error("Failed to upgrade job event stream", "job", job.GetName(), "error", err)
+			return
+		}
+		defer conn.Close()
+		ws := newWebsocketSink(conn)
+		sink = ws
+
+		// Stop watching as soon as the client closes the socket
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		go func() {
+			select {
+			case <-ws.closed:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	} else {
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Header("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+		sink = &sseSink{c: c}
+	}
+
+	metrics.StepCounter.WithLabelValues(path, "stream_start", "success").Inc()
+	logger.Slog.Info("Job event stream opened", "job", job.GetName(), "last_event_id", lastEventID)
+
+	err := streamJobEvents(ctx, dynamicClient, job, lastEventID, sink)
+	if err != nil && ctx.Err() == nil {
+		metrics.StepCounter.WithLabelValues(path, "stream_error", "error").Inc()
+		logger.Slog.Error("Job event stream failed", "job", job.GetName(), "error", err)
+		return
+	}
+	logger.Slog.Info("Job event stream closed", "job", job.GetName())
+}
+
+// streamJobEvents resumes from lastEventID when the AgentJob can still be read
+// at that version and otherwise sends the current state of job. It then watches
+// the AgentJob and sends what changes.
+func streamJobEvents(ctx context.Context, dynamicClient dynamic.Interface, job *unstructured.Unstructured, lastEventID string, sink jobEventSink) error {
+	// 🔹 Resume from the version the client has seen, or start with a snapshot
+	previous, err := jobAtEventID(ctx, dynamicClient, job, lastEventID)
+	if err != nil {
+		return err
+	}
+	if previous == nil {
+		if err := sendJobChanges(sink, nil, job); err != nil {
+			return err
+		}
+		previous = job
+	}
+	if jobFinished(previous) {
+		return nil
+	}
+
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+
+	for {
+		// 🔹 Watch from the last seen version; watches expire and are re-opened
+		watcher, err := dynamicClient.Resource(agentJobGVR).Namespace(agentJobNamespace).Watch(ctx, metav1.ListOptions{
+			FieldSelector:   fields.OneTermEqualSelector("metadata.name", job.GetName()).String(),
+			ResourceVersion: previous.GetResourceVersion(),
+		})
+		resync := apierrors.IsResourceExpired(err) || apierrors.IsGone(err)
+		if err != nil && !resync {
+			return err
+		}
+
+		if !resync {
+			resync, err = forwardJobWatch(ctx, watcher, &previous, sink, keepalive.C)
+			watcher.Stop()
+			if err != nil || previous == nil || jobFinished(previous) {
+				return err
+			}
+		}
+
+		// 🔹 The version we watched from is gone; send what changed since
+		if resync {
+			current, err := dynamicClient.Resource(agentJobGVR).Namespace(agentJobNamespace).Get(ctx, job.GetName(), metav1.GetOptions{})
+			if apierrors.IsNotFound(err) {
+				return sink.send(JobEvent{ID: previous.GetResourceVersion(), Event: jobEventDeleted, Data: JobStateEvent{Job: job.GetName()}})
+			}
+			if err != nil {
+				return err
+			}
+			if err := sendJobChanges(sink, previous, current); err != nil {
+				return err
+			}
+			previous = current
+			if jobFinished(previous) {
+				return nil
+			}
+		}
+	}
+}
+
+// jobAtEventID returns the AgentJob as it was at lastEventID, so that a client
+// that reconnects is only sent what changed since. It returns nil if there is
+// nothing to resume from: no ID, an ID that is not a version of this AgentJob,
+// or a version the API server has compacted away (410 Gone).
+func jobAtEventID(ctx context.Context, dynamicClient dynamic.Interface, job *unstructured.Unstructured, lastEventID string) (*unstructured.Unstructured, error) {
+	if lastEventID == "" {
+		return nil, nil
+	}
+	if lastEventID == job.GetResourceVersion() {
+		return job, nil
+	}
+
+	list, err := dynamicClient.Resource(agentJobGVR).Namespace(agentJobNamespace).List(ctx, metav1.ListOptions{
+		FieldSelector:        fields.OneTermEqualSelector("metadata.name", job.GetName()).String(),
+		ResourceVersion:      lastEventID,
+		ResourceVersionMatch: metav1.ResourceVersionMatchExact,
+	})
+	switch {
+	case apierrors.IsGone(err) || apierrors.IsResourceExpired(err):
+		logger.Slog.Info("Job event stream cannot resume from a compacted version", "job", job.GetName(), "last_event_id", lastEventID)
+		return nil, nil
+	case apierrors.IsBadRequest(err) || apierrors.IsInvalid(err) || apierrors.IsTimeout(err):
+		// Not a resourceVersion, or one newer than the API server has seen
+		logger.Slog.Info("Job event stream ignored an unknown last event ID", "job", job.GetName(), "last_event_id", lastEventID)
+		return nil, nil
+	case err != nil:
+		return nil, err
+	}
+
+	// The ID may belong to an earlier AgentJob with the same name
+	for i := range list.Items {
+		if list.Items[i].GetUID() == job.GetUID() {
+			return &list.Items[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// forwardJobWatch sends the changes reported by a watch until it closes, the
+// job finishes or is deleted (previous is then nil), or the client goes away.
+// It reports whether the watch expired and the job has to be fetched again.
+func forwardJobWatch(ctx context.Context, watcher watch.Interface, previous **unstructured.Unstructured, sink jobEventSink, keepalive <-chan time.Time) (bool, error) {
+	for {
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-keepalive:
+			if err := sink.keepalive(); err != nil {
+				return false, err
+			}
+		case event, ok := <-watcher.ResultChan():
+			if !ok {
+				return false, nil
+			}
+			switch event.Type {
+			case watch.Error:
+				status := apierrors.FromObject(event.Object)
+				if apierrors.IsResourceExpired(status) || apierrors.IsGone(status) {
+					return true, nil
+				}
+				return false, status
+			case watch.Deleted:
+				job := *previous
+				if deleted, ok := event.Object.(*unstructured.Unstructured); ok {
+					job = deleted
+				}
+				*previous = nil
+				return false, sink.send(JobEvent{ID: job.GetResourceVersion(), Event: jobEventDeleted, Data: JobStateEvent{Job: job.GetName()}})
+			case watch.Added, watch.Modified:
+				current, ok := event.Object.(*unstructured.Unstructured)
+				if !ok {
+					continue
+				}
+				if err := sendJobChanges(sink, *previous, current); err != nil {
+					return false, err
+				}
+				*previous = current
+				if jobFinished(current) {
+					return false, nil
+				}
+			}
+		}
+	}
+}
+
+// sendJobChanges sends a node event for every node whose status changed and a
+// state event if the state or message changed. A nil previous sends everything.
+func sendJobChanges(sink jobEventSink, previous, current *unstructured.Unstructured) error {
+	var events []JobEvent
+
+	// 🔹 A node event for every node that reported since the previous version
+	previousNodes := map[string]interface{}{}
+	if previous != nil {
+		nodes, _, _ := unstructured.NestedSlice(previous.Object, "status", "nodes")
+		for _, n := range nodes {
+			if node, ok := n.(map[string]interface{}); ok {
+				alias, _ := node["alias"].(string)
+				previousNodes[alias] = node
+			}
+		}
+	}
+	nodes, _, _ := unstructured.NestedSlice(current.Object, "status", "nodes")
+	for _, n := range nodes {
+		node, ok := n.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		status := nodeStatusOf(node)
+		if !reflect.DeepEqual(previousNodes[status.Alias], node) {
+			events = append(events, JobEvent{Event: jobEventNode, Data: JobNodeEvent{Job: current.GetName(), JobNodeStatus: status}})
+		}
+	}
+
+	// 🔹 Then a state event if the state or message changed
+	summary := summarizeAgentJob(current)
+	changed := previous == nil
+	if !changed {
+		before := summarizeAgentJob(previous)
+		changed = before.State != summary.State || before.Message != summary.Message
+	}
+	if changed {
+		events = append(events, JobEvent{Event: jobEventState, Data: JobStateEvent{Job: current.GetName(), State: summary.State, Message: summary.Message}})
+	}
+
+	for i, event := range events {
+		if i == len(events)-1 {
+			event.ID = current.GetResourceVersion()
+		}
+		if err := sink.send(event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// jobFinished reports whether nothing more will happen to a job.
+func jobFinished(job *unstructured.Unstructured) bool {
+	state, _, _ := unstructured.NestedString(job.Object, "status", "state")
+	return terminalJobStates[state]
+}
+
+//--------------------- Sinks ---------------------//
+
+// sseSink writes events as Server-Sent Events.
+type sseSink struct {
+	c *gin.Context
+}
+
+func (s *sseSink) send(event JobEvent) error {
+	if err := sse.Encode(s.c.Writer, sse.Event{Id: event.ID, Event: event.Event, Data: event.Data}); err != nil {
+		return err
+	}
+	s.c.Writer.Flush()
+	return nil
+}
+
+func (s *sseSink) keepalive() error {
+	if _, err := s.c.Writer.WriteString(": keepalive\n\n"); err != nil {
+		return err
+	}
+	s.c.Writer.Flush()
+	return nil
+}
+
+// websocketSink writes events as JSON text messages.
+type websocketSink struct {
+	conn   *websocket.Conn
+	closed chan struct{}
+}
+
+// newWebsocketSink starts reading from the connection, which is needed to
+// process control frames and to notice when the client closes it.
+func newWebsocketSink(conn *websocket.Conn) *websocketSink {
+	s := &websocketSink{conn: conn, closed: make(chan struct{})}
+	go func() {
+		defer close(s.closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	return s
+}
+
+func (s *websocketSink) send(event JobEvent) error {
+	select {
+	case <-s.closed:
+		return websocket.ErrCloseSent
+	default:
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(keepaliveInterval))
+	return s.conn.WriteJSON(event)
+}
+
+func (s *websocketSink) keepalive() error {
+	select {
+	case <-s.closed:
+		return websocket.ErrCloseSent
+	default:
+	}
+	return s.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(keepaliveInterval))
+}
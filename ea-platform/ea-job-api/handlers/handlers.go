@@ -5,9 +5,11 @@ import (
 	"crypto/rand"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"ea-job-api/config"
@@ -15,11 +17,10 @@ import (
 	"ea-job-api/metrics"
 
 	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/client-go/dynamic"
-	"k8s.io/client-go/rest"
 )
 
 // Define structs to store agent definition after lookup
@@ -30,13 +31,31 @@ type Agent struct {
 	Description string        `json:"description"`
 	Nodes       []Node        `json:"nodes"`
 	Edges       []Edge        `json:"edges"`
+	Inputs      []AgentInput  `json:"inputs,omitempty"`
+	Version     int           `json:"version"`
 	Metadata    AgentMetadata `json:"metadata"`
 }
 
+// AgentVersion is an immutable agent revision returned by the Agent Manager.
+type AgentVersion struct {
+	Version int   `json:"version"`
+	Agent   Agent `json:"agent"`
+}
+
 type Node struct {
 	Alias      string                 `json:"alias"`
 	Type       string                 `json:"type"`
 	Parameters map[string]interface{} `json:"parameters"`
+	Retry      *RetryPolicy           `json:"retry,omitempty"`
+}
+
+// RetryPolicy overrides the node definition's retry policy for a single node.
+type RetryPolicy struct {
+	MaxAttempts          int     `json:"max_attempts,omitempty"`
+	InitialBackoff       string  `json:"initial_backoff,omitempty"`
+	MaxBackoff           string  `json:"max_backoff,omitempty"`
+	Multiplier           float64 `json:"multiplier,omitempty"`
+	RetryableStatusCodes []int   `json:"retryable_status_codes,omitempty"`
 }
 
 type Edge struct {
@@ -45,8 +64,16 @@ type Edge struct {
 }
 
 type CreateJobRequest struct {
-	AgentID string `json:"agent_id"`
-	UserID  string `json:"user_id"`
+	AgentID        string `json:"agent_id"`
+	UserID         string `json:"user_id"`
+	TimeoutSeconds int    `json:"timeout_seconds,omitempty"` // Optional job-level deadline
+	AgentVersion   int    `json:"agent_version,omitempty"`   // Optional agent revision; defaults to the latest
+
+	Inputs map[string]interface{} `json:"inputs,omitempty"` // Values for the agent's declared inputs
+
+	// Set by the job operator when an AgentSchedule fires (internal callers only)
+	Schedule      string     `json:"schedule,omitempty"`
+	ScheduledTime *time.Time `json:"scheduled_time,omitempty"`
 }
 
 // Metadata holds timestamps for Agents.
@@ -104,91 +131,129 @@ func HandleCreateJob(c *gin.Context) {
 		return
 	}
 
-	// Fetch the agent details from the Agent Manager **using the user's ID**
-	cfg := config.LoadConfig()
-	agentURL := fmt.Sprintf("%s%s", cfg.AgentManagerUrl, req.AgentID)
+	// Reject negative deadlines; zero means no job-level timeout
+	if req.TimeoutSeconds < 0 {
+		logger.Slog.Error("Invalid job timeout", "timeout_seconds", req.TimeoutSeconds)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "timeout_seconds must not be negative"})
+		return
+	}
 
-	// Create the request to the Agent Manager
-	agentReq, err := http.NewRequest("GET", agentURL, nil)
-	if err != nil {
-		logger.Slog.Error("Failed to create request to Agent Manager", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create request"})
+	if req.AgentVersion < 0 {
+		logger.Slog.Error("Invalid agent version", "agent_version", req.AgentVersion)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "agent_version must not be negative"})
 		return
 	}
 
-	// Pass along the user's authorization token (so the agent manager applies user-based access controls)
-	authHeader := c.GetHeader("Authorization")
-	if authHeader != "" {
-		agentReq.Header.Set("Authorization", authHeader)
+	// 🔹 Scheduled runs come from the job operator on behalf of the schedule's owner
+	agentManagerUserID := authenticatedUserID
+	if req.Schedule != "" {
+		if authenticatedUserID != "internal" {
+			metrics.StepCounter.WithLabelValues(path, "user_spoofing_attempt", "failure").Inc()
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only the job operator can start scheduled runs"})
+			return
+		}
+		if req.UserID == "" || req.ScheduledTime == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Scheduled runs need user_id and scheduled_time"})
+			return
+		}
+		// The agent manager must check the owner's access, not the operator's
+		agentManagerUserID = req.UserID
 	}
 
-	// Pass the user's ID as a security measure (instead of using `internal`)
-	agentReq.Header.Set("X-Consumer-Username", authenticatedUserID)
+	// 🔹 Create the AgentJob
+	spec := agentJobSpec{
+		AgentID:          req.AgentID,
+		AgentVersion:     req.AgentVersion,
+		UserID:           req.UserID,
+		AgentManagerUser: agentManagerUserID,
+		AuthHeader:       c.GetHeader("Authorization"),
+		UserGroups:       consumerGroups(c),
+		Inputs:           req.Inputs,
+		TimeoutSeconds:   req.TimeoutSeconds,
+	}
+	// Label scheduled runs so the operator can find the active runs of a
+	// schedule, and name them after their schedule and minute so a retried
+	// trigger cannot start the same run twice
+	if req.Schedule != "" {
+		spec.Name = scheduledJobName(req.Schedule, *req.ScheduledTime)
+		spec.Labels = map[string]string{scheduleLabel: req.Schedule}
+		spec.Annotations = map[string]string{scheduledTimeAnnotation: req.ScheduledTime.UTC().Format(time.RFC3339)}
+	}
 
-	// Send the request
-	agentClient := &http.Client{}
-	resp, err := agentClient.Do(agentReq)
+	job, _, err := createAgentJob(spec)
 	if err != nil {
-		logger.Slog.Error("Failed to reach agent manager", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve agent"})
+		respondJobCreationError(c, path, err)
 		return
 	}
-	defer resp.Body.Close()
 
-	// Check response status
-	if resp.StatusCode != http.StatusOK {
-		logger.Slog.Error("Agent manager returned non-200 response", "status", resp.StatusCode)
-		c.JSON(resp.StatusCode, gin.H{"error": "Failed to retrieve agent"})
-		return
-	}
+	agentVersion, _, _ := unstructured.NestedInt64(job.Object, "spec", "agentVersion")
+	logger.Slog.Info("Successfully created AgentJob CR", "jobName", job.GetName())
+	c.JSON(http.StatusAccepted, gin.H{"status": "job created", "job_name": job.GetName(), "user_id": req.UserID, "agent_version": agentVersion})
+}
 
-	// Parse the response body
-	body, err := io.ReadAll(resp.Body)
-	logger.Slog.Info("agent manager response body", "body", resp.Body)
-	if err != nil {
-		logger.Slog.Error("Failed to read response body", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to parse agent data"})
-		return
+// agentJobSpec is what an AgentJob is created from.
+type agentJobSpec struct {
+	AgentID          string
+	AgentVersion     int // Agent revision; the latest when 0
+	UserID           string
+	AgentManagerUser string   // User the agent is fetched as, so their access controls apply
+	AuthHeader       string   // Authorization header passed to the Agent Manager
+	UserGroups       []string // Orgs of AgentManagerUser from Kong's X-Consumer-Groups, if known
+	Inputs           map[string]interface{}
+	TimeoutSeconds   int
+
+	Name        string // Generated from the agent ID when empty
+	Labels      map[string]string
+	Annotations map[string]string
+}
+
+// jobCreationError is a failure of createAgentJob and the response it maps to.
+type jobCreationError struct {
+	status  int
+	step    string // Metrics step
+	message string
+	details []string
+	jobName string
+	err     error
+}
+
+func (e *jobCreationError) Error() string {
+	if e.err != nil {
+		return fmt.Sprintf("%s: %v", e.message, e.err)
 	}
+	return e.message
+}
 
-	var agent Agent
-	if err := json.Unmarshal(body, &agent); err != nil {
-		logger.Slog.Error("Failed to unmarshal agent JSON", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid agent data format"})
-		return
+func (e *jobCreationError) Unwrap() error {
+	return e.err
+}
+
+// createAgentJob fetches the agent from the Agent Manager, checks the inputs
+// against its declarations and creates the AgentJob that runs it. It returns
+// the created AgentJob and a dynamic client, or a *jobCreationError.
+func createAgentJob(spec agentJobSpec) (*unstructured.Unstructured, dynamic.Interface, error) {
+	// Fetch the agent details from the Agent Manager **using the user's ID**
+	agent, status, err := fetchAgent(spec.AuthHeader, spec.AgentManagerUser, spec.AgentID, spec.AgentVersion)
+	if err != nil {
+		return nil, nil, &jobCreationError{status: status, step: "agent_fetch_error", message: "Failed to retrieve agent", err: err}
 	}
 
-	if agent.ID == "" {
-		logger.Slog.Error("Agent ID is missing from agent manager response")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Agent data missing ID"})
-		return
+	// Validate the job inputs against the agent's declarations and apply defaults
+	inputs, problems := resolveJobInputs(agent.Inputs, spec.Inputs)
+	if len(problems) > 0 {
+		return nil, nil, &jobCreationError{status: http.StatusBadRequest, step: "invalid_inputs", message: "Invalid job inputs", details: problems}
 	}
 
 	// Generate a unique job name
-	hash := generateRandomHash()
-	jobName := fmt.Sprintf("agentjob-%s-%s", agent.ID, hash)
-
-	// Create Kubernetes client configuration
-	k8sConfig, err := rest.InClusterConfig()
-	if err != nil {
-		logger.Slog.Error("Failed to create in-cluster Kubernetes config", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create Kubernetes client config"})
-		return
+	jobName := spec.Name
+	if jobName == "" {
+		jobName = fmt.Sprintf("agentjob-%s-%s", agent.ID, generateRandomHash())
 	}
 
 	// Create a dynamic Kubernetes client
-	dynamicClient, err := dynamic.NewForConfig(k8sConfig)
+	dynamicClient, err := newDynamicClient()
 	if err != nil {
-		logger.Slog.Error("Failed to create dynamic Kubernetes client", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create Kubernetes dynamic client"})
-		return
-	}
-
-	// Define the GroupVersionResource (GVR) for the AgentJob CRD
-	agentJobGVR := schema.GroupVersionResource{
-		Group:    "ea.erulabs.ai",
-		Version:  "v1",
-		Resource: "agentjobs",
+		return nil, nil, &jobCreationError{status: http.StatusInternalServerError, step: "k8s_client_error", message: "Failed to create Kubernetes dynamic client", err: err}
 	}
 
 	// Ensure parameters field retains complex structure
@@ -199,15 +264,11 @@ func HandleCreateJob(c *gin.Context) {
 
 		// Convert Parameters into JSON and back to preserve structure
 		parametersJSON, err := json.Marshal(node.Parameters)
-		if err != nil {
-			logger.Slog.Error("Failed to marshal node parameters", "error", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process node parameters"})
-			return
+		if err == nil {
+			err = json.Unmarshal(parametersJSON, &parametersMap)
 		}
-		if err := json.Unmarshal(parametersJSON, &parametersMap); err != nil {
-			logger.Slog.Error("Failed to unmarshal node parameters into map", "error", err)
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to process node parameters"})
-			return
+		if err != nil {
+			return nil, nil, &jobCreationError{status: http.StatusInternalServerError, step: "node_processing_error", message: "Failed to process node parameters", err: err}
 		}
 
 		// Construct the node map with properly formatted parameters
@@ -216,49 +277,204 @@ func HandleCreateJob(c *gin.Context) {
 			"type":       node.Type,
 			"parameters": parametersMap, // Ensures structured handling
 		}
+
+		// Carry the per-node retry override into the job spec
+		if node.Retry != nil {
+			retryMap := make(map[string]interface{})
+			retryJSON, err := json.Marshal(node.Retry)
+			if err == nil {
+				err = json.Unmarshal(retryJSON, &retryMap)
+			}
+			if err != nil {
+				return nil, nil, &jobCreationError{status: http.StatusInternalServerError, step: "node_processing_error", message: "Failed to process node retry policy", err: err}
+			}
+			nodeMap["retry"] = retryMap
+		}
 		nodes = append(nodes, nodeMap)
 	}
 
+	// Org-visible node definitions need the creator's orgs, which are only
+	// known when the creator starts the job themselves
+	var creatorGroups []string
+	if spec.AgentManagerUser == agent.Creator {
+		creatorGroups = spec.UserGroups
+	}
+
+	// Pin every node to the definition version it resolves to now
+	unresolved, err := pinNodeVersions(nodes, agent.Creator, creatorGroups)
+	if err != nil {
+		return nil, nil, &jobCreationError{status: http.StatusBadGateway, step: "node_version_error", message: "Failed to resolve node versions", err: err}
+	}
+	if len(unresolved) > 0 {
+		return nil, nil, &jobCreationError{status: http.StatusUnprocessableEntity, step: "node_version_error", message: "Unresolvable node versions", details: unresolved}
+	}
+
 	// Define the AgentJob Custom Resource
+	jobSpec := map[string]interface{}{
+		"agentID": agent.ID,
+		"name":    agent.Name,
+		"user":    spec.UserID,
+		"creator": agent.Creator,
+		"nodes":   nodes, // Now properly formatted
+		"edges":   agent.Edges,
+		"metadata": map[string]interface{}{
+			"created_at": time.Now().Format(time.RFC3339),
+			"updated_at": time.Now().Format(time.RFC3339),
+		},
+	}
 	agentJob := &unstructured.Unstructured{
 		Object: map[string]interface{}{
 			"apiVersion": "ea.erulabs.ai/v1",
 			"kind":       "AgentJob",
 			"metadata": map[string]interface{}{
 				"name":      jobName,
-				"namespace": "ea-platform",
-			},
-			"spec": map[string]interface{}{
-				"agentID": agent.ID,
-				"name":    agent.Name,
-				"user":    req.UserID,
-				"creator": agent.Creator,
-				"nodes":   nodes, // Now properly formatted
-				"edges":   agent.Edges,
-				"metadata": map[string]interface{}{
-					"created_at": time.Now().Format(time.RFC3339),
-					"updated_at": time.Now().Format(time.RFC3339),
-				},
+				"namespace": agentJobNamespace,
 			},
+			"spec": jobSpec,
 		},
 	}
+	if len(spec.Labels) > 0 {
+		agentJob.SetLabels(spec.Labels)
+	}
+	if len(spec.Annotations) > 0 {
+		agentJob.SetAnnotations(spec.Annotations)
+	}
+
+	// Record which agent revision the job runs (agents created before versioning have none)
+	if agent.Version > 0 {
+		jobSpec["agentVersion"] = int64(agent.Version)
+	}
+
+	// Inputs seed the executor's state under the reserved "inputs" alias
+	if len(inputs) > 0 {
+		inputsJSON, err := json.Marshal(inputs)
+		inputsMap := make(map[string]interface{})
+		if err == nil {
+			err = json.Unmarshal(inputsJSON, &inputsMap)
+		}
+		if err != nil {
+			return nil, nil, &jobCreationError{status: http.StatusInternalServerError, step: "inputs_processing_error", message: "Failed to process job inputs", err: err}
+		}
+		jobSpec["inputs"] = inputsMap
+	}
+
+	// Attach the optional job-level deadline (int64 keeps the unstructured object deep-copyable)
+	if spec.TimeoutSeconds > 0 {
+		jobSpec["timeoutSeconds"] = int64(spec.TimeoutSeconds)
+	}
+
+	// Record the creator's orgs for the executor's node visibility check
+	if len(creatorGroups) > 0 {
+		groups := make([]interface{}, 0, len(creatorGroups))
+		for _, group := range creatorGroups {
+			groups = append(groups, group)
+		}
+		jobSpec["creatorGroups"] = groups
+	}
 
 	// Create the AgentJob CR in Kubernetes
-	_, err = dynamicClient.Resource(agentJobGVR).
-		Namespace("ea-platform").
+	created, err := dynamicClient.Resource(agentJobGVR).
+		Namespace(agentJobNamespace).
 		Create(context.TODO(), agentJob, metav1.CreateOptions{})
 
+	if apierrors.IsAlreadyExists(err) {
+		return nil, nil, &jobCreationError{status: http.StatusConflict, step: "job_already_exists", message: "Job already exists", jobName: jobName, err: err}
+	}
 	if err != nil {
-		logger.Slog.Error("Failed to create AgentJob custom resource", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create job in Kubernetes"})
-		return
+		return nil, nil, &jobCreationError{status: http.StatusInternalServerError, step: "k8s_create_error", message: "Failed to create job in Kubernetes", err: err}
+	}
+	return created, dynamicClient, nil
+}
+
+// respondJobCreationError logs a createAgentJob failure and writes its response.
+func respondJobCreationError(c *gin.Context, path string, err error) {
+	var jobErr *jobCreationError
+	if !errors.As(err, &jobErr) {
+		jobErr = &jobCreationError{status: http.StatusInternalServerError, step: "job_create_error", message: "Failed to create job", err: err}
 	}
+	metrics.StepCounter.WithLabelValues(path, jobErr.step, "error").Inc()
+	logger.Slog.Error("Failed to create AgentJob", "error", err)
 
-	logger.Slog.Info("Successfully created AgentJob CR", "jobName", jobName)
-	c.JSON(http.StatusAccepted, gin.H{"status": "job created", "job_name": jobName, "user_id": req.UserID})
+	response := gin.H{"error": jobErr.message}
+	if len(jobErr.details) > 0 {
+		response["details"] = jobErr.details
+	}
+	if jobErr.jobName != "" {
+		response["job_name"] = jobErr.jobName
+	}
+	c.JSON(jobErr.status, response)
 }
 
 // Helper Functions
+
+// fetchAgent loads an agent, or one of its immutable revisions when version > 0,
+// from the Agent Manager on behalf of userID so its access controls apply. On
+// failure it also returns the HTTP status to respond with.
+func fetchAgent(authHeader, userID, agentID string, version int) (Agent, int, error) {
+	cfg := config.LoadConfig()
+	agentURL := fmt.Sprintf("%s%s", cfg.AgentManagerUrl, agentID)
+	if version > 0 {
+		// Pin the job to an immutable revision instead of the latest one
+		agentURL = fmt.Sprintf("%s%s/versions/%d", cfg.AgentManagerUrl, agentID, version)
+	}
+
+	agentReq, err := http.NewRequest("GET", agentURL, nil)
+	if err != nil {
+		return Agent{}, http.StatusInternalServerError, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Pass along the user's authorization token (so the agent manager applies user-based access controls)
+	if authHeader != "" {
+		agentReq.Header.Set("Authorization", authHeader)
+	}
+
+	// Pass the user's ID as a security measure (instead of using `internal`)
+	agentReq.Header.Set("X-Consumer-Username", userID)
+
+	resp, err := http.DefaultClient.Do(agentReq)
+	if err != nil {
+		return Agent{}, http.StatusInternalServerError, fmt.Errorf("failed to reach agent manager: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return Agent{}, resp.StatusCode, fmt.Errorf("agent manager returned status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return Agent{}, http.StatusInternalServerError, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	var agent Agent
+	if version > 0 {
+		var agentVersion AgentVersion
+		if err := json.Unmarshal(body, &agentVersion); err != nil {
+			return Agent{}, http.StatusInternalServerError, fmt.Errorf("invalid agent version data: %w", err)
+		}
+		agent = agentVersion.Agent
+		agent.Version = agentVersion.Version
+	} else if err := json.Unmarshal(body, &agent); err != nil {
+		return Agent{}, http.StatusInternalServerError, fmt.Errorf("invalid agent data: %w", err)
+	}
+
+	if agent.ID == "" {
+		return Agent{}, http.StatusInternalServerError, fmt.Errorf("agent data missing ID")
+	}
+	return agent, http.StatusOK, nil
+}
+
+// consumerGroups returns the orgs of the caller from Kong's `X-Consumer-Groups` header.
+func consumerGroups(c *gin.Context) []string {
+	var groups []string
+	for _, group := range strings.Split(c.GetHeader("X-Consumer-Groups"), ",") {
+		if group = strings.TrimSpace(group); group != "" {
+			groups = append(groups, group)
+		}
+	}
+	return groups
+}
+
 // generateRandomHash creates a random 6-character hexadecimal string
 func generateRandomHash() string {
 	b := make([]byte, 3) // 3 bytes = 6 hex characters
@@ -0,0 +1,769 @@
+package handlers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ea-job-api/logger"
+	"ea-job-api/metrics"
+
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/dynamic"
+)
+
+// agentHookGVR is the GroupVersionResource of the AgentHook CRD.
+var agentHookGVR = schema.GroupVersionResource{
+	Group:    "ea.erulabs.ai",
+	Version:  "v1",
+	Resource: "agenthooks",
+}
+
+// secretGVR is the GroupVersionResource of Kubernetes Secrets, which hold the
+// signing secrets of hooks.
+var secretGVR = schema.GroupVersionResource{Version: "v1", Resource: "secrets"}
+
+// hookLabel is set on the AgentJobs a hook starts, to its ID.
+const hookLabel = "ea.erulabs.ai/hook"
+
+// hookSecretKey is the key of the signing secret in a hook's Secret.
+const hookSecretKey = "secret"
+
+// Signature schemes: how a caller signs the body of a hook request.
+const (
+	signatureHMAC   = "hmac-sha256" // X-Ea-Signature-256: sha256=<hex HMAC of the body>
+	signatureGitHub = "github"      // X-Hub-Signature-256: sha256=<hex HMAC of the body>
+	signatureStripe = "stripe"      // Stripe-Signature: t=<unix time>,v1=<hex HMAC of "t.body">
+)
+
+const (
+	// maxHookBodyBytes bounds the request bodies hooks accept.
+	maxHookBodyBytes = 1 << 20
+	// maxHookWaitSeconds bounds how long a hook request waits for its job.
+	maxHookWaitSeconds = 60
+	// stripeSignatureTolerance is how old a Stripe signature timestamp may be.
+	stripeSignatureTolerance = 5 * time.Minute
+)
+
+// HookRequest is the body of POST and PUT /api/v1/hooks.
+type HookRequest struct {
+	Name            string                 `json:"name"`
+	AgentID         string                 `json:"agent_id" binding:"required"`
+	AgentVersion    int                    `json:"agent_version,omitempty"`    // Optional agent revision; defaults to the latest at each call
+	UserID          string                 `json:"user_id"`                    // Owner the jobs start as; defaults to the caller
+	Inputs          map[string]interface{} `json:"inputs,omitempty"`           // Fixed input values
+	InputMapping    map[string]string      `json:"input_mapping,omitempty"`    // Input key to a dotted path into the request body; "$" is the whole body
+	TimeoutSeconds  int                    `json:"timeout_seconds,omitempty"`  // Job-level deadline
+	SignatureScheme string                 `json:"signature_scheme,omitempty"` // hmac-sha256 (default), github or stripe
+	WaitSeconds     int                    `json:"wait_seconds,omitempty"`     // How long calls wait for the job by default
+}
+
+// Hook is an AgentHook as returned by the hooks endpoints. Secret is only set
+// when the hook is created or its secret rotated.
+type Hook struct {
+	ID string `json:"id"`
+	HookRequest
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// HandleCreateHook creates an AgentHook and its signing secret, which is only
+// returned in this response.
+func HandleCreateHook(c *gin.Context) {
+	path := c.FullPath()
+	metrics.StepCounter.WithLabelValues(path, "api_request_start", "success").Inc()
+
+	var req HookRequest
+	if !bindHookRequest(c, path, &req) {
+		return
+	}
+
+	dynamicClient, err := newDynamicClient()
+	if err != nil {
+		logger.Slog.Error("Failed to create dynamic Kubernetes client", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create Kubernetes dynamic client"})
+		return
+	}
+
+	hookID := "hook-" + generateRandomHash()
+	hook := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "ea.erulabs.ai/v1",
+			"kind":       "AgentHook",
+			"metadata": map[string]interface{}{
+				"name":      hookID,
+				"namespace": agentJobNamespace,
+			},
+			"spec": hookSpec(req, hookID+"-secret"),
+		},
+	}
+	created, err := dynamicClient.Resource(agentHookGVR).Namespace(agentJobNamespace).Create(context.TODO(), hook, metav1.CreateOptions{})
+	if err != nil {
+		metrics.StepCounter.WithLabelValues(path, "k8s_create_error", "error").Inc()
+		logger.Slog.Error("Failed to create AgentHook", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create hook in Kubernetes"})
+		return
+	}
+
+	// 🔹 The Secret is owned by the hook so Kubernetes deletes it with the hook
+	secret, err := writeHookSecret(dynamicClient, created, false)
+	if err != nil {
+		metrics.StepCounter.WithLabelValues(path, "k8s_secret_error", "error").Inc()
+		logger.Slog.Error("Failed to create hook secret", "hook", hookID, "error", err)
+		_ = dynamicClient.Resource(agentHookGVR).Namespace(agentJobNamespace).Delete(context.TODO(), hookID, metav1.DeleteOptions{})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create hook secret"})
+		return
+	}
+
+	metrics.StepCounter.WithLabelValues(path, "create_success", "success").Inc()
+	logger.Slog.Info("AgentHook created", "hook", hookID, "agent_id", req.AgentID, "user", req.UserID)
+	response := hookOf(created)
+	response.Secret = secret
+	c.JSON(http.StatusCreated, response)
+}
+
+// HandleListHooks lists AgentHooks by ID. Non-internal users only see their
+// own hooks. Filters: user_id and agent_id.
+func HandleListHooks(c *gin.Context) {
+	path := c.FullPath()
+	metrics.StepCounter.WithLabelValues(path, "api_hit", "success").Inc()
+
+	authenticatedUserID := c.GetHeader("X-Consumer-Username")
+	if authenticatedUserID == "" {
+		logger.Slog.Error("Missing X-Consumer-Username header")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// 🔹 Non-internal users can only list their own hooks
+	userID := c.Query("user_id")
+	if authenticatedUserID != "internal" {
+		if userID != "" && userID != authenticatedUserID {
+			logger.Slog.Error("User spoofing attempt detected", "authenticated", authenticatedUserID, "requested", userID)
+			metrics.StepCounter.WithLabelValues(path, "user_spoofing_attempt", "failure").Inc()
+			c.JSON(http.StatusForbidden, gin.H{"error": "User ID does not match authenticated user"})
+			return
+		}
+		userID = authenticatedUserID
+	}
+	agentID := c.Query("agent_id")
+
+	dynamicClient, err := newDynamicClient()
+	if err != nil {
+		logger.Slog.Error("Failed to create dynamic Kubernetes client", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create Kubernetes dynamic client"})
+		return
+	}
+
+	list, err := dynamicClient.Resource(agentHookGVR).Namespace(agentJobNamespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		metrics.StepCounter.WithLabelValues(path, "k8s_list_error", "error").Inc()
+		logger.Slog.Error("Failed to list AgentHooks", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list hooks"})
+		return
+	}
+
+	hooks := []Hook{}
+	for i := range list.Items {
+		hook := hookOf(&list.Items[i])
+		if (userID != "" && hook.UserID != userID) || (agentID != "" && hook.AgentID != agentID) {
+			continue
+		}
+		hooks = append(hooks, hook)
+	}
+	sort.Slice(hooks, func(i, j int) bool { return hooks[i].ID < hooks[j].ID })
+
+	metrics.StepCounter.WithLabelValues(path, "retrieval_success", "success").Inc()
+	c.JSON(http.StatusOK, hooks)
+}
+
+// HandleGetHook returns an AgentHook without its secret.
+func HandleGetHook(c *gin.Context) {
+	path := c.FullPath()
+	metrics.StepCounter.WithLabelValues(path, "api_hit", "success").Inc()
+
+	hook, _, ok := loadOwnedResource(c, path, agentHookGVR, "Hook")
+	if !ok {
+		return
+	}
+
+	metrics.StepCounter.WithLabelValues(path, "retrieval_success", "success").Inc()
+	c.JSON(http.StatusOK, hookOf(hook))
+}
+
+// HandleUpdateHook replaces the spec of an AgentHook. Its secret is kept.
+func HandleUpdateHook(c *gin.Context) {
+	path := c.FullPath()
+	metrics.StepCounter.WithLabelValues(path, "api_request_start", "success").Inc()
+
+	hook, dynamicClient, ok := loadOwnedResource(c, path, agentHookGVR, "Hook")
+	if !ok {
+		return
+	}
+
+	var req HookRequest
+	if !bindHookRequest(c, path, &req) {
+		return
+	}
+
+	secretName, _, _ := unstructured.NestedString(hook.Object, "spec", "secretName")
+	hook.Object["spec"] = hookSpec(req, secretName)
+	updated, err := dynamicClient.Resource(agentHookGVR).Namespace(agentJobNamespace).Update(context.TODO(), hook, metav1.UpdateOptions{})
+	if apierrors.IsConflict(err) {
+		metrics.StepCounter.WithLabelValues(path, "k8s_update_conflict", "error").Inc()
+		c.JSON(http.StatusConflict, gin.H{"error": "Hook was modified concurrently, retry the update"})
+		return
+	}
+	if err != nil {
+		metrics.StepCounter.WithLabelValues(path, "k8s_update_error", "error").Inc()
+		logger.Slog.Error("Failed to update AgentHook", "hook", hook.GetName(), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update hook"})
+		return
+	}
+
+	metrics.StepCounter.WithLabelValues(path, "update_success", "success").Inc()
+	logger.Slog.Info("AgentHook updated", "hook", updated.GetName())
+	c.JSON(http.StatusOK, hookOf(updated))
+}
+
+// HandleRotateHookSecret replaces the signing secret of an AgentHook and
+// returns the new one. Requests signed with the old secret are rejected.
+func HandleRotateHookSecret(c *gin.Context) {
+	path := c.FullPath()
+	metrics.StepCounter.WithLabelValues(path, "api_request_start", "success").Inc()
+
+	hook, dynamicClient, ok := loadOwnedResource(c, path, agentHookGVR, "Hook")
+	if !ok {
+		return
+	}
+
+	secret, err := writeHookSecret(dynamicClient, hook, true)
+	if err != nil {
+		metrics.StepCounter.WithLabelValues(path, "k8s_secret_error", "error").Inc()
+		logger.Slog.Error("Failed to rotate hook secret", "hook", hook.GetName(), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rotate hook secret"})
+		return
+	}
+
+	metrics.StepCounter.WithLabelValues(path, "rotate_success", "success").Inc()
+	logger.Slog.Info("AgentHook secret rotated", "hook", hook.GetName())
+	response := hookOf(hook)
+	response.Secret = secret
+	c.JSON(http.StatusOK, response)
+}
+
+// HandleDeleteHook deletes an AgentHook; Kubernetes deletes its Secret with it.
+func HandleDeleteHook(c *gin.Context) {
+	path := c.FullPath()
+	metrics.StepCounter.WithLabelValues(path, "api_request_start", "success").Inc()
+
+	hook, dynamicClient, ok := loadOwnedResource(c, path, agentHookGVR, "Hook")
+	if !ok {
+		return
+	}
+
+	err := dynamicClient.Resource(agentHookGVR).Namespace(agentJobNamespace).Delete(context.TODO(), hook.GetName(), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		metrics.StepCounter.WithLabelValues(path, "k8s_delete_error", "error").Inc()
+		logger.Slog.Error("Failed to delete AgentHook", "hook", hook.GetName(), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete hook"})
+		return
+	}
+
+	metrics.StepCounter.WithLabelValues(path, "delete_success", "success").Inc()
+	logger.Slog.Info("AgentHook deleted", "hook", hook.GetName())
+	c.JSON(http.StatusOK, gin.H{"status": "hook deleted", "id": hook.GetName()})
+}
+
+// HandleTriggerHook starts the agent of a hook for an external caller. The
+// request is authenticated by the HMAC signature of its body rather than by
+// the API gateway. The JSON body is mapped into the agent's inputs. With a
+// wait time (the `wait` query parameter or the hook's default), the response
+// holds the job's final state and node outputs if it finishes in time.
+func HandleTriggerHook(c *gin.Context) {
+	path := c.FullPath()
+	metrics.StepCounter.WithLabelValues(path, "api_hit", "success").Inc()
+
+	body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxHookBodyBytes))
+	if err != nil {
+		metrics.StepCounter.WithLabelValues(path, "invalid_request_body", "error").Inc()
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body too large"})
+		return
+	}
+
+	dynamicClient, err := newDynamicClient()
+	if err != nil {
+		logger.Slog.Error("Failed to create dynamic Kubernetes client", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create Kubernetes dynamic client"})
+		return
+	}
+
+	// 🔹 Load the hook and check the signature before looking at the body
+	hookID := c.Param("name")
+	hook, err := dynamicClient.Resource(agentHookGVR).Namespace(agentJobNamespace).Get(context.TODO(), hookID, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		metrics.StepCounter.WithLabelValues(path, "hook_not_found", "error").Inc()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Hook not found"})
+		return
+	}
+	if err != nil {
+		metrics.StepCounter.WithLabelValues(path, "k8s_get_error", "error").Inc()
+		logger.Slog.Error("Failed to get AgentHook", "hook", hookID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve hook"})
+		return
+	}
+	spec := hookOf(hook)
+
+	secret, err := readHookSecret(dynamicClient, hook)
+	if err != nil {
+		metrics.StepCounter.WithLabelValues(path, "k8s_secret_error", "error").Inc()
+		logger.Slog.Error("Failed to read hook secret", "hook", hookID, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve hook"})
+		return
+	}
+	if err := verifyHookSignature(spec.SignatureScheme, secret, c.Request.Header, body, time.Now()); err != nil {
+		metrics.StepCounter.WithLabelValues(path, "invalid_signature", "failure").Inc()
+		logger.Slog.Warn("Rejected hook request", "hook", hookID, "reason", err)
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Invalid signature"})
+		return
+	}
+
+	wait := spec.WaitSeconds
+	if value := c.Query("wait"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 || n > maxHookWaitSeconds {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("wait must be between 0 and %d seconds", maxHookWaitSeconds)})
+			return
+		}
+		wait = n
+	}
+
+	// 🔹 Map the body into inputs on top of the hook's fixed inputs
+	var payload interface{} = map[string]interface{}{}
+	if len(strings.TrimSpace(string(body))) > 0 {
+		if err := json.Unmarshal(body, &payload); err != nil {
+			metrics.StepCounter.WithLabelValues(path, "invalid_request_body", "error").Inc()
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Request body must be JSON"})
+			return
+		}
+	}
+	inputs := make(map[string]interface{}, len(spec.Inputs)+len(spec.InputMapping))
+	for key, value := range spec.Inputs {
+		inputs[key] = value
+	}
+	for key, bodyPath := range spec.InputMapping {
+		if value, ok := lookupJSONPath(payload, bodyPath); ok {
+			inputs[key] = value
+		}
+	}
+
+	// 🔹 Start the job as the hook's owner
+	job, dynamicClient, err := createAgentJob(agentJobSpec{
+		AgentID:          spec.AgentID,
+		AgentVersion:     spec.AgentVersion,
+		UserID:           spec.UserID,
+		AgentManagerUser: spec.UserID,
+		Inputs:           inputs,
+		TimeoutSeconds:   spec.TimeoutSeconds,
+		Labels:           map[string]string{hookLabel: hookID},
+	})
+	if err != nil {
+		respondJobCreationError(c, path, err)
+		return
+	}
+	metrics.StepCounter.WithLabelValues(path, "job_created", "success").Inc()
+	logger.Slog.Info("AgentJob created by hook", "hook", hookID, "job", job.GetName())
+
+	if wait == 0 {
+		c.JSON(http.StatusAccepted, gin.H{"status": "job created", "job_name": job.GetName()})
+		return
+	}
+
+	// 🔹 Wait for the job to finish, returning what it has so far on timeout
+	ctx, cancel := context.WithTimeout(c.Request.Context(), time.Duration(wait)*time.Second)
+	defer cancel()
+	job, err = waitForAgentJob(ctx, dynamicClient, job)
+	if err != nil && ctx.Err() == nil {
+		metrics.StepCounter.WithLabelValues(path, "job_wait_error", "error").Inc()
+		logger.Slog.Error("Failed to wait for hook job", "hook", hookID, "job", job.GetName(), "error", err)
+	}
+	if !jobFinished(job) {
+		c.JSON(http.StatusAccepted, gin.H{"status": "job running", "job_name": job.GetName(), "state": summarizeAgentJob(job).State})
+		return
+	}
+	c.JSON(http.StatusOK, jobDetailOf(job))
+}
+
+// waitForAgentJob watches job until it finishes or ctx is done, and returns its
+// last known version.
+func waitForAgentJob(ctx context.Context, dynamicClient dynamic.Interface, job *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+	for !jobFinished(job) {
+		watcher, err := dynamicClient.Resource(agentJobGVR).Namespace(agentJobNamespace).Watch(ctx, metav1.ListOptions{
+			FieldSelector:   fields.OneTermEqualSelector("metadata.name", job.GetName()).String(),
+			ResourceVersion: job.GetResourceVersion(),
+		})
+		if err != nil {
+			return job, err
+		}
+
+		resync := false
+	events:
+		for {
+			select {
+			case <-ctx.Done():
+				watcher.Stop()
+				return job, ctx.Err()
+			case event, ok := <-watcher.ResultChan():
+				if !ok {
+					break events
+				}
+				switch event.Type {
+				case watch.Error:
+					resync = true
+					break events
+				case watch.Deleted:
+					watcher.Stop()
+					return job, nil
+				case watch.Added, watch.Modified:
+					if current, ok := event.Object.(*unstructured.Unstructured); ok {
+						job = current
+						if jobFinished(job) {
+							break events
+						}
+					}
+				}
+			}
+		}
+		watcher.Stop()
+
+		// 🔹 The version we watched from is gone; read the job again
+		if resync {
+			current, err := dynamicClient.Resource(agentJobGVR).Namespace(agentJobNamespace).Get(ctx, job.GetName(), metav1.GetOptions{})
+			if err != nil {
+				return job, err
+			}
+			job = current
+		}
+	}
+	return job, nil
+}
+
+// bindHookRequest parses and validates a hook request, fills in its defaults
+// and checks that its owner can run the agent and that it only sets declared
+// inputs. It writes the error response on failure.
+func bindHookRequest(c *gin.Context, path string, req *HookRequest) bool {
+	authenticatedUserID := c.GetHeader("X-Consumer-Username")
+	if authenticatedUserID == "" {
+		logger.Slog.Error("Missing X-Consumer-Username header")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return false
+	}
+
+	if err := c.ShouldBindJSON(req); err != nil {
+		logger.Slog.Error("Invalid hook request body", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return false
+	}
+
+	// 🔹 Hooks run as their owner, who must be the caller
+	if req.UserID == "" {
+		req.UserID = authenticatedUserID
+	}
+	if authenticatedUserID != "internal" && req.UserID != authenticatedUserID {
+		logger.Slog.Error("User spoofing attempt detected", "authenticated", authenticatedUserID, "requested", req.UserID)
+		metrics.StepCounter.WithLabelValues(path, "user_spoofing_attempt", "failure").Inc()
+		c.JSON(http.StatusForbidden, gin.H{"error": "User ID does not match authenticated user"})
+		return false
+	}
+	if req.UserID == "internal" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Internal callers must set user_id"})
+		return false
+	}
+
+	// 🔹 Fill in defaults, then validate the hook itself
+	if req.SignatureScheme == "" {
+		req.SignatureScheme = signatureHMAC
+	}
+	var problems []string
+	switch req.SignatureScheme {
+	case signatureHMAC, signatureGitHub, signatureStripe:
+	default:
+		problems = append(problems, fmt.Sprintf("signature_scheme: must be %s, %s or %s", signatureHMAC, signatureGitHub, signatureStripe))
+	}
+	if req.WaitSeconds < 0 || req.WaitSeconds > maxHookWaitSeconds {
+		problems = append(problems, fmt.Sprintf("wait_seconds: must be between 0 and %d", maxHookWaitSeconds))
+	}
+	if req.TimeoutSeconds < 0 {
+		problems = append(problems, "timeout_seconds: must be positive")
+	}
+	if req.AgentVersion < 0 {
+		problems = append(problems, "agent_version: must be positive")
+	}
+	if len(problems) > 0 {
+		metrics.StepCounter.WithLabelValues(path, "invalid_hook", "error").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid hook", "details": problems})
+		return false
+	}
+
+	// 🔹 Required inputs may come from the body, so only check that every input is declared
+	agent, status, err := fetchAgent(c.GetHeader("Authorization"), req.UserID, req.AgentID, req.AgentVersion)
+	if err != nil {
+		logger.Slog.Error("Failed to retrieve agent", "agent_id", req.AgentID, "error", err)
+		c.JSON(status, gin.H{"error": "Failed to retrieve agent"})
+		return false
+	}
+	declared := make(map[string]bool, len(agent.Inputs))
+	for _, input := range agent.Inputs {
+		declared[input.Key] = true
+	}
+	for key := range req.Inputs {
+		if !declared[key] {
+			problems = append(problems, fmt.Sprintf("inputs: %q is not an input of the agent", key))
+		}
+	}
+	for key := range req.InputMapping {
+		if !declared[key] {
+			problems = append(problems, fmt.Sprintf("input_mapping: %q is not an input of the agent", key))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		metrics.StepCounter.WithLabelValues(path, "invalid_inputs", "error").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job inputs", "details": problems})
+		return false
+	}
+	if req.Name == "" {
+		req.Name = agent.Name
+	}
+	return true
+}
+
+// hookSpec is the AgentHook spec of a validated request.
+func hookSpec(req HookRequest, secretName string) map[string]interface{} {
+	spec := map[string]interface{}{
+		"name":            req.Name,
+		"agentID":         req.AgentID,
+		"user":            req.UserID,
+		"signatureScheme": req.SignatureScheme,
+		"secretName":      secretName,
+	}
+	if req.AgentVersion > 0 {
+		spec["agentVersion"] = int64(req.AgentVersion)
+	}
+	if req.TimeoutSeconds > 0 {
+		spec["timeoutSeconds"] = int64(req.TimeoutSeconds)
+	}
+	if req.WaitSeconds > 0 {
+		spec["waitSeconds"] = int64(req.WaitSeconds)
+	}
+	if len(req.Inputs) > 0 {
+		spec["inputs"] = req.Inputs
+	}
+	if len(req.InputMapping) > 0 {
+		mapping := make(map[string]interface{}, len(req.InputMapping))
+		for key, bodyPath := range req.InputMapping {
+			mapping[key] = bodyPath
+		}
+		spec["inputMapping"] = mapping
+	}
+	return spec
+}
+
+// hookOf reads the spec of an AgentHook.
+func hookOf(obj *unstructured.Unstructured) Hook {
+	hook := Hook{ID: obj.GetName(), URL: "/api/v1/hooks/" + obj.GetName(), CreatedAt: obj.GetCreationTimestamp().Time}
+
+	spec, _, _ := unstructured.NestedMap(obj.Object, "spec")
+	hook.Name, _ = spec["name"].(string)
+	hook.AgentID, _ = spec["agentID"].(string)
+	hook.UserID, _ = spec["user"].(string)
+	hook.SignatureScheme, _ = spec["signatureScheme"].(string)
+	hook.Inputs, _ = spec["inputs"].(map[string]interface{})
+	hook.InputMapping, _, _ = unstructured.NestedStringMap(obj.Object, "spec", "inputMapping")
+	agentVersion, _, _ := unstructured.NestedInt64(obj.Object, "spec", "agentVersion")
+	hook.AgentVersion = int(agentVersion)
+	timeoutSeconds, _, _ := unstructured.NestedInt64(obj.Object, "spec", "timeoutSeconds")
+	hook.TimeoutSeconds = int(timeoutSeconds)
+	waitSeconds, _, _ := unstructured.NestedInt64(obj.Object, "spec", "waitSeconds")
+	hook.WaitSeconds = int(waitSeconds)
+	return hook
+}
+
+// writeHookSecret generates a new signing secret for hook and stores it in the
+// hook's Secret, creating the Secret unless replace is set.
+func writeHookSecret(dynamicClient dynamic.Interface, hook *unstructured.Unstructured, replace bool) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	secret := hex.EncodeToString(b)
+
+	secretName, _, _ := unstructured.NestedString(hook.Object, "spec", "secretName")
+	obj := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata": map[string]interface{}{
+				"name":      secretName,
+				"namespace": agentJobNamespace,
+				"labels":    map[string]interface{}{hookLabel: hook.GetName()},
+				"ownerReferences": []interface{}{
+					map[string]interface{}{
+						"apiVersion": "ea.erulabs.ai/v1",
+						"kind":       "AgentHook",
+						"name":       hook.GetName(),
+						"uid":        string(hook.GetUID()),
+					},
+				},
+			},
+			"type":       "Opaque",
+			"stringData": map[string]interface{}{hookSecretKey: secret},
+		},
+	}
+
+	secrets := dynamicClient.Resource(secretGVR).Namespace(agentJobNamespace)
+	if !replace {
+		_, err := secrets.Create(context.TODO(), obj, metav1.CreateOptions{})
+		return secret, err
+	}
+	current, err := secrets.Get(context.TODO(), secretName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = secrets.Create(context.TODO(), obj, metav1.CreateOptions{})
+		return secret, err
+	}
+	if err != nil {
+		return "", err
+	}
+	obj.SetResourceVersion(current.GetResourceVersion())
+	_, err = secrets.Update(context.TODO(), obj, metav1.UpdateOptions{})
+	return secret, err
+}
+
+// readHookSecret returns the signing secret of hook.
+func readHookSecret(dynamicClient dynamic.Interface, hook *unstructured.Unstructured) ([]byte, error) {
+	secretName, _, _ := unstructured.NestedString(hook.Object, "spec", "secretName")
+	secret, err := dynamicClient.Resource(secretGVR).Namespace(agentJobNamespace).Get(context.TODO(), secretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	encoded, _, _ := unstructured.NestedString(secret.Object, "data", hookSecretKey)
+	value, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(value) == 0 {
+		return nil, fmt.Errorf("secret %s has no %q key", secretName, hookSecretKey)
+	}
+	return value, nil
+}
+
+// verifyHookSignature checks the signature of body in the headers of the given
+// scheme. The error says why a request was rejected and is only logged.
+func verifyHookSignature(scheme string, secret []byte, header http.Header, body []byte, now time.Time) error {
+	switch scheme {
+	case signatureStripe:
+		// Stripe-Signature: t=<unix time>,v1=<signature>[,v1=<signature>...]
+		value := header.Get("Stripe-Signature")
+		if value == "" {
+			return fmt.Errorf("missing Stripe-Signature header")
+		}
+		var timestamp string
+		var signatures []string
+		for _, part := range strings.Split(value, ",") {
+			key, val, _ := strings.Cut(strings.TrimSpace(part), "=")
+			switch key {
+			case "t":
+				timestamp = val
+			case "v1":
+				signatures = append(signatures, val)
+			}
+		}
+		seconds, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid Stripe-Signature timestamp")
+		}
+		if age := now.Sub(time.Unix(seconds, 0)); age > stripeSignatureTolerance || age < -stripeSignatureTolerance {
+			return fmt.Errorf("Stripe-Signature timestamp outside the tolerance")
+		}
+		expected := hmacSHA256(secret, []byte(timestamp+"."+string(body)))
+		for _, signature := range signatures {
+			if hmacEqualHex(expected, signature) {
+				return nil
+			}
+		}
+		return fmt.Errorf("no matching Stripe-Signature")
+
+	default:
+		headerName := "X-Ea-Signature-256"
+		if scheme == signatureGitHub {
+			headerName = "X-Hub-Signature-256"
+		}
+		value := header.Get(headerName)
+		if value == "" {
+			return fmt.Errorf("missing %s header", headerName)
+		}
+		if !hmacEqualHex(hmacSHA256(secret, body), strings.TrimPrefix(value, "sha256=")) {
+			return fmt.Errorf("%s does not match", headerName)
+		}
+		return nil
+	}
+}
+
+func hmacSHA256(secret, message []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(message)
+	return mac.Sum(nil)
+}
+
+// hmacEqualHex compares a MAC with a hex-encoded signature in constant time.
+func hmacEqualHex(expected []byte, signature string) bool {
+	decoded, err := hex.DecodeString(signature)
+	return err == nil && hmac.Equal(expected, decoded)
+}
+
+// lookupJSONPath returns the value at a dotted path such as
+// "pull_request.head.ref" or "items.0.id" in a decoded JSON document. The path
+// "$" is the whole document.
+func lookupJSONPath(document interface{}, path string) (interface{}, bool) {
+	if path == "$" {
+		return document, true
+	}
+	current := document
+	for _, segment := range strings.Split(strings.TrimPrefix(path, "$."), ".") {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			value, ok := node[segment]
+			if !ok {
+				return nil, false
+			}
+			current = value
+		case []interface{}:
+			index, err := strconv.Atoi(segment)
+			if err != nil || index < 0 || index >= len(node) {
+				return nil, false
+			}
+			current = node[index]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// AgentInput is an input an agent declares; nodes reference it as {{inputs.key}}.
+type AgentInput struct {
+	Key         string        `json:"key"`
+	Type        string        `json:"type"`
+	Description string        `json:"description,omitempty"`
+	Default     interface{}   `json:"default,omitempty"`
+	Enum        []interface{} `json:"enum,omitempty"`
+	Required    bool          `json:"required,omitempty"`
+}
+
+// resolveJobInputs checks the inputs of a job request against the agent's
+// declared inputs and fills in defaults. It returns every problem found.
+func resolveJobInputs(declared []AgentInput, provided map[string]interface{}) (map[string]interface{}, []string) {
+	var problems []string
+	resolved := make(map[string]interface{}, len(declared))
+
+	known := make(map[string]bool, len(declared))
+	for _, input := range declared {
+		known[input.Key] = true
+
+		value, present := provided[input.Key]
+		if !present || value == nil {
+			switch {
+			case input.Default != nil:
+				resolved[input.Key] = input.Default
+			case input.Required:
+				problems = append(problems, fmt.Sprintf("input %q is required", input.Key))
+			}
+			continue
+		}
+
+		if !inputTypeMatches(input.Type, value) {
+			problems = append(problems, fmt.Sprintf("input %q must be of type %s", input.Key, input.Type))
+			continue
+		}
+		if len(input.Enum) > 0 && !inputEnumContains(input.Enum, value) {
+			problems = append(problems, fmt.Sprintf("input %q must be one of %v", input.Key, input.Enum))
+			continue
+		}
+		resolved[input.Key] = value
+	}
+
+	// Undeclared inputs are rejected so typos do not silently fall back to defaults
+	var unknown []string
+	for key := range provided {
+		if !known[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		problems = append(problems, fmt.Sprintf("input %q is not declared by the agent", key))
+	}
+
+	return resolved, problems
+}
+
+// inputTypeMatches reports whether a JSON-decoded value has the declared input type.
+func inputTypeMatches(inputType string, value interface{}) bool {
+	switch inputType {
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f)
+	case "bool", "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	}
+	return true
+}
+
+func inputEnumContains(enum []interface{}, value interface{}) bool {
+	for _, allowed := range enum {
+		if fmt.Sprint(allowed) == fmt.Sprint(value) {
+			return true
+		}
+	}
+	return false
+}
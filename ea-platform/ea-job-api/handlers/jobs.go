@@ -0,0 +1,386 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ea-job-api/logger"
+	"ea-job-api/metrics"
+
+	"github.com/gin-gonic/gin"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+// agentJobNamespace is the namespace AgentJobs and their executor Jobs run in.
+const agentJobNamespace = "ea-platform"
+
+// agentJobGVR is the GroupVersionResource of the AgentJob CRD.
+var agentJobGVR = schema.GroupVersionResource{
+	Group:    "ea.erulabs.ai",
+	Version:  "v1",
+	Resource: "agentjobs",
+}
+
+// rerunOfAnnotation records the AgentJob a re-run was cloned from.
+const rerunOfAnnotation = "ea.erulabs.ai/rerun-of"
+
+// terminalJobStates are AgentJob states after which nothing runs any more.
+var terminalJobStates = map[string]bool{
+	"completed": true,
+	"error":     true,
+	"cancelled": true,
+}
+
+// JobSummary is an AgentJob as listed by GET /api/v1/jobs.
+type JobSummary struct {
+	Name         string    `json:"name"`
+	AgentID      string    `json:"agent_id"`
+	AgentVersion int64     `json:"agent_version,omitempty"`
+	User         string    `json:"user"`
+	State        string    `json:"state"`
+	Message      string    `json:"message,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// JobNodeStatus is the status and output of one node of an AgentJob.
+type JobNodeStatus struct {
+	Alias       string      `json:"alias"`
+	Status      string      `json:"status"`
+	Output      interface{} `json:"output,omitempty"`
+	LastUpdated string      `json:"last_updated,omitempty"`
+}
+
+// JobDetail is an AgentJob as returned by GET /api/v1/jobs/:name.
+type JobDetail struct {
+	JobSummary
+	AgentName string                 `json:"agent_name,omitempty"`
+	Creator   string                 `json:"creator,omitempty"`
+	Inputs    map[string]interface{} `json:"inputs,omitempty"`
+	RerunOf   string                 `json:"rerun_of,omitempty"`
+	Nodes     []JobNodeStatus        `json:"nodes"`
+}
+
+// newDynamicClient returns a dynamic client for the cluster the API runs in.
+func newDynamicClient() (dynamic.Interface, error) {
+	k8sConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	return dynamic.NewForConfig(k8sConfig)
+}
+
+// HandleListJobs lists AgentJobs, newest first. Non-internal users only see
+// their own jobs. Filters: user_id, agent_id, state, created_after and
+// created_before (RFC 3339), and limit.
+func HandleListJobs(c *gin.Context) {
+	path := c.FullPath()
+	metrics.StepCounter.WithLabelValues(path, "api_hit", "success").Inc()
+
+	authenticatedUserID := c.GetHeader("X-Consumer-Username")
+	if authenticatedUserID == "" {
+		logger.Slog.Error("Missing X-Consumer-Username header")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// 🔹 Non-internal users can only list their own jobs
+	userID := c.Query("user_id")
+	if authenticatedUserID != "internal" {
+		if userID != "" && userID != authenticatedUserID {
+			logger.Slog.Error("User spoofing attempt detected", "authenticated", authenticatedUserID, "requested", userID)
+			metrics.StepCounter.WithLabelValues(path, "user_spoofing_attempt", "failure").Inc()
+			c.JSON(http.StatusForbidden, gin.H{"error": "User ID does not match authenticated user"})
+			return
+		}
+		userID = authenticatedUserID
+	}
+
+	// 🔹 Parse the remaining filters
+	var createdAfter, createdBefore time.Time
+	for param, target := range map[string]*time.Time{"created_after": &createdAfter, "created_before": &createdBefore} {
+		if value := c.Query(param); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%s must be an RFC 3339 timestamp", param)})
+				return
+			}
+			*target = t
+		}
+	}
+	limit := 0
+	if value := c.Query("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive integer"})
+			return
+		}
+		limit = n
+	}
+	agentID := c.Query("agent_id")
+	state := c.Query("state")
+
+	dynamicClient, err := newDynamicClient()
+	if err != nil {
+		logger.Slog.Error("Failed to create dynamic Kubernetes client", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create Kubernetes dynamic client"})
+		return
+	}
+
+	// Spec fields cannot be used as field selectors, so filtering happens here
+	list, err := dynamicClient.Resource(agentJobGVR).Namespace(agentJobNamespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		metrics.StepCounter.WithLabelValues(path, "k8s_list_error", "error").Inc()
+		logger.Slog.Error("Failed to list AgentJobs", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list jobs"})
+		return
+	}
+
+	jobs := []JobSummary{}
+	for i := range list.Items {
+		job := summarizeAgentJob(&list.Items[i])
+		switch {
+		case userID != "" && job.User != userID,
+			agentID != "" && job.AgentID != agentID,
+			state != "" && job.State != state,
+			!createdAfter.IsZero() && job.CreatedAt.Before(createdAfter),
+			!createdBefore.IsZero() && !job.CreatedAt.Before(createdBefore):
+			continue
+		}
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		if !jobs[i].CreatedAt.Equal(jobs[j].CreatedAt) {
+			return jobs[i].CreatedAt.After(jobs[j].CreatedAt)
+		}
+		return jobs[i].Name < jobs[j].Name
+	})
+	if limit > 0 && len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+
+	metrics.StepCounter.WithLabelValues(path, "retrieval_success", "success").Inc()
+	logger.Slog.Info("Jobs listed", "user", authenticatedUserID, "count", len(jobs))
+	c.JSON(http.StatusOK, jobs)
+}
+
+// HandleGetJob returns an AgentJob with the status and output of each node.
+func HandleGetJob(c *gin.Context) {
+	path := c.FullPath()
+	metrics.StepCounter.WithLabelValues(path, "api_hit", "success").Inc()
+
+	job, _, ok := loadOwnedAgentJob(c, path)
+	if !ok {
+		return
+	}
+
+	metrics.StepCounter.WithLabelValues(path, "retrieval_success", "success").Inc()
+	c.JSON(http.StatusOK, jobDetailOf(job))
+}
+
+// HandleCancelJob requests the cancellation of a job by setting
+// spec.cancelRequested. The operator stops the executor, which reports its
+// in-flight nodes as Cancelled, and marks the AgentJob cancelled.
+func HandleCancelJob(c *gin.Context) {
+	path := c.FullPath()
+	metrics.StepCounter.WithLabelValues(path, "api_request_start", "success").Inc()
+
+	job, dynamicClient, ok := loadOwnedAgentJob(c, path)
+	if !ok {
+		return
+	}
+	jobName := job.GetName()
+	authenticatedUserID := c.GetHeader("X-Consumer-Username")
+
+	state, _, _ := unstructured.NestedString(job.Object, "status", "state")
+	if terminalJobStates[state] {
+		metrics.StepCounter.WithLabelValues(path, "job_already_finished", "error").Inc()
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Job is already %s", state)})
+		return
+	}
+
+	// 🔹 A merge patch leaves the rest of the spec alone and cannot conflict
+	patch := []byte(`{"spec":{"cancelRequested":true}}`)
+	_, err := dynamicClient.Resource(agentJobGVR).Namespace(agentJobNamespace).Patch(context.TODO(), jobName, types.MergePatchType, patch, metav1.PatchOptions{})
+	if apierrors.IsNotFound(err) {
+		metrics.StepCounter.WithLabelValues(path, "job_not_found", "error").Inc()
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return
+	}
+	if err != nil {
+		metrics.StepCounter.WithLabelValues(path, "k8s_patch_error", "error").Inc()
+		logger.Slog.Error("Failed to request AgentJob cancellation", "job", jobName, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel job"})
+		return
+	}
+
+	metrics.StepCounter.WithLabelValues(path, "cancel_success", "success").Inc()
+	logger.Slog.Info("AgentJob cancellation requested", "job", jobName, "user", authenticatedUserID)
+	c.JSON(http.StatusAccepted, gin.H{"status": "cancellation requested", "job_name": jobName})
+}
+
+// HandleRerunJob starts a new AgentJob with the spec of an existing one, so it
+// runs the same agent revision with the same inputs.
+func HandleRerunJob(c *gin.Context) {
+	path := c.FullPath()
+	metrics.StepCounter.WithLabelValues(path, "api_request_start", "success").Inc()
+
+	job, dynamicClient, ok := loadOwnedAgentJob(c, path)
+	if !ok {
+		return
+	}
+
+	spec, found, err := unstructured.NestedMap(job.Object, "spec")
+	if err != nil || !found {
+		logger.Slog.Error("Failed to read AgentJob spec", "job", job.GetName(), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Job has no spec"})
+		return
+	}
+	agentID, _ := spec["agentID"].(string)
+
+	// 🔹 Clone the spec with fresh timestamps; status starts empty
+	delete(spec, "cancelRequested")
+	spec["metadata"] = map[string]interface{}{
+		"created_at": time.Now().Format(time.RFC3339),
+		"updated_at": time.Now().Format(time.RFC3339),
+	}
+	jobName := fmt.Sprintf("agentjob-%s-%s", agentID, generateRandomHash())
+	rerun := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "ea.erulabs.ai/v1",
+			"kind":       "AgentJob",
+			"metadata": map[string]interface{}{
+				"name":      jobName,
+				"namespace": agentJobNamespace,
+				"annotations": map[string]interface{}{
+					rerunOfAnnotation: job.GetName(),
+				},
+			},
+			"spec": spec,
+		},
+	}
+
+	if _, err := dynamicClient.Resource(agentJobGVR).Namespace(agentJobNamespace).Create(context.TODO(), rerun, metav1.CreateOptions{}); err != nil {
+		metrics.StepCounter.WithLabelValues(path, "k8s_create_error", "error").Inc()
+		logger.Slog.Error("Failed to create re-run AgentJob", "job", job.GetName(), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create job in Kubernetes"})
+		return
+	}
+
+	metrics.StepCounter.WithLabelValues(path, "rerun_success", "success").Inc()
+	logger.Slog.Info("AgentJob re-run created", "job", jobName, "rerun_of", job.GetName())
+	user, _ := spec["user"].(string)
+	c.JSON(http.StatusAccepted, gin.H{"status": "job created", "job_name": jobName, "rerun_of": job.GetName(), "user_id": user})
+}
+
+// loadOwnedAgentJob fetches the AgentJob named in the path. Non-internal users
+// may only access jobs they started. It writes the error response on failure.
+func loadOwnedAgentJob(c *gin.Context, path string) (*unstructured.Unstructured, dynamic.Interface, bool) {
+	return loadOwnedResource(c, path, agentJobGVR, "Job")
+}
+
+// loadOwnedResource fetches the resource named in the path, which must belong
+// to the caller (spec.user) unless the caller is internal. kind names the
+// resource in error messages. It writes the error response on failure.
+func loadOwnedResource(c *gin.Context, path string, gvr schema.GroupVersionResource, kind string) (*unstructured.Unstructured, dynamic.Interface, bool) {
+	authenticatedUserID := c.GetHeader("X-Consumer-Username")
+	if authenticatedUserID == "" {
+		logger.Slog.Error("Missing X-Consumer-Username header")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return nil, nil, false
+	}
+
+	dynamicClient, err := newDynamicClient()
+	if err != nil {
+		logger.Slog.Error("Failed to create dynamic Kubernetes client", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create Kubernetes dynamic client"})
+		return nil, nil, false
+	}
+
+	notFoundStep := strings.ToLower(kind) + "_not_found"
+	name := c.Param("name")
+	obj, err := dynamicClient.Resource(gvr).Namespace(agentJobNamespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		metrics.StepCounter.WithLabelValues(path, notFoundStep, "error").Inc()
+		c.JSON(http.StatusNotFound, gin.H{"error": kind + " not found"})
+		return nil, nil, false
+	}
+	if err != nil {
+		metrics.StepCounter.WithLabelValues(path, "k8s_get_error", "error").Inc()
+		logger.Slog.Error("Failed to get resource", "resource", gvr.Resource, "name", name, "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to retrieve " + strings.ToLower(kind)})
+		return nil, nil, false
+	}
+
+	// Report other users' resources as missing rather than revealing that they exist
+	user, _, _ := unstructured.NestedString(obj.Object, "spec", "user")
+	if authenticatedUserID != "internal" && user != authenticatedUserID {
+		logger.Slog.Warn("Access to another user's resource denied", "resource", gvr.Resource, "name", name, "authenticated", authenticatedUserID)
+		metrics.StepCounter.WithLabelValues(path, notFoundStep, "error").Inc()
+		c.JSON(http.StatusNotFound, gin.H{"error": kind + " not found"})
+		return nil, nil, false
+	}
+
+	return obj, dynamicClient, true
+}
+
+// jobDetailOf reads an AgentJob with the status and output of each node.
+func jobDetailOf(job *unstructured.Unstructured) JobDetail {
+	detail := JobDetail{JobSummary: summarizeAgentJob(job), Nodes: []JobNodeStatus{}}
+	detail.AgentName, _, _ = unstructured.NestedString(job.Object, "spec", "name")
+	detail.Creator, _, _ = unstructured.NestedString(job.Object, "spec", "creator")
+	detail.Inputs, _, _ = unstructured.NestedMap(job.Object, "spec", "inputs")
+	detail.RerunOf = job.GetAnnotations()[rerunOfAnnotation]
+
+	nodes, _, _ := unstructured.NestedSlice(job.Object, "status", "nodes")
+	for _, n := range nodes {
+		if node, ok := n.(map[string]interface{}); ok {
+			detail.Nodes = append(detail.Nodes, nodeStatusOf(node))
+		}
+	}
+	return detail
+}
+
+// summarizeAgentJob reads the listed fields of an AgentJob.
+func summarizeAgentJob(job *unstructured.Unstructured) JobSummary {
+	summary := JobSummary{Name: job.GetName(), CreatedAt: job.GetCreationTimestamp().Time}
+	summary.AgentID, _, _ = unstructured.NestedString(job.Object, "spec", "agentID")
+	summary.AgentVersion, _, _ = unstructured.NestedInt64(job.Object, "spec", "agentVersion")
+	summary.User, _, _ = unstructured.NestedString(job.Object, "spec", "user")
+	summary.State, _, _ = unstructured.NestedString(job.Object, "status", "state")
+	summary.Message, _, _ = unstructured.NestedString(job.Object, "status", "message")
+	if summary.State == "" {
+		summary.State = "pending"
+	}
+	return summary
+}
+
+// nodeStatusOf reads a node entry of an AgentJob's status.
+func nodeStatusOf(node map[string]interface{}) JobNodeStatus {
+	status := JobNodeStatus{}
+	status.Alias, _ = node["alias"].(string)
+	status.Status, _ = node["status"].(string)
+	status.LastUpdated, _ = node["lastUpdated"].(string)
+
+	// Outputs are stored as JSON strings on the CR
+	if output, _ := node["output"].(string); output != "" {
+		var decoded interface{}
+		if err := json.Unmarshal([]byte(output), &decoded); err == nil {
+			status.Output = decoded
+		} else {
+			status.Output = output
+		}
+	}
+	return status
+}
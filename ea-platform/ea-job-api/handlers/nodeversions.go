@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"ea-job-api/config"
+)
+
+// resolvedNodeVersions is the Agent Manager's answer to a node version resolution.
+type resolvedNodeVersions struct {
+	Nodes map[string]struct {
+		Type    string `json:"type"`
+		Version string `json:"version"`
+	} `json:"nodes"`
+	Errors map[string]string `json:"errors"`
+}
+
+// pinNodeVersions rewrites the type of every node, including the nodes of
+// foreach graphs, to the exact "type@version" the Agent Manager resolves it to
+// for the agent's creator. A job then runs the same definitions even when its
+// executor restarts after newer versions were published. Types without a
+// definition, such as built-in executor nodes, are kept. It returns the types
+// no published version satisfies.
+func pinNodeVersions(nodes []map[string]interface{}, creator string, groups []string) ([]string, error) {
+	types := make(map[string]bool)
+	collectJobNodeTypes(nodes, types)
+	if len(types) == 0 {
+		return nil, nil
+	}
+
+	query := url.Values{}
+	for nodeType := range types {
+		query.Add("type", nodeType)
+	}
+	req, err := http.NewRequest("GET", config.LoadConfig().AgentManagerNodesUrl+"resolve?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	// Resolve with the creator's access, as the executor does when the job runs
+	req.Header.Set("X-Consumer-Username", creator)
+	if len(groups) > 0 {
+		req.Header.Set("X-Consumer-Groups", strings.Join(groups, ","))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach agent manager: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("agent manager returned status %d", resp.StatusCode)
+	}
+
+	var resolved resolvedNodeVersions
+	if err := json.NewDecoder(resp.Body).Decode(&resolved); err != nil {
+		return nil, fmt.Errorf("invalid node version data: %w", err)
+	}
+
+	var problems []string
+	for nodeType, message := range resolved.Errors {
+		problems = append(problems, fmt.Sprintf("node type %s: %s", nodeType, message))
+	}
+	sort.Strings(problems)
+	if len(problems) > 0 {
+		return problems, nil
+	}
+
+	pinned := make(map[string]string, len(resolved.Nodes))
+	for nodeType, def := range resolved.Nodes {
+		pinned[nodeType] = def.Type + "@" + def.Version
+	}
+	pinJobNodeTypes(nodes, pinned)
+	return nil, nil
+}
+
+// collectJobNodeTypes gathers the node types of nodes and their foreach graphs.
+func collectJobNodeTypes(nodes []map[string]interface{}, types map[string]bool) {
+	for _, node := range nodes {
+		if nodeType, ok := node["type"].(string); ok && nodeType != "" {
+			types[nodeType] = true
+		}
+		collectJobNodeTypes(foreachJobNodes(node), types)
+	}
+}
+
+// pinJobNodeTypes replaces the node types of nodes and their foreach graphs.
+func pinJobNodeTypes(nodes []map[string]interface{}, pinned map[string]string) {
+	for _, node := range nodes {
+		if nodeType, ok := node["type"].(string); ok {
+			if exact, ok := pinned[nodeType]; ok {
+				node["type"] = exact
+			}
+		}
+		pinJobNodeTypes(foreachJobNodes(node), pinned)
+	}
+}
+
+// foreachJobNodes returns the nested nodes of a foreach node, which are kept
+// in its "nodes" parameter.
+func foreachJobNodes(node map[string]interface{}) []map[string]interface{} {
+	parameters, _ := node["parameters"].(map[string]interface{})
+	nested, _ := parameters["nodes"].([]interface{})
+	var nodes []map[string]interface{}
+	for _, n := range nested {
+		if nestedNode, ok := n.(map[string]interface{}); ok {
+			nodes = append(nodes, nestedNode)
+		}
+	}
+	return nodes
+}
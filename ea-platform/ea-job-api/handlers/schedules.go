@@ -0,0 +1,436 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"ea-job-api/logger"
+	"ea-job-api/metrics"
+
+	"github.com/gin-gonic/gin"
+	"github.com/robfig/cron/v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// agentScheduleGVR is the GroupVersionResource of the AgentSchedule CRD.
+var agentScheduleGVR = schema.GroupVersionResource{
+	Group:    "ea.erulabs.ai",
+	Version:  "v1",
+	Resource: "agentschedules",
+}
+
+const (
+	// scheduleLabel is set on the AgentJobs a schedule starts, to its name.
+	scheduleLabel = "ea.erulabs.ai/schedule"
+	// scheduledTimeAnnotation records the run time an AgentJob was started for.
+	scheduledTimeAnnotation = "ea.erulabs.ai/scheduled-time"
+)
+
+// Concurrency policies: what to do when a run is due while earlier runs are active.
+const (
+	concurrencyAllow   = "allow"   // Start the run anyway
+	concurrencyForbid  = "forbid"  // Skip the run
+	concurrencyReplace = "replace" // Cancel the active runs, then start the run
+)
+
+// Missed-run policies: what to do with runs that could not start on time.
+const (
+	missedRunOnce = "runOnce" // Start the most recent missed run, late
+	missedRunSkip = "skip"    // Drop missed runs
+)
+
+const (
+	defaultScheduleTimeZone        = "UTC"
+	defaultStartingDeadlineSeconds = 60
+)
+
+// ScheduleRequest is the body of POST and PUT /api/v1/schedules.
+type ScheduleRequest struct {
+	Name                    string                 `json:"name"`
+	AgentID                 string                 `json:"agent_id" binding:"required"`
+	AgentVersion            int                    `json:"agent_version,omitempty"` // Optional agent revision; defaults to the latest at each run
+	UserID                  string                 `json:"user_id"`                 // Owner the runs start as; defaults to the caller
+	Cron                    string                 `json:"cron" binding:"required"` // Standard 5-field cron expression or descriptor such as @daily
+	TimeZone                string                 `json:"time_zone,omitempty"`     // IANA time zone the cron expression is read in; defaults to UTC
+	Inputs                  map[string]interface{} `json:"inputs,omitempty"`        // Input overrides passed to every run
+	TimeoutSeconds          int                    `json:"timeout_seconds,omitempty"`
+	ConcurrencyPolicy       string                 `json:"concurrency_policy,omitempty"`        // allow (default), forbid or replace
+	MissedRunPolicy         string                 `json:"missed_run_policy,omitempty"`         // runOnce (default) or skip
+	StartingDeadlineSeconds int                    `json:"starting_deadline_seconds,omitempty"` // How late a run may start before it counts as missed
+	Suspend                 bool                   `json:"suspend,omitempty"`
+}
+
+// ScheduleStatus is the state the job operator records on an AgentSchedule.
+type ScheduleStatus struct {
+	LastScheduleTime *time.Time `json:"last_schedule_time,omitempty"`
+	NextScheduleTime *time.Time `json:"next_schedule_time,omitempty"`
+	LastJobName      string     `json:"last_job_name,omitempty"`
+	ActiveJobs       []string   `json:"active_jobs,omitempty"`
+	MissedRuns       int64      `json:"missed_runs,omitempty"`
+	Message          string     `json:"message,omitempty"`
+}
+
+// Schedule is an AgentSchedule as returned by the schedules endpoints.
+type Schedule struct {
+	ID string `json:"id"`
+	ScheduleRequest
+	CreatedAt time.Time      `json:"created_at"`
+	Status    ScheduleStatus `json:"status"`
+}
+
+// scheduledJobName names the AgentJob of the run of schedule due at t. Runs
+// are at most once a minute, so the name is unique per run and a retried
+// trigger cannot start the same run twice.
+func scheduledJobName(schedule string, t time.Time) string {
+	return fmt.Sprintf("%s-%d", schedule, t.Unix()/60)
+}
+
+// HandleCreateSchedule creates an AgentSchedule. The job operator starts an
+// AgentJob every time its cron expression fires.
+func HandleCreateSchedule(c *gin.Context) {
+	path := c.FullPath()
+	metrics.StepCounter.WithLabelValues(path, "api_request_start", "success").Inc()
+
+	var req ScheduleRequest
+	if !bindScheduleRequest(c, path, &req) {
+		return
+	}
+
+	schedule := &unstructured.Unstructured{
+		Object: map[string]interface{}{
+			"apiVersion": "ea.erulabs.ai/v1",
+			"kind":       "AgentSchedule",
+			"metadata": map[string]interface{}{
+				"name":      "schedule-" + generateRandomHash(),
+				"namespace": agentJobNamespace,
+			},
+			"spec": scheduleSpec(req),
+		},
+	}
+
+	dynamicClient, err := newDynamicClient()
+	if err != nil {
+		logger.Slog.Error("Failed to create dynamic Kubernetes client", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create Kubernetes dynamic client"})
+		return
+	}
+
+	created, err := dynamicClient.Resource(agentScheduleGVR).Namespace(agentJobNamespace).Create(context.TODO(), schedule, metav1.CreateOptions{})
+	if err != nil {
+		metrics.StepCounter.WithLabelValues(path, "k8s_create_error", "error").Inc()
+		logger.Slog.Error("Failed to create AgentSchedule", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create schedule in Kubernetes"})
+		return
+	}
+
+	metrics.StepCounter.WithLabelValues(path, "create_success", "success").Inc()
+	logger.Slog.Info("AgentSchedule created", "schedule", created.GetName(), "agent_id", req.AgentID, "user", req.UserID)
+	c.JSON(http.StatusCreated, scheduleOf(created))
+}
+
+// HandleListSchedules lists AgentSchedules by name. Non-internal users only see
+// their own schedules. Filters: user_id and agent_id.
+func HandleListSchedules(c *gin.Context) {
+	path := c.FullPath()
+	metrics.StepCounter.WithLabelValues(path, "api_hit", "success").Inc()
+
+	authenticatedUserID := c.GetHeader("X-Consumer-Username")
+	if authenticatedUserID == "" {
+		logger.Slog.Error("Missing X-Consumer-Username header")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// 🔹 Non-internal users can only list their own schedules
+	userID := c.Query("user_id")
+	if authenticatedUserID != "internal" {
+		if userID != "" && userID != authenticatedUserID {
+			logger.Slog.Error("User spoofing attempt detected", "authenticated", authenticatedUserID, "requested", userID)
+			metrics.StepCounter.WithLabelValues(path, "user_spoofing_attempt", "failure").Inc()
+			c.JSON(http.StatusForbidden, gin.H{"error": "User ID does not match authenticated user"})
+			return
+		}
+		userID = authenticatedUserID
+	}
+	agentID := c.Query("agent_id")
+
+	dynamicClient, err := newDynamicClient()
+	if err != nil {
+		logger.Slog.Error("Failed to create dynamic Kubernetes client", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create Kubernetes dynamic client"})
+		return
+	}
+
+	list, err := dynamicClient.Resource(agentScheduleGVR).Namespace(agentJobNamespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		metrics.StepCounter.WithLabelValues(path, "k8s_list_error", "error").Inc()
+		logger.Slog.Error("Failed to list AgentSchedules", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list schedules"})
+		return
+	}
+
+	schedules := []Schedule{}
+	for i := range list.Items {
+		schedule := scheduleOf(&list.Items[i])
+		if (userID != "" && schedule.UserID != userID) || (agentID != "" && schedule.AgentID != agentID) {
+			continue
+		}
+		schedules = append(schedules, schedule)
+	}
+	sort.Slice(schedules, func(i, j int) bool { return schedules[i].ID < schedules[j].ID })
+
+	metrics.StepCounter.WithLabelValues(path, "retrieval_success", "success").Inc()
+	c.JSON(http.StatusOK, schedules)
+}
+
+// HandleGetSchedule returns an AgentSchedule with its last and next run times.
+func HandleGetSchedule(c *gin.Context) {
+	path := c.FullPath()
+	metrics.StepCounter.WithLabelValues(path, "api_hit", "success").Inc()
+
+	schedule, _, ok := loadOwnedResource(c, path, agentScheduleGVR, "Schedule")
+	if !ok {
+		return
+	}
+
+	metrics.StepCounter.WithLabelValues(path, "retrieval_success", "success").Inc()
+	c.JSON(http.StatusOK, scheduleOf(schedule))
+}
+
+// HandleUpdateSchedule replaces the spec of an AgentSchedule. Its status, and
+// so the time of its last run, is kept.
+func HandleUpdateSchedule(c *gin.Context) {
+	path := c.FullPath()
+	metrics.StepCounter.WithLabelValues(path, "api_request_start", "success").Inc()
+
+	schedule, dynamicClient, ok := loadOwnedResource(c, path, agentScheduleGVR, "Schedule")
+	if !ok {
+		return
+	}
+
+	var req ScheduleRequest
+	if !bindScheduleRequest(c, path, &req) {
+		return
+	}
+
+	// 🔹 The resourceVersion of the schedule we read guards against lost updates
+	schedule.Object["spec"] = scheduleSpec(req)
+	updated, err := dynamicClient.Resource(agentScheduleGVR).Namespace(agentJobNamespace).Update(context.TODO(), schedule, metav1.UpdateOptions{})
+	if apierrors.IsConflict(err) {
+		metrics.StepCounter.WithLabelValues(path, "k8s_update_conflict", "error").Inc()
+		c.JSON(http.StatusConflict, gin.H{"error": "Schedule was modified concurrently, retry the update"})
+		return
+	}
+	if err != nil {
+		metrics.StepCounter.WithLabelValues(path, "k8s_update_error", "error").Inc()
+		logger.Slog.Error("Failed to update AgentSchedule", "schedule", schedule.GetName(), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update schedule"})
+		return
+	}
+
+	metrics.StepCounter.WithLabelValues(path, "update_success", "success").Inc()
+	logger.Slog.Info("AgentSchedule updated", "schedule", updated.GetName())
+	c.JSON(http.StatusOK, scheduleOf(updated))
+}
+
+// HandleDeleteSchedule deletes an AgentSchedule. Runs it already started keep running.
+func HandleDeleteSchedule(c *gin.Context) {
+	path := c.FullPath()
+	metrics.StepCounter.WithLabelValues(path, "api_request_start", "success").Inc()
+
+	schedule, dynamicClient, ok := loadOwnedResource(c, path, agentScheduleGVR, "Schedule")
+	if !ok {
+		return
+	}
+
+	err := dynamicClient.Resource(agentScheduleGVR).Namespace(agentJobNamespace).Delete(context.TODO(), schedule.GetName(), metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		metrics.StepCounter.WithLabelValues(path, "k8s_delete_error", "error").Inc()
+		logger.Slog.Error("Failed to delete AgentSchedule", "schedule", schedule.GetName(), "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete schedule"})
+		return
+	}
+
+	metrics.StepCounter.WithLabelValues(path, "delete_success", "success").Inc()
+	logger.Slog.Info("AgentSchedule deleted", "schedule", schedule.GetName())
+	c.JSON(http.StatusOK, gin.H{"status": "schedule deleted", "id": schedule.GetName()})
+}
+
+// bindScheduleRequest parses and validates a schedule request, fills in its
+// defaults and checks that its owner can run the agent with its inputs. It
+// writes the error response on failure.
+func bindScheduleRequest(c *gin.Context, path string, req *ScheduleRequest) bool {
+	authenticatedUserID := c.GetHeader("X-Consumer-Username")
+	if authenticatedUserID == "" {
+		logger.Slog.Error("Missing X-Consumer-Username header")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return false
+	}
+
+	if err := c.ShouldBindJSON(req); err != nil {
+		logger.Slog.Error("Invalid schedule request body", "error", err)
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request body"})
+		return false
+	}
+
+	// 🔹 Schedules run as their owner, who must be the caller
+	if req.UserID == "" {
+		req.UserID = authenticatedUserID
+	}
+	if authenticatedUserID != "internal" && req.UserID != authenticatedUserID {
+		logger.Slog.Error("User spoofing attempt detected", "authenticated", authenticatedUserID, "requested", req.UserID)
+		metrics.StepCounter.WithLabelValues(path, "user_spoofing_attempt", "failure").Inc()
+		c.JSON(http.StatusForbidden, gin.H{"error": "User ID does not match authenticated user"})
+		return false
+	}
+	if req.UserID == "internal" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Internal callers must set user_id"})
+		return false
+	}
+
+	// 🔹 Fill in defaults, then validate the schedule itself
+	if req.TimeZone == "" {
+		req.TimeZone = defaultScheduleTimeZone
+	}
+	if req.ConcurrencyPolicy == "" {
+		req.ConcurrencyPolicy = concurrencyAllow
+	}
+	if req.MissedRunPolicy == "" {
+		req.MissedRunPolicy = missedRunOnce
+	}
+	if req.StartingDeadlineSeconds == 0 {
+		req.StartingDeadlineSeconds = defaultStartingDeadlineSeconds
+	}
+	if problems := validateSchedule(req); len(problems) > 0 {
+		metrics.StepCounter.WithLabelValues(path, "invalid_schedule", "error").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid schedule", "details": problems})
+		return false
+	}
+
+	// 🔹 Check the agent and inputs now rather than failing at every run
+	agent, status, err := fetchAgent(c.GetHeader("Authorization"), req.UserID, req.AgentID, req.AgentVersion)
+	if err != nil {
+		logger.Slog.Error("Failed to retrieve agent", "agent_id", req.AgentID, "error", err)
+		c.JSON(status, gin.H{"error": "Failed to retrieve agent"})
+		return false
+	}
+	if _, problems := resolveJobInputs(agent.Inputs, req.Inputs); len(problems) > 0 {
+		metrics.StepCounter.WithLabelValues(path, "invalid_inputs", "error").Inc()
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job inputs", "details": problems})
+		return false
+	}
+	if req.Name == "" {
+		req.Name = agent.Name
+	}
+	return true
+}
+
+// validateSchedule returns every problem with the timing and policies of a schedule.
+func validateSchedule(req *ScheduleRequest) []string {
+	var problems []string
+
+	// The time zone has its own field, so it may not be set in the expression
+	if strings.HasPrefix(req.Cron, "TZ=") || strings.HasPrefix(req.Cron, "CRON_TZ=") {
+		problems = append(problems, "cron: set the time zone with time_zone instead")
+	} else if _, err := cron.ParseStandard(req.Cron); err != nil {
+		problems = append(problems, fmt.Sprintf("cron: %v", err))
+	}
+	if _, err := time.LoadLocation(req.TimeZone); err != nil {
+		problems = append(problems, fmt.Sprintf("time_zone: unknown time zone %q", req.TimeZone))
+	}
+
+	switch req.ConcurrencyPolicy {
+	case concurrencyAllow, concurrencyForbid, concurrencyReplace:
+	default:
+		problems = append(problems, fmt.Sprintf("concurrency_policy: must be %s, %s or %s", concurrencyAllow, concurrencyForbid, concurrencyReplace))
+	}
+	switch req.MissedRunPolicy {
+	case missedRunOnce, missedRunSkip:
+	default:
+		problems = append(problems, fmt.Sprintf("missed_run_policy: must be %s or %s", missedRunOnce, missedRunSkip))
+	}
+
+	if req.StartingDeadlineSeconds < 0 {
+		problems = append(problems, "starting_deadline_seconds: must be positive")
+	}
+	if req.TimeoutSeconds < 0 {
+		problems = append(problems, "timeout_seconds: must be positive")
+	}
+	if req.AgentVersion < 0 {
+		problems = append(problems, "agent_version: must be positive")
+	}
+	return problems
+}
+
+// scheduleSpec is the AgentSchedule spec of a validated request.
+func scheduleSpec(req ScheduleRequest) map[string]interface{} {
+	spec := map[string]interface{}{
+		"name":                    req.Name,
+		"agentID":                 req.AgentID,
+		"user":                    req.UserID,
+		"schedule":                req.Cron,
+		"timeZone":                req.TimeZone,
+		"concurrencyPolicy":       req.ConcurrencyPolicy,
+		"missedRunPolicy":         req.MissedRunPolicy,
+		"startingDeadlineSeconds": int64(req.StartingDeadlineSeconds),
+		"suspend":                 req.Suspend,
+	}
+	if req.AgentVersion > 0 {
+		spec["agentVersion"] = int64(req.AgentVersion)
+	}
+	if req.TimeoutSeconds > 0 {
+		spec["timeoutSeconds"] = int64(req.TimeoutSeconds)
+	}
+	if len(req.Inputs) > 0 {
+		spec["inputs"] = req.Inputs
+	}
+	return spec
+}
+
+// scheduleOf reads the spec and status of an AgentSchedule.
+func scheduleOf(obj *unstructured.Unstructured) Schedule {
+	schedule := Schedule{ID: obj.GetName(), CreatedAt: obj.GetCreationTimestamp().Time}
+
+	spec, _, _ := unstructured.NestedMap(obj.Object, "spec")
+	schedule.Name, _ = spec["name"].(string)
+	schedule.AgentID, _ = spec["agentID"].(string)
+	schedule.UserID, _ = spec["user"].(string)
+	schedule.Cron, _ = spec["schedule"].(string)
+	schedule.TimeZone, _ = spec["timeZone"].(string)
+	schedule.ConcurrencyPolicy, _ = spec["concurrencyPolicy"].(string)
+	schedule.MissedRunPolicy, _ = spec["missedRunPolicy"].(string)
+	schedule.Suspend, _ = spec["suspend"].(bool)
+	schedule.Inputs, _ = spec["inputs"].(map[string]interface{})
+	agentVersion, _, _ := unstructured.NestedInt64(obj.Object, "spec", "agentVersion")
+	schedule.AgentVersion = int(agentVersion)
+	timeoutSeconds, _, _ := unstructured.NestedInt64(obj.Object, "spec", "timeoutSeconds")
+	schedule.TimeoutSeconds = int(timeoutSeconds)
+	deadline, _, _ := unstructured.NestedInt64(obj.Object, "spec", "startingDeadlineSeconds")
+	schedule.StartingDeadlineSeconds = int(deadline)
+
+	status, _, _ := unstructured.NestedMap(obj.Object, "status")
+	schedule.Status.LastScheduleTime = parseStatusTime(status["lastScheduleTime"])
+	schedule.Status.NextScheduleTime = parseStatusTime(status["nextScheduleTime"])
+	schedule.Status.LastJobName, _ = status["lastJobName"].(string)
+	schedule.Status.ActiveJobs, _, _ = unstructured.NestedStringSlice(obj.Object, "status", "activeJobs")
+	schedule.Status.MissedRuns, _, _ = unstructured.NestedInt64(obj.Object, "status", "missedRuns")
+	schedule.Status.Message, _ = status["message"].(string)
+	return schedule
+}
+
+// parseStatusTime parses an RFC 3339 status timestamp, if set.
+func parseStatusTime(value interface{}) *time.Time {
+	s, _ := value.(string)
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil
+	}
+	return &t
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"log"
+	_ "time/tzdata" // Schedules may use any IANA time zone, whatever the image ships
 
 	"ea-job-api/config"
 	"ea-job-api/logger"
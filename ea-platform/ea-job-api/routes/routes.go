@@ -25,8 +25,34 @@ func RegisterRoutes() *gin.Engine {
 	// jobs routes
 	jobs := router.Group("/api/v1/jobs")
 	{
-		jobs.POST("", handlers.HandleCreateJob) // Create new job
+		jobs.POST("", handlers.HandleCreateJob)              // Create new job
+		jobs.GET("", handlers.HandleListJobs)                // List jobs
+		jobs.GET("/:name", handlers.HandleGetJob)            // Get job status and node outputs
+		jobs.GET("/:name/events", handlers.HandleJobEvents)  // Stream job progress (SSE or WebSocket)
+		jobs.POST("/:name/cancel", handlers.HandleCancelJob) // Cancel a running job
+		jobs.POST("/:name/rerun", handlers.HandleRerunJob)   // Re-run a job with the same spec
+	}
 
+	// schedules routes
+	schedules := router.Group("/api/v1/schedules")
+	{
+		schedules.POST("", handlers.HandleCreateSchedule)         // Create a schedule
+		schedules.GET("", handlers.HandleListSchedules)           // List schedules
+		schedules.GET("/:name", handlers.HandleGetSchedule)       // Get a schedule and its last/next runs
+		schedules.PUT("/:name", handlers.HandleUpdateSchedule)    // Replace a schedule
+		schedules.DELETE("/:name", handlers.HandleDeleteSchedule) // Delete a schedule
+	}
+
+	// hooks routes
+	hooks := router.Group("/api/v1/hooks")
+	{
+		hooks.POST("", handlers.HandleCreateHook)                           // Create a hook and its signing secret
+		hooks.GET("", handlers.HandleListHooks)                             // List hooks
+		hooks.GET("/:name", handlers.HandleGetHook)                         // Get a hook
+		hooks.PUT("/:name", handlers.HandleUpdateHook)                      // Replace a hook
+		hooks.DELETE("/:name", handlers.HandleDeleteHook)                   // Delete a hook
+		hooks.POST("/:name/rotate-secret", handlers.HandleRotateHookSecret) // Replace a hook's signing secret
+		hooks.POST("/:name", handlers.HandleTriggerHook)                    // Start the hook's agent (signed by the caller)
 	}
 
 	return router
@@ -37,7 +63,7 @@ func corsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
 		c.Writer.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Last-Event-ID")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(200)
@@ -7,6 +7,8 @@ type Config struct {
 	Port             string
 	AgentManagerUrl  string
 	FeatureK8sEvents string
+	BlobDir          string
+	OutputValidation string
 }
 
 // LoadConfig initializes the configuration from environment variables.
@@ -15,6 +17,8 @@ func LoadConfig() Config {
 		//AgentManagerUrl: getEnv("AGENT_MANAGER_URL", "http://agent-manager.ea.erulabs.local/api/v1"), //for local testing
 		AgentManagerUrl:  getEnv("AGENT_MANAGER_URL", "http://ea-agent-manager.ea-platform.svc.cluster.local:8080/api/v1"),
 		FeatureK8sEvents: getEnv("FEATURE_K8S_EVENTS", "true"),
+		BlobDir:          getEnv("BLOB_DIR", "/tmp/ea-job-executor/blobs"),
+		OutputValidation: getEnv("OUTPUT_VALIDATION", "warn"),
 	}
 }
 
@@ -0,0 +1,50 @@
+package executor
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"ea-job-executor/logger"
+)
+
+//--------------------- Cancellation ---------------------//
+
+// ErrJobCancelled is returned by Run when the job was cancelled by a signal.
+var ErrJobCancelled = errors.New("agent job cancelled")
+
+// ExitCodeCancelled is the exit code of a cancelled executor (128 + SIGTERM).
+const ExitCodeCancelled = 143
+
+// cancelOnSignal returns a context that is cancelled with ErrJobCancelled when
+// the process receives SIGTERM or SIGINT. Kubernetes sends SIGTERM when the
+// operator deletes the executor Job of a cancelled AgentJob.
+func cancelOnSignal(parent context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(parent)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			logger.Slog.Warn("Received signal, cancelling agent job", "signal", sig.String())
+			cancel(ErrJobCancelled)
+		case <-done:
+		}
+	}()
+
+	return ctx, func() {
+		signal.Stop(signals)
+		close(done)
+		cancel(nil)
+	}
+}
+
+// jobCancelled reports whether ctx was cancelled because the job was.
+func jobCancelled(ctx context.Context) bool {
+	return errors.Is(context.Cause(ctx), ErrJobCancelled)
+}
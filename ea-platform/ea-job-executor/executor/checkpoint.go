@@ -0,0 +1,139 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"ea-job-executor/logger"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+)
+
+//--------------------- Checkpoints ---------------------//
+
+// Every completed node is checkpointed through its "Completed" status event,
+// which the job operator copies into the AgentJob's status.nodes. The event
+// carries the node's raw result next to its flattened output, since flattened
+// keys cannot tell nested objects from keys containing "." and drop empty
+// objects. When the executor pod is restarted, those results are loaded back
+// into the execution state so that only the nodes that have not completed yet
+// run again.
+
+var agentJobGVR = schema.GroupVersionResource{
+	Group:    "ea.erulabs.ai",
+	Version:  "v1",
+	Resource: "agentjobs",
+}
+
+// loadCheckpoint returns the results of the completed top-level nodes recorded
+// in the AgentJob status, keyed by node alias.
+func loadCheckpoint(ctx context.Context, agentJobID string) (map[string]interface{}, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes config: %w", err)
+	}
+	dynamicClient, err := dynamic.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Kubernetes dynamic client: %w", err)
+	}
+
+	agentJob, err := dynamicClient.Resource(agentJobGVR).Namespace("ea-platform").Get(ctx, agentJobID, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get AgentJob %s: %w", agentJobID, err)
+	}
+
+	nodes, _, err := unstructured.NestedSlice(agentJob.Object, "status", "nodes")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read AgentJob node status: %w", err)
+	}
+
+	completed := make(map[string]interface{})
+	for _, n := range nodes {
+		node, ok := n.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		alias, _ := node["alias"].(string)
+		status, _ := node["status"].(string)
+		if alias == "" || status != "Completed" {
+			continue
+		}
+
+		if resultJSON, ok := node["result"].(string); ok && resultJSON != "" {
+			var result interface{}
+			if err := json.Unmarshal([]byte(resultJSON), &result); err != nil {
+				logger.Slog.Warn("Ignoring checkpoint with invalid result", "alias", alias, "error", err)
+				continue
+			}
+			completed[alias] = result
+			continue
+		}
+
+		// Jobs checkpointed before results were recorded only have the flattened output
+		output := make(map[string]interface{})
+		if outputJSON, ok := node["output"].(string); ok && outputJSON != "" {
+			if err := json.Unmarshal([]byte(outputJSON), &output); err != nil {
+				logger.Slog.Warn("Ignoring checkpoint with invalid output", "alias", alias, "error", err)
+				continue
+			}
+		}
+		completed[alias] = unflattenJSON(alias, output)
+	}
+
+	return completed, nil
+}
+
+// restoreCheckpoint rehydrates the execution state with the checkpointed results
+// of nodes in the graph and marks them as restored so they are not executed again.
+// Aliases of nested nodes (e.g. foreach iterations) are ignored; their parent
+// node either completed as a whole or runs again.
+func restoreCheckpoint(graph ExecutionGraph, completed map[string]interface{}, state *ExecutionState) {
+	state.Lock.Lock()
+	defer state.Lock.Unlock()
+
+	if state.Restored == nil {
+		state.Restored = make(map[string]bool)
+	}
+
+	for alias, result := range completed {
+		if _, exists := graph.Nodes[alias]; !exists {
+			continue
+		}
+
+		// Store the result as storeNodeResult does
+		state.Results[alias] = result
+		if resMap, ok := result.(map[string]interface{}); ok {
+			flattenJSON(alias, resMap, state.Results)
+		}
+		state.Restored[alias] = true
+
+		logger.Slog.Info("Restored node from checkpoint", "alias", alias)
+	}
+}
+
+// unflattenJSON rebuilds the nested output of a node from its flattened
+// "alias.path.to.key" entries, reversing flattenJSON as far as possible.
+func unflattenJSON(prefix string, flattened map[string]interface{}) map[string]interface{} {
+	result := make(map[string]interface{})
+	for key, value := range flattened {
+		path := strings.Split(strings.TrimPrefix(key, prefix+"."), ".")
+
+		current := result
+		for _, part := range path[:len(path)-1] {
+			next, ok := current[part].(map[string]interface{})
+			if !ok {
+				next = make(map[string]interface{})
+				current[part] = next
+			}
+			current = next
+		}
+		current[path[len(path)-1]] = value
+	}
+	return result
+}
@@ -0,0 +1,419 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"ea-job-executor/logger"
+)
+
+//--------------------- Condition Nodes ---------------------//
+
+// conditionNodeType is the built-in node type used for conditional branching.
+// A condition node evaluates its "expression" parameter against the execution
+// state and only lets the edges of the chosen branch ("then" or "else") run.
+const conditionNodeType = "control.condition"
+
+// ConditionBranches lists the aliases triggered by each branch of a condition node.
+type ConditionBranches struct {
+	Then []string
+	Else []string
+}
+
+// parseConditionBranches reads the "then" and "else" parameters of a condition node.
+// Each parameter may be a single alias or a list of aliases.
+func parseConditionBranches(node NodeInstance) (ConditionBranches, error) {
+	thenTargets, err := aliasList(node.Parameters["then"])
+	if err != nil {
+		return ConditionBranches{}, fmt.Errorf("condition node %s: invalid 'then' parameter: %w", node.Alias, err)
+	}
+	elseTargets, err := aliasList(node.Parameters["else"])
+	if err != nil {
+		return ConditionBranches{}, fmt.Errorf("condition node %s: invalid 'else' parameter: %w", node.Alias, err)
+	}
+	return ConditionBranches{Then: thenTargets, Else: elseTargets}, nil
+}
+
+// untaken returns the targets of the branch that was not chosen.
+func (b ConditionBranches) untaken(branch string) []string {
+	if branch == "then" {
+		return b.Else
+	}
+	return b.Then
+}
+
+// executeConditionNode evaluates the condition expression and returns the node result.
+func executeConditionNode(node NodeInstance, state *ExecutionState) (map[string]interface{}, error) {
+	expression, ok := node.Parameters["expression"].(string)
+	if !ok || strings.TrimSpace(expression) == "" {
+		return nil, fmt.Errorf("condition node %s is missing an 'expression' parameter", node.Alias)
+	}
+
+	value, err := evaluateCondition(expression, state)
+	if err != nil {
+		return nil, fmt.Errorf("condition node %s: %w", node.Alias, err)
+	}
+
+	branch := "else"
+	if value {
+		branch = "then"
+	}
+
+	logger.Slog.Info("Condition evaluated", "alias", node.Alias, "expression", expression, "result", value, "branch", branch)
+
+	return map[string]interface{}{
+		"result": value,
+		"branch": branch,
+	}, nil
+}
+
+func aliasList(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if v == "" {
+			return nil, nil
+		}
+		return []string{v}, nil
+	case []string:
+		return v, nil
+	case []interface{}:
+		aliases := make([]string, 0, len(v))
+		for _, item := range v {
+			alias, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected alias string, got %T", item)
+			}
+			aliases = append(aliases, alias)
+		}
+		return aliases, nil
+	default:
+		return nil, fmt.Errorf("expected alias or list of aliases, got %T", value)
+	}
+}
+
+//--------------------- Expression Evaluation ---------------------//
+
+// evaluateCondition evaluates a boolean expression such as
+//
+//	{{classifier.label}} == "spam" && {{classifier.score}} > 0.8
+//
+// Supported operands are {{alias.path}} references, string, number, true,
+// false and null literals. Supported operators are ==, !=, <, <=, >, >=, &&,
+// || and !, with parentheses for grouping. A lone operand is evaluated for
+// truthiness.
+func evaluateCondition(expression string, state *ExecutionState) (bool, error) {
+	tokens, err := tokenizeCondition(expression)
+	if err != nil {
+		return false, err
+	}
+
+	p := &conditionParser{tokens: tokens, state: state}
+	value, err := p.parseOr()
+	if err != nil {
+		return false, err
+	}
+	if p.pos < len(p.tokens) {
+		return false, fmt.Errorf("unexpected token %q in expression", p.tokens[p.pos].text)
+	}
+	return truthy(value), nil
+}
+
+type conditionTokenKind int
+
+const (
+	tokenReference conditionTokenKind = iota
+	tokenString
+	tokenNumber
+	tokenIdent
+	tokenOperator
+)
+
+type conditionToken struct {
+	kind conditionTokenKind
+	text string
+}
+
+func tokenizeCondition(expression string) ([]conditionToken, error) {
+	var tokens []conditionToken
+	i := 0
+	for i < len(expression) {
+		ch := expression[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+		case strings.HasPrefix(expression[i:], "{{"):
+			end := strings.Index(expression[i:], "}}")
+			if end < 0 {
+				return nil, errors.New("unterminated reference in expression")
+			}
+			ref := strings.TrimSpace(expression[i+2 : i+end])
+			tokens = append(tokens, conditionToken{kind: tokenReference, text: ref})
+			i += end + 2
+		case ch == '"' || ch == '\'':
+			j := i + 1
+			var sb strings.Builder
+			for j < len(expression) && expression[j] != ch {
+				if expression[j] == '\\' && j+1 < len(expression) {
+					j++
+				}
+				sb.WriteByte(expression[j])
+				j++
+			}
+			if j >= len(expression) {
+				return nil, errors.New("unterminated string literal in expression")
+			}
+			tokens = append(tokens, conditionToken{kind: tokenString, text: sb.String()})
+			i = j + 1
+		case (ch >= '0' && ch <= '9') || (ch == '-' && i+1 < len(expression) && expression[i+1] >= '0' && expression[i+1] <= '9'):
+			j := i + 1
+			for j < len(expression) && ((expression[j] >= '0' && expression[j] <= '9') || expression[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, conditionToken{kind: tokenNumber, text: expression[i:j]})
+			i = j
+		case isIdentChar(ch):
+			j := i
+			for j < len(expression) && isIdentChar(expression[j]) {
+				j++
+			}
+			tokens = append(tokens, conditionToken{kind: tokenIdent, text: expression[i:j]})
+			i = j
+		default:
+			matched := false
+			for _, op := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")"} {
+				if strings.HasPrefix(expression[i:], op) {
+					tokens = append(tokens, conditionToken{kind: tokenOperator, text: op})
+					i += len(op)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, fmt.Errorf("unexpected character %q in expression", ch)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+func isIdentChar(ch byte) bool {
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || ch == '_'
+}
+
+type conditionParser struct {
+	tokens []conditionToken
+	pos    int
+	state  *ExecutionState
+}
+
+func (p *conditionParser) peekOperator(op string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == tokenOperator && p.tokens[p.pos].text == op
+}
+
+func (p *conditionParser) parseOr() (interface{}, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekOperator("||") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = truthy(left) || truthy(right)
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseAnd() (interface{}, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekOperator("&&") {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = truthy(left) && truthy(right)
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseUnary() (interface{}, error) {
+	if p.peekOperator("!") {
+		p.pos++
+		value, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return !truthy(value), nil
+	}
+	return p.parseComparison()
+}
+
+func (p *conditionParser) parseComparison() (interface{}, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.peekOperator(op) {
+			p.pos++
+			right, err := p.parseOperand()
+			if err != nil {
+				return nil, err
+			}
+			return compareValues(op, left, right)
+		}
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseOperand() (interface{}, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, errors.New("unexpected end of expression")
+	}
+	tok := p.tokens[p.pos]
+	p.pos++
+
+	switch tok.kind {
+	case tokenReference:
+		return resolveStateReference(tok.text, p.state)
+	case tokenString:
+		return tok.text, nil
+	case tokenNumber:
+		return strconv.ParseFloat(tok.text, 64)
+	case tokenIdent:
+		switch tok.text {
+		case "true":
+			return true, nil
+		case "false":
+			return false, nil
+		case "null", "nil":
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unknown identifier %q in expression (did you mean {{%s}}?)", tok.text, tok.text)
+	case tokenOperator:
+		if tok.text == "(" {
+			value, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if !p.peekOperator(")") {
+				return nil, errors.New("missing closing parenthesis in expression")
+			}
+			p.pos++
+			return value, nil
+		}
+	}
+	return nil, fmt.Errorf("unexpected token %q in expression", tok.text)
+}
+
+// compareValues applies a comparison operator. Equality falls back to comparing
+// the formatted values when the operand types differ, so "0.7" == 0.7 holds.
+func compareValues(op string, left, right interface{}) (bool, error) {
+	switch op {
+	case "==", "!=":
+		equal := valuesEqual(left, right)
+		if op == "==" {
+			return equal, nil
+		}
+		return !equal, nil
+	}
+
+	l, lok := toFloat(left)
+	r, rok := toFloat(right)
+	if lok && rok {
+		switch op {
+		case "<":
+			return l < r, nil
+		case "<=":
+			return l <= r, nil
+		case ">":
+			return l > r, nil
+		case ">=":
+			return l >= r, nil
+		}
+	}
+
+	ls, lsok := left.(string)
+	rs, rsok := right.(string)
+	if lsok && rsok {
+		switch op {
+		case "<":
+			return ls < rs, nil
+		case "<=":
+			return ls <= rs, nil
+		case ">":
+			return ls > rs, nil
+		case ">=":
+			return ls >= rs, nil
+		}
+	}
+
+	return false, fmt.Errorf("cannot compare %v %s %v", left, op, right)
+}
+
+func valuesEqual(left, right interface{}) bool {
+	if left == nil || right == nil {
+		return left == nil && right == nil
+	}
+	if l, ok := toFloat(left); ok {
+		if r, ok := toFloat(right); ok {
+			return l == r
+		}
+	}
+	if reflect.DeepEqual(left, right) {
+		return true
+	}
+	return fmt.Sprintf("%v", left) == fmt.Sprintf("%v", right)
+}
+
+func toFloat(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// truthy reports whether a resolved value counts as true in a condition.
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case string:
+		s := strings.TrimSpace(strings.ToLower(v))
+		return s != "" && s != "false" && s != "0"
+	case float64:
+		return v != 0
+	case int:
+		return v != 0
+	case int64:
+		return v != 0
+	case []interface{}:
+		return len(v) > 0
+	case map[string]interface{}:
+		return len(v) > 0
+	}
+	return true
+}
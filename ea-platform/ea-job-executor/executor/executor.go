@@ -1,11 +1,14 @@
 package executor
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"regexp"
 	"strconv"
@@ -17,6 +20,7 @@ import (
 	"ea-job-executor/logger"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
@@ -28,6 +32,7 @@ type NodeInstance struct {
 	Alias      string                 `json:"alias,omitempty"`
 	Type       string                 `json:"type"`
 	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Retry      *RetryPolicy           `json:"retry,omitempty"` // Overrides the definition's retry policy
 }
 
 type Edge struct {
@@ -42,13 +47,19 @@ type Metadata struct {
 }
 
 type Agent struct {
-	ID          string         `json:"id"`
-	Name        string         `json:"name"`
-	Creator     string         `json:"creator"`
-	Description string         `json:"description"`
-	Nodes       []NodeInstance `json:"nodes"`
-	Edges       []Edge         `json:"edges"`
-	Metadata    Metadata       `json:"metadata"`
+	ID             string         `json:"id"`
+	Name           string         `json:"name"`
+	Creator        string         `json:"creator"`
+	Description    string         `json:"description"`
+	Nodes          []NodeInstance `json:"nodes"`
+	Edges          []Edge         `json:"edges"`
+	Metadata       Metadata       `json:"metadata"`
+	TimeoutSeconds int            `json:"timeout_seconds,omitempty"` // Job-level deadline from the AgentJob spec
+	AgentVersion   int            `json:"agent_version,omitempty"`   // Agent revision the job runs
+
+	Inputs map[string]interface{} `json:"inputs,omitempty"` // Job inputs, referenced as {{inputs.key}}
+
+	CreatorGroups []string `json:"creator_groups,omitempty"` // Orgs of the creator, for org-visible node definitions
 }
 
 type ExecutionGraph struct {
@@ -56,18 +67,29 @@ type ExecutionGraph struct {
 	AdjList        map[string][]string
 	Indegrees      map[string]int
 	ExecutionOrder []string
+	Branches       map[string]ConditionBranches // Condition node alias -> branch targets
 }
 
 type ExecutionState struct {
 	Results map[string]interface{}
 	Lock    sync.RWMutex
+	Parent  *ExecutionState // Enclosing scope for nested graphs (e.g. foreach iterations)
+	Scope   string          // Prefix applied to node aliases in status events
+
+	Restored map[string]bool // Nodes whose results were restored from a checkpoint
 }
 
 type NodeDefinition struct {
 	Type       string          `json:"type"`
+	Version    string          `json:"version"`
+	Creator    string          `json:"creator,omitempty"`
+	Visibility string          `json:"visibility,omitempty"` // private, org or public
+	Org        string          `json:"org,omitempty"`
 	API        APIConfig       `json:"api"`
 	Parameters []NodeParameter `json:"parameters"`
 	Outputs    []NodeOutput    `json:"outputs"`
+	Retry      *RetryPolicy    `json:"retry,omitempty"`
+	Metadata   NodeMetadata    `json:"metadata"`
 }
 
 type APIConfig struct {
@@ -83,7 +105,14 @@ type NodeParameter struct {
 }
 
 type NodeOutput struct {
-	Key string `json:"key"`
+	Key      string `json:"key"`
+	Type     string `json:"type"`
+	Path     string `json:"path,omitempty"`     // Where the value is in the raw result; defaults to Key
+	Optional bool   `json:"optional,omitempty"` // Missing values are not a validation error
+}
+
+type NodeMetadata struct {
+	Additional map[string]interface{} `json:"additional"`
 }
 
 // NodesLibrary represents the full set of nodes available from the agent manager.
@@ -91,37 +120,122 @@ type NodesLibrary []NodeDefinition
 
 //--------------------- Main Executor Function ---------------------//
 
+// ExecuteAgentJob runs the AgentJob in filePath in-cluster and exits the process
+// with its outcome. This is the entrypoint of the Kubernetes Job.
 func ExecuteAgentJob(filePath string) {
 	config := config.LoadConfig()
 
+	rt := InClusterRuntime(config.AgentManagerUrl, config.BlobDir)
+	rt.OutputValidation = config.OutputValidation
+
+	finalOutput, err := Run(filePath, rt)
+	if errors.Is(err, ErrJobCancelled) {
+		logger.Slog.Warn("Agent job cancelled")
+		os.Exit(ExitCodeCancelled)
+	}
+	if err != nil {
+		handleError(err, "Agent job execution failed")
+	}
+
+	logger.Slog.Info("Execution completed successfully", "output", finalOutput)
+
+	os.Exit(0)
+}
+
+// Run executes the AgentJob in filePath with the given runtime backends and
+// returns the output of the last executed node.
+func Run(filePath string, rt Runtime) (interface{}, error) {
+	env = rt
+
 	agent, err := loadAgentJob(filePath)
 	if err != nil {
-		handleError(err, "Failed to load agent job")
-		os.Exit(1)
+		return nil, fmt.Errorf("failed to load agent job: %w", err)
 	}
 
-	nodesLib, err := loadNodesLibrary(config.AgentManagerUrl)
+	logger.Slog.Info("Executing agent", "agentID", agent.ID, "agentVersion", agent.AgentVersion, "agentJobID", agent.Metadata.AgentJobID)
+
+	nodesLib, err := rt.Nodes.LoadNodes()
 	if err != nil {
-		handleError(err, "Failed to load nodes library")
+		return nil, fmt.Errorf("failed to load nodes library: %w", err)
+	}
+	// The library is loaded as an internal service; only use what the creator can see
+	nodesLib = visibleNodeDefinitions(nodesLib, agent.Creator, agent.CreatorGroups)
+
+	// Check every versioned node type (pinned by the job API) against the library before running anything
+	if err := resolveNodeVersions(agent.Nodes, nodesLib); err != nil {
+		return nil, fmt.Errorf("failed to resolve node versions: %w", err)
 	}
 
 	graph, err := buildExecutionGraph(agent)
 	if err != nil {
-		handleError(err, "Failed to build execution graph")
+		return nil, fmt.Errorf("failed to build execution graph: %w", err)
 	}
 
-	finalOutput, err := executeGraph(agent.Metadata.AgentJobID, agent.Creator, graph, nodesLib)
-	if err != nil {
-		handleError(err, "Graph execution failed")
+	// SIGTERM (the executor Job being deleted) or SIGINT cancels the in-flight nodes
+	ctx, stopSignals := cancelOnSignal(context.Background())
+	defer stopSignals()
+
+	// Apply the job-level deadline from the AgentJob spec
+	if agent.TimeoutSeconds > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(agent.TimeoutSeconds)*time.Second)
+		defer cancel()
+		logger.Slog.Info("Job deadline set", "timeout_seconds", agent.TimeoutSeconds)
 	}
 
-	logger.Slog.Info("Execution completed successfully", "output", finalOutput)
+	state := &ExecutionState{Results: make(map[string]interface{})}
+	seedInputs(mergeInputs(agent.Inputs, rt.Inputs), state)
+
+	// Resume from the node results already recorded on the AgentJob, if any
+	if rt.Resume {
+		completed, err := loadCheckpoint(ctx, agent.Metadata.AgentJobID)
+		switch {
+		case apierrors.IsForbidden(err):
+			// The operator grants each job read access to its AgentJob; without it restarts lose all progress
+			logger.Slog.Error("Not allowed to read the AgentJob checkpoint, executing all nodes", "error", err)
+		case err != nil:
+			logger.Slog.Warn("Failed to load checkpoint, executing all nodes", "error", err)
+		case len(completed) > 0:
+			restoreCheckpoint(graph, completed, state)
+		}
+	}
 
-	os.Exit(0)
+	finalOutput, err := executeGraph(ctx, agent.Metadata.AgentJobID, agent.Creator, graph, nodesLib, state)
+	if err != nil && jobCancelled(ctx) {
+		return nil, ErrJobCancelled
+	}
+	if err != nil {
+		return nil, fmt.Errorf("graph execution failed: %w", err)
+	}
+
+	return finalOutput, nil
 }
 
 //--------------------- Step Functions ---------------------//
 
+// inputsAlias is the reserved alias job inputs are stored under.
+const inputsAlias = "inputs"
+
+// mergeInputs overlays the runtime's inputs on those of the job.
+func mergeInputs(jobInputs, overrides map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{}, len(jobInputs)+len(overrides))
+	for key, value := range jobInputs {
+		merged[key] = value
+	}
+	for key, value := range overrides {
+		merged[key] = value
+	}
+	return merged
+}
+
+// seedInputs stores the job inputs in the state like the result of a node
+// aliased "inputs", so {{inputs.topic}} resolves at every graph level.
+func seedInputs(inputs map[string]interface{}, state *ExecutionState) {
+	state.Results[inputsAlias] = inputs
+	flattenJSON(inputsAlias, inputs, state.Results)
+	logger.Slog.Info("Seeded job inputs", "inputs", inputs)
+}
+
 func loadAgentJob(filePath string) (Agent, error) {
 	data, err := os.ReadFile(filePath)
 	if err != nil {
@@ -133,35 +247,57 @@ func loadAgentJob(filePath string) (Agent, error) {
 	return agent, err
 }
 
+// nodesListPageSize is the page size used to list node definitions; the Agent
+// Manager caps it at 500.
+const nodesListPageSize = 500
+
 func loadNodesLibrary(agentManagerURL string) (NodesLibrary, error) {
-	// Step 1: Fetch the basic node list
-	nodesListURL := agentManagerURL + "/nodes"
-	req, err := http.NewRequest("GET", nodesListURL, nil)
-	if err != nil {
-		return nil, err
+	// Step 1: Fetch the basic node list, following the list's page cursors
+	type nodeSummary struct {
+		ID      string `json:"id"`
+		Type    string `json:"type"`
+		Creator string `json:"creator"`
 	}
-	req.Header.Set("X-Consumer-Username", "internal") // Use internal header
+	var nodeSummaries []nodeSummary
 
 	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
+	cursor := ""
+	for {
+		nodesListURL := fmt.Sprintf("%s/nodes?limit=%d", agentManagerURL, nodesListPageSize)
+		if cursor != "" {
+			nodesListURL += "&cursor=" + url.QueryEscape(cursor)
+		}
+		req, err := http.NewRequest("GET", nodesListURL, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("X-Consumer-Username", "internal") // Use internal header
 
-	// Check response status
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("nodes list request failed with status %d", resp.StatusCode)
-	}
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
 
-	// Parse response body
-	var nodeSummaries []struct {
-		ID      string `json:"id"`
-		Type    string `json:"type"`
-		Creator string `json:"creator"`
-	}
-	if err := json.NewDecoder(resp.Body).Decode(&nodeSummaries); err != nil {
-		return nil, err
+		// Check response status
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("nodes list request failed with status %d", resp.StatusCode)
+		}
+
+		// Parse response body
+		var page []nodeSummary
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		nodeSummaries = append(nodeSummaries, page...)
+
+		// The last page has no next cursor
+		cursor = resp.Header.Get("X-Next-Cursor")
+		if cursor == "" {
+			break
+		}
 	}
 
 	// Step 2: Fetch full details for each node
@@ -213,6 +349,7 @@ func buildExecutionGraph(agent Agent) (ExecutionGraph, error) {
 		Nodes:     make(map[string]NodeInstance),
 		AdjList:   make(map[string][]string),
 		Indegrees: make(map[string]int),
+		Branches:  make(map[string]ConditionBranches),
 	}
 
 	existingEdges := make(map[string]bool)
@@ -243,7 +380,15 @@ func buildExecutionGraph(agent Agent) (ExecutionGraph, error) {
 	// Step 3: Add implicit edges (skip if explicit exists)
 	for _, node := range agent.Nodes {
 		dependencies := extractParameterDependencies(node.Parameters)
+		if node.Type == foreachNodeType {
+			dependencies = extractForeachDependencies(node)
+		}
 		for _, dep := range dependencies {
+			if _, exists := graph.Nodes[dep]; !exists {
+				// References to aliases outside this graph resolve from an enclosing scope at runtime
+				logger.Slog.Info("Reference to alias outside of graph, no edge added", "from", dep, "to", node.Alias)
+				continue
+			}
 			if dep != node.Alias {
 				edgeKey := dep + "->" + node.Alias
 				if dep != node.Alias && !contains(graph.AdjList[dep], node.Alias) {
@@ -258,21 +403,38 @@ func buildExecutionGraph(agent Agent) (ExecutionGraph, error) {
 		}
 	}
 
-	// ✅ Preserve original indegrees before topological sort
-	originalIndegrees := make(map[string]int)
-	for k, v := range graph.Indegrees {
-		originalIndegrees[k] = v
+	// Step 4: Add branch edges from condition nodes to their targets
+	for _, node := range agent.Nodes {
+		if node.Type != conditionNodeType {
+			continue
+		}
+		branches, err := parseConditionBranches(node)
+		if err != nil {
+			return ExecutionGraph{}, err
+		}
+		for _, target := range append(append([]string{}, branches.Then...), branches.Else...) {
+			if _, exists := graph.Nodes[target]; !exists {
+				return ExecutionGraph{}, fmt.Errorf("condition node %s references unknown branch target: %s", node.Alias, target)
+			}
+			edgeKey := node.Alias + "->" + target
+			if !existingEdges[edgeKey] && !contains(graph.AdjList[node.Alias], target) {
+				graph.AdjList[node.Alias] = append(graph.AdjList[node.Alias], target)
+				graph.Indegrees[target]++
+				existingEdges[edgeKey] = true
+				logger.Slog.Info("Added branch edge", "from", node.Alias, "to", target, "current_indegree", graph.Indegrees[target])
+			}
+		}
+		graph.Branches[node.Alias] = branches
 	}
-	graph.Indegrees = originalIndegrees
 
-	// Step 4: Topological Sort
+	// Step 5: Topological Sort
 	order, err := topologicalSort(graph)
 	if err != nil {
 		return ExecutionGraph{}, err
 	}
 	graph.ExecutionOrder = order
 
-	// Step 5: Output the execution graph structure
+	// Step 6: Output the execution graph structure
 	logger.Slog.Info("Execution graph built successfully")
 	for node, neighbors := range graph.AdjList {
 		logger.Slog.Info("Node connections", "node", node, "triggers", neighbors)
@@ -285,12 +447,25 @@ func buildExecutionGraph(agent Agent) (ExecutionGraph, error) {
 	return graph, nil
 }
 
-func executeGraph(agentJobID string, agentCreator string, graph ExecutionGraph, nodesLib []NodeDefinition) (interface{}, error) {
-	state := &ExecutionState{Results: make(map[string]interface{})}
+func executeGraph(ctx context.Context, agentJobID string, agentCreator string, graph ExecutionGraph, nodesLib []NodeDefinition, state *ExecutionState) (interface{}, error) {
+	// Cancel the remaining nodes as soon as one of them fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	var wg sync.WaitGroup
 	errCh := make(chan error, len(graph.ExecutionOrder))
 
-	executedNodes := make(map[string]bool)
+	// Track the parents of every node so we know when all of its dependencies are settled
+	parents := make(map[string][]string)
+	for from, targets := range graph.AdjList {
+		for _, to := range targets {
+			parents[to] = append(parents[to], from)
+		}
+	}
+
+	settledNodes := make(map[string]bool) // Nodes that were executed or skipped
+	skippedNodes := make(map[string]bool) // Nodes skipped because they sit on an untaken branch
+	deadEdges := make(map[string]bool)    // Branch edges not taken by a condition node ("from->to")
 	executedNodesLock := sync.Mutex{}
 
 	logger.Slog.Info("Starting graph execution...")
@@ -301,8 +476,8 @@ func executeGraph(agentJobID string, agentCreator string, graph ExecutionGraph,
 	activeNodesLock := sync.Mutex{} // Lock for activeNodes counter
 
 	// ✅ Seed ONLY nodes with no incoming edges (no dependencies)
-	for nodeAlias, indegree := range graph.Indegrees {
-		if indegree == 0 && !hasIncomingEdges(graph, nodeAlias) {
+	for nodeAlias := range graph.Nodes {
+		if len(parents[nodeAlias]) == 0 {
 			logger.Slog.Info("Seeding node with no dependencies", "node", nodeAlias)
 			nodeQueue <- graph.Nodes[nodeAlias]
 
@@ -312,52 +487,104 @@ func executeGraph(agentJobID string, agentCreator string, graph ExecutionGraph,
 		}
 	}
 
+	// settleNode marks a node as finished and queues or skips the dependents whose
+	// dependencies are now all settled. Callers must hold executedNodesLock.
+	var settleNode func(alias string)
+	settleNode = func(alias string) {
+		settledNodes[alias] = true
+
+		for _, dependent := range graph.AdjList[alias] {
+			// ✅ Check if ALL dependencies have been settled, and whether any incoming
+			// edge is live (its parent ran and, for a condition, took this branch)
+			allDepsSettled := true
+			live := false
+			for _, parent := range parents[dependent] {
+				if !settledNodes[parent] {
+					allDepsSettled = false
+					logger.Slog.Info("Dependency not yet executed", "dependent", dependent, "missing_dependency", parent)
+					break
+				}
+				if !skippedNodes[parent] && !deadEdges[parent+"->"+dependent] {
+					live = true
+				}
+			}
+
+			if !allDepsSettled || settledNodes[dependent] {
+				continue
+			}
+
+			if !live {
+				// Only reachable through untaken branches: skip instead of blocking or failing.
+				// A join node with at least one live incoming edge still runs.
+				logger.Slog.Info("Skipping node on untaken branch", "node", dependent)
+				skippedNodes[dependent] = true
+				emitNodeStatus(agentJobID, state.Scope+dependent, "Skipped", "{}")
+				settleNode(dependent)
+				continue
+			}
+
+			logger.Slog.Info("All dependencies satisfied, adding to queue", "node", dependent)
+			nodeQueue <- graph.Nodes[dependent]
+
+			activeNodesLock.Lock()
+			activeNodes++
+			activeNodesLock.Unlock()
+		}
+	}
+
 	// Worker function to process nodes
 	worker := func() {
-		for node := range nodeQueue {
-			logger.Slog.Info("Executing node", "node", node.Alias)
-
-			if err := executeNode(agentJobID, agentCreator, node, nodesLib, state); err != nil {
-				logger.Slog.Error("Node execution failed", "node", node.Alias, "error", err)
-				errCh <- err
+		for {
+			var node NodeInstance
+			select {
+			case <-ctx.Done():
 				return
+			case next, ok := <-nodeQueue:
+				if !ok {
+					return
+				}
+				node = next
+			}
+
+			state.Lock.RLock()
+			restored := state.Restored[node.Alias]
+			state.Lock.RUnlock()
+
+			if restored {
+				logger.Slog.Info("Skipping node restored from checkpoint", "node", node.Alias)
+			} else {
+				logger.Slog.Info("Executing node", "node", node.Alias)
+
+				if err := executeNode(ctx, agentJobID, agentCreator, node, nodesLib, state); err != nil {
+					logger.Slog.Error("Node execution failed", "node", node.Alias, "error", err)
+					reportNodeFailure(ctx, agentJobID, state.Scope+node.Alias, err)
+					errCh <- err
+					cancel()
+					return
+				}
 			}
 
 			executedNodesLock.Lock()
-			executedNodes[node.Alias] = true
 			logger.Slog.Info("Node execution completed", "node", node.Alias)
-			executedNodesLock.Unlock()
 
-			// ✅ Resolve dependencies strictly
-			for _, dependent := range graph.AdjList[node.Alias] {
-				executedNodesLock.Lock()
-				if graph.Indegrees[dependent] > 0 {
-					graph.Indegrees[dependent]--
-					logger.Slog.Info("Dependency resolved", "from", node.Alias, "to", dependent, "remaining_indegree", graph.Indegrees[dependent])
-				}
-				logger.Slog.Info("Dependency resolved", "from", node.Alias, "to", dependent, "remaining_indegree", graph.Indegrees[dependent])
-
-				// ✅ Check if ALL dependencies have been successfully executed
-				allDepsExecuted := true
-				for parent, children := range graph.AdjList {
-					if contains(children, dependent) && !executedNodes[parent] {
-						allDepsExecuted = false
-						logger.Slog.Info("Dependency not yet executed", "dependent", dependent, "missing_dependency", parent)
-						break
+			// ✅ Disable the edges of the branch a condition node did not take
+			if branches, ok := graph.Branches[node.Alias]; ok {
+				state.Lock.RLock()
+				branch, _ := state.Results[node.Alias+".branch"].(string)
+				state.Lock.RUnlock()
+				for _, target := range branches.untaken(branch) {
+					if contains(branches.Then, target) && contains(branches.Else, target) {
+						continue // Targets on both branches always run
 					}
+					deadEdges[node.Alias+"->"+target] = true
+					logger.Slog.Info("Branch not taken", "condition", node.Alias, "target", target)
 				}
-
-				if graph.Indegrees[dependent] == 0 && allDepsExecuted {
-					logger.Slog.Info("All dependencies satisfied, adding to queue", "node", dependent)
-					nodeQueue <- graph.Nodes[dependent]
-
-					activeNodesLock.Lock()
-					activeNodes++
-					activeNodesLock.Unlock()
-				}
-				executedNodesLock.Unlock()
 			}
 
+			// ✅ Resolve dependencies strictly
+			settleNode(node.Alias)
+			executedNodesLock.Unlock()
+
 			// ✅ Decrement active node count
 			activeNodesLock.Lock()
 			activeNodes--
@@ -384,77 +611,171 @@ func executeGraph(agentJobID string, agentCreator string, graph ExecutionGraph,
 	wg.Wait()
 	close(errCh)
 
-	logger.Slog.Info("Graph execution completed successfully")
-
 	// Error handling
 	if len(errCh) > 0 {
 		return nil, <-errCh
 	}
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("graph execution stopped: %w", err)
+	}
 
-	// ✅ Return the final output
-	finalNode := graph.ExecutionOrder[len(graph.ExecutionOrder)-1]
-	logger.Slog.Info("Graph execution completed successfully", "final_node", finalNode, "result", state.Results[finalNode])
-	return state.Results[finalNode], nil
+	logger.Slog.Info("Graph execution completed successfully")
+
+	// ✅ Return the output of the last node that actually ran
+	for i := len(graph.ExecutionOrder) - 1; i >= 0; i-- {
+		finalNode := graph.ExecutionOrder[i]
+		if skippedNodes[finalNode] {
+			continue
+		}
+		logger.Slog.Info("Graph execution completed successfully", "final_node", finalNode, "result", state.Results[finalNode])
+		return state.Results[finalNode], nil
+	}
+	return nil, nil
 }
 
 //--------------------- Node Execution ---------------------//
 
-func executeNode(agentJobID string, agentCreator string, node NodeInstance, nodesLib []NodeDefinition, state *ExecutionState) error {
+func executeNode(ctx context.Context, agentJobID string, agentCreator string, node NodeInstance, nodesLib []NodeDefinition, state *ExecutionState) error {
+	logger.Slog.Info("Executing node", "alias", node.Alias, "original_parameters", node.Parameters)
+
+	req := NodeRequest{
+		AgentJobID:   agentJobID,
+		AgentCreator: agentCreator,
+		Node:         node,
+		NodesLib:     nodesLib,
+		State:        state,
+	}
+	baseType, constraint := splitNodeType(node.Type)
 	nodeDef, err := findNodeDefinition(node.Type, nodesLib)
+	if err == nil {
+		req.Definition = &nodeDef
+	} else if constraint != "" {
+		return fmt.Errorf("node %s: %w", node.Alias, err)
+	}
+
+	runner, err := resolveNodeRunner(baseType, req.Definition)
 	if err != nil {
 		return err
 	}
 
-	logger.Slog.Info("Executing node", "alias", node.Alias, "original_parameters", node.Parameters)
+	// Apply the node-level timeout from the definition metadata
+	if req.Definition != nil {
+		timeout, err := nodeTimeout(*req.Definition)
+		if err != nil {
+			return err
+		}
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+	}
 
-	// Inject inputs from state (resolve {{alias.some.output}})
-	params, err := injectInputsFromState(node.Parameters, state)
+	result, err := runner.Run(ctx, req)
 	if err != nil {
 		return err
 	}
-	node.Parameters = params
 
-	logger.Slog.Info("Parameters after dependency injection", "alias", node.Alias, "injected_parameters", node.Parameters)
-
-	var result interface{}
-	if nodeDef.API.BaseURL != "" {
-		// Execute API node with injected parameters
-		result, err = executeAPINode(agentCreator, node, nodeDef, state)
+	// Map declared outputs to stable keys and validate them. Dry-run API nodes
+	// return the request with output stubs instead, so they are not mapped.
+	_, apiNode := runner.(HTTPRunner)
+	if req.Definition != nil && !(env.DryRun && apiNode) {
+		result, err = applyOutputs(node.Alias, *req.Definition, result)
 		if err != nil {
 			return err
 		}
-	} else {
-		result = node.Parameters
 	}
 
+	return storeNodeResult(agentJobID, node.Alias, result, state)
+}
+
+// storeNodeResult saves a node's raw and flattened output in the execution state
+// and reports the node as completed.
+func storeNodeResult(agentJobID string, alias string, result interface{}, state *ExecutionState) error {
 	// Save raw and flattened output
 	state.Lock.Lock()
-	state.Results[node.Alias] = result
+	state.Results[alias] = result
 
-	logger.Slog.Info("Execution result", "alias", node.Alias, "result", result)
+	logger.Slog.Info("Execution result", "alias", alias, "result", result)
 
 	// Flatten the output for easy reference
 	flattened := make(map[string]interface{})
 	if resMap, ok := result.(map[string]interface{}); ok {
-		flattenJSON(node.Alias, resMap, flattened)
+		flattenJSON(alias, resMap, flattened)
 		for k, v := range flattened {
 			state.Results[k] = v
 		}
 	}
 	state.Lock.Unlock()
 
-	// ✅ Emit Kubernetes Event with flattened JSON
+	// ✅ Emit Kubernetes Event with flattened JSON, and the raw result for checkpoints
 	flattenedJSON, err := json.Marshal(flattened)
 	if err != nil {
-		logger.Slog.Error("Failed to marshal flattened output", "node", node.Alias, "error", err)
+		logger.Slog.Error("Failed to marshal flattened output", "node", alias, "error", err)
 		return err
 	}
-	emitK8sEvent(agentJobID, node.Alias, "Completed", string(flattenedJSON))
+	resultJSON, err := json.Marshal(result)
+	if err != nil {
+		logger.Slog.Error("Failed to marshal node result", "node", alias, "error", err)
+		return err
+	}
+	emitNodeCompleted(agentJobID, state.Scope+alias, string(flattenedJSON), string(resultJSON))
 
 	return nil
 }
 
-func executeAPINode(agentCreator string, node NodeInstance, def NodeDefinition, state *ExecutionState) (interface{}, error) {
+// reportNodeFailure emits the terminal status of a node that returned an error.
+// Deadline errors are reported as "Timeout" and nodes stopped by a cancelled
+// job as "Cancelled"; nodes stopped because a sibling failed are not reported,
+// since that failure already was.
+func reportNodeFailure(ctx context.Context, agentJobID string, eventAlias string, err error) {
+	status := "Failed"
+	switch {
+	case jobCancelled(ctx):
+		status = "Cancelled"
+	case errors.Is(err, context.DeadlineExceeded):
+		status = "Timeout"
+	case errors.Is(err, context.Canceled):
+		return
+	}
+
+	output := map[string]interface{}{"error": err.Error()}
+	var statusErr *apiStatusError
+	if errors.As(err, &statusErr) {
+		output["status"] = statusErr.StatusCode
+		output["body"] = statusErr.Body
+	}
+
+	outputJSON, _ := json.Marshal(output)
+	emitNodeStatus(agentJobID, eventAlias, status, string(outputJSON))
+}
+
+// nodeTimeout reads the optional per-node timeout from the definition's
+// metadata.additional.timeout. Numbers are seconds; strings may be a number of
+// seconds or a Go duration such as "90s" or "2m".
+func nodeTimeout(def NodeDefinition) (time.Duration, error) {
+	raw, ok := def.Metadata.Additional["timeout"]
+	if !ok || raw == nil {
+		return 0, nil
+	}
+
+	switch v := raw.(type) {
+	case float64:
+		return time.Duration(v * float64(time.Second)), nil
+	case string:
+		if seconds, err := strconv.ParseFloat(v, 64); err == nil {
+			return time.Duration(seconds * float64(time.Second)), nil
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return 0, fmt.Errorf("node definition %s: invalid timeout %q: %w", def.Type, v, err)
+		}
+		return d, nil
+	}
+	return 0, fmt.Errorf("node definition %s: unsupported timeout value of type %T", def.Type, raw)
+}
+
+func executeAPINode(ctx context.Context, agentJobID string, agentCreator string, node NodeInstance, def NodeDefinition, state *ExecutionState) (interface{}, error) {
 	// Inject inputs from state to resolve placeholders
 	params, err := injectInputsFromState(node.Parameters, state)
 	if err != nil {
@@ -468,7 +789,7 @@ func executeAPINode(agentCreator string, node NodeInstance, def NodeDefinition,
 		for key, value := range node.Parameters {
 			placeholder := fmt.Sprintf("{%s}", key)
 			if strings.Contains(url, placeholder) {
-				url = strings.ReplaceAll(url, placeholder, fmt.Sprintf("%v", value))
+				url = strings.ReplaceAll(url, placeholder, formatPlaceholderValue(value))
 				delete(node.Parameters, key) // Remove path params from query/body
 			}
 		}
@@ -476,38 +797,45 @@ func executeAPINode(agentCreator string, node NodeInstance, def NodeDefinition,
 
 	logger.Slog.Info("Preparing API request", "alias", node.Alias, "url", url, "method", def.API.Method, "payload", node.Parameters)
 
-	// Prepare API request
-	var req *http.Request
+	// Prepare API request payload
+	var body []byte
 	if def.API.Method == "POST" || def.API.Method == "PUT" {
 		// Send JSON payload for POST and PUT
-		body, _ := json.Marshal(node.Parameters)
-		req, err = http.NewRequest(def.API.Method, url, strings.NewReader(string(body)))
-		req.Header.Set("Content-Type", "application/json")
+		body, _ = json.Marshal(node.Parameters)
 	} else {
 		// For GET and DELETE, append query parameters if any remain
 		if len(node.Parameters) > 0 {
 			queryParams := make([]string, 0)
 			for key, value := range node.Parameters {
-				queryParams = append(queryParams, fmt.Sprintf("%s=%v", key, value))
+				queryParams = append(queryParams, fmt.Sprintf("%s=%s", key, formatPlaceholderValue(value)))
 			}
 			url = fmt.Sprintf("%s?%s", url, strings.Join(queryParams, "&"))
 		}
-		req, err = http.NewRequest(def.API.Method, url, nil)
 	}
 
-	if err != nil {
-		return nil, err
+	// In dry-run mode, report the resolved request instead of sending it
+	if env.DryRun {
+		logger.Slog.Info("Dry run, skipping API request", "alias", node.Alias, "url", url, "method", def.API.Method)
+		result := map[string]interface{}{
+			"dry_run": true,
+			"method":  def.API.Method,
+			"url":     url,
+			"body":    node.Parameters,
+		}
+		dryRunOutputs(def, result)
+		return result, nil
 	}
 
 	// Handle Authorization header secret replacement
+	headers := make(map[string]string)
 	for key, value := range def.API.Headers {
 		updatedHeader := value
 		if strings.Contains(value, "((") && strings.Contains(value, "))") {
 			// Extract secret key from placeholder ((some_key))
 			secretKey := extractSecretKey(value)
 			if secretKey != "" {
-				// Fetch secret from Kubernetes
-				userSecret, err := fetchUserSecret(agentCreator)
+				// Fetch secret from the configured secret source
+				userSecret, err := env.Secrets.UserSecrets(agentCreator)
 				if err != nil {
 					return nil, fmt.Errorf("failed to fetch user secret: %w", err)
 				}
@@ -521,22 +849,71 @@ func executeAPINode(agentCreator string, node NodeInstance, def NodeDefinition,
 				}
 			}
 		}
-		req.Header.Set(key, updatedHeader)
+		headers[key] = updatedHeader
 	}
 
-	// Execute the API call
-	resp, err := http.DefaultClient.Do(req)
+	// Resolve the retry policy (instance overrides definition)
+	policy, err := resolveRetryPolicy(def.Retry, node.Retry)
 	if err != nil {
 		return nil, err
 	}
-	defer resp.Body.Close()
 
-	// Parse the response
-	var result map[string]interface{}
-	err = json.NewDecoder(resp.Body).Decode(&result)
+	// Execute the API call, retrying transient failures
+	resultValue, err := withRetry(ctx, agentJobID, state.Scope+node.Alias, policy, func(attempt int) (interface{}, error) {
+		var req *http.Request
+		var reqErr error
+		if body != nil {
+			req, reqErr = http.NewRequestWithContext(ctx, def.API.Method, url, bytes.NewReader(body))
+			if reqErr == nil {
+				req.Header.Set("Content-Type", "application/json")
+			}
+		} else {
+			req, reqErr = http.NewRequestWithContext(ctx, def.API.Method, url, nil)
+		}
+		if reqErr != nil {
+			return nil, &permanentError{reqErr}
+		}
+		for key, value := range headers {
+			req.Header.Set(key, value)
+		}
+
+		logger.Slog.Info("Sending API request", "alias", node.Alias, "attempt", attempt, "max_attempts", policy.MaxAttempts)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if policy.isRetryableStatus(resp.StatusCode) {
+			respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+			return nil, &retryableStatusError{StatusCode: resp.StatusCode, Body: string(respBody)}
+		}
+
+		// Any other non-2xx response fails the node with its body captured
+		if err := checkResponseStatus(resp); err != nil {
+			return nil, err
+		}
+
+		var result map[string]interface{}
+		if format := streamFormat(resp, def); format != "" {
+			// Streaming responses are read chunk by chunk with partial output events
+			result, err = readStream(agentJobID, state.Scope+node.Alias, format, resp.Body, def)
+		} else {
+			// Parse the response according to its content type
+			result, err = decodeResponse(resp)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		addResponseMetadata(result, resp, def)
+		return result, nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	result := resultValue.(map[string]interface{})
 
 	logger.Slog.Info("API response received", "alias", node.Alias, "response", result)
 
@@ -599,127 +976,128 @@ func handleError(err error, msg string) {
 	}
 }
 
-func findNodeDefinition(nodeType string, nodesLib []NodeDefinition) (NodeDefinition, error) {
-	for _, node := range nodesLib {
-		if node.Type == nodeType {
-			return node, nil
-		}
-	}
-	return NodeDefinition{}, errors.New("node definition not found")
-}
-
 func injectInputsFromState(params map[string]interface{}, state *ExecutionState) (map[string]interface{}, error) {
 	resolved := make(map[string]interface{})
 
-	// Regex to detect all placeholders like {{ alias.key }}
-	placeholderRegex := regexp.MustCompile(`{{\s*([^{}]+?)\s*}}`)
+	for key, val := range params {
+		newVal, err := resolveParameterValue(val, state)
+		if err != nil {
+			return nil, err
+		}
+		resolved[key] = newVal
+	}
 
-	var resolveValue func(interface{}) (interface{}, error)
+	return resolved, nil
+}
 
-	resolveValue = func(value interface{}) (interface{}, error) {
-		switch v := value.(type) {
-		case string:
-			matches := placeholderRegex.FindAllStringSubmatch(v, -1)
-			if len(matches) == 0 {
-				return v, nil
-			}
+// placeholderRegex detects all placeholders like {{ alias.key }}
+var placeholderRegex = regexp.MustCompile(`{{\s*([^{}]+?)\s*}}`)
+
+// singleReferenceRegex matches a value that is exactly one placeholder
+var singleReferenceRegex = regexp.MustCompile(`^\s*{{\s*([^{}]+?)\s*}}\s*$`)
+
+// resolveParameterValue resolves the placeholders in a parameter value. A string
+// that is exactly one placeholder becomes the referenced value with its type
+// intact (number, bool, array, object). Placeholders embedded in a larger string
+// are replaced by the referenced string, or by its JSON encoding for any other
+// type. Maps and arrays are resolved recursively.
+func resolveParameterValue(value interface{}, state *ExecutionState) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		if match := singleReferenceRegex.FindStringSubmatch(v); match != nil {
+			return resolveStateReference(match[1], state)
+		}
 
-			resolvedStr := v
-			for _, match := range matches {
-				ref := match[1] // e.g., "gpt.choices"
-				parts := strings.Split(ref, ".")
-				alias := parts[0]
+		matches := placeholderRegex.FindAllStringSubmatch(v, -1)
+		if len(matches) == 0 {
+			return v, nil
+		}
 
-				// Lock and fetch value from ExecutionState
-				state.Lock.RLock()
-				data, exists := state.Results[alias]
-				state.Lock.RUnlock()
+		resolvedStr := v
+		for _, match := range matches {
+			result, err := resolveStateReference(match[1], state)
+			if err != nil {
+				return nil, err
+			}
 
-				if !exists {
-					return nil, fmt.Errorf("invalid reference: %s", ref)
-				}
+			// Replace the placeholder with the resolved value
+			resolvedStr = strings.Replace(resolvedStr, match[0], formatPlaceholderValue(result), -1)
+		}
 
-				// Resolve nested properties
-				result := data
-				for _, part := range parts[1:] {
-					// Handle array index (e.g., messages[0])
-					if strings.Contains(part, "[") {
-						key := part[:strings.Index(part, "[")]
-						idxStr := part[strings.Index(part, "[")+1 : strings.Index(part, "]")]
-						index, err := strconv.Atoi(idxStr)
-						if err != nil {
-							return nil, fmt.Errorf("invalid index in reference: %s", part)
-						}
-
-						// Traverse into the array
-						if arr, ok := result.(map[string]interface{})[key].([]interface{}); ok {
-							if index >= 0 && index < len(arr) {
-								result = arr[index]
-							} else {
-								return nil, fmt.Errorf("index out of bounds in reference: %s", part)
-							}
-						} else {
-							return nil, fmt.Errorf("expected array for key: %s", key)
-						}
-					} else {
-						// Traverse into nested objects
-						if nested, ok := result.(map[string]interface{})[part]; ok {
-							result = nested
-						} else {
-							return nil, fmt.Errorf("invalid nested key: %s", part)
-						}
-					}
-				}
+		return resolvedStr, nil
 
-				// Replace the placeholder with the resolved value
-				resolvedStr = strings.Replace(resolvedStr, match[0], fmt.Sprintf("%v", result), -1)
+	case map[string]interface{}:
+		newMap := make(map[string]interface{})
+		for k, v := range v {
+			newVal, err := resolveParameterValue(v, state)
+			if err != nil {
+				return nil, err
 			}
-
-			return resolvedStr, nil
-
-		case map[string]interface{}:
-			newMap := make(map[string]interface{})
-			for k, v := range v {
-				newVal, err := resolveValue(v)
-				if err != nil {
-					return nil, err
-				}
-				newMap[k] = newVal
+			newMap[k] = newVal
+		}
+		return newMap, nil
+
+	case []interface{}:
+		newSlice := make([]interface{}, len(v))
+		for i, item := range v {
+			newVal, err := resolveParameterValue(item, state)
+			if err != nil {
+				return nil, err
 			}
-			return newMap, nil
+			newSlice[i] = newVal
+		}
+		return newSlice, nil
 
-		case []interface{}:
-			newSlice := make([]interface{}, len(v))
-			for i, item := range v {
-				newVal, err := resolveValue(item)
-				if err != nil {
-					return nil, err
-				}
-				newSlice[i] = newVal
-			}
-			return newSlice, nil
+	default:
+		return v, nil
+	}
+}
 
-		default:
-			return v, nil
-		}
+// formatPlaceholderValue renders a resolved value inside a larger string:
+// strings are inserted as-is and everything else is JSON encoded.
+func formatPlaceholderValue(value interface{}) string {
+	if str, ok := value.(string); ok {
+		return str
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprintf("%v", value)
 	}
+	return string(encoded)
+}
 
-	for key, val := range params {
-		newVal, err := resolveValue(val)
-		if err != nil {
-			return nil, err
+// lookup fetches a result from this scope or the closest enclosing scope that defines it.
+func (s *ExecutionState) lookup(key string) (interface{}, bool) {
+	for scope := s; scope != nil; scope = scope.Parent {
+		scope.Lock.RLock()
+		value, exists := scope.Results[key]
+		scope.Lock.RUnlock()
+		if exists {
+			return value, true
 		}
-		resolved[key] = newVal
 	}
+	return nil, false
+}
 
-	return resolved, nil
+// resolveStateReference resolves a reference expression such as
+// "noaa.properties.periods[0].detailedForecast" or `label ?? "none" | upper`
+// against the execution state and returns the referenced value.
+func resolveStateReference(ref string, state *ExecutionState) (interface{}, error) {
+	expr, err := parseReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	return expr.evaluate(state)
 }
 
 func topologicalSort(graph ExecutionGraph) ([]string, error) {
 	var order []string
 	queue := []string{}
 
+	// Work on a copy so the graph keeps its original indegrees
+	indegrees := make(map[string]int)
 	for node, indeg := range graph.Indegrees {
+		indegrees[node] = indeg
 		if indeg == 0 {
 			queue = append(queue, node)
 		}
@@ -731,8 +1109,8 @@ func topologicalSort(graph ExecutionGraph) ([]string, error) {
 		order = append(order, current)
 
 		for _, neighbor := range graph.AdjList[current] {
-			graph.Indegrees[neighbor]--
-			if graph.Indegrees[neighbor] == 0 {
+			indegrees[neighbor]--
+			if indegrees[neighbor] == 0 {
 				queue = append(queue, neighbor)
 			}
 		}
@@ -745,7 +1123,7 @@ func topologicalSort(graph ExecutionGraph) ([]string, error) {
 	return order, nil
 }
 
-func emitK8sEvent(agentJobID string, nodeAlias, status, output string) {
+func emitK8sEvent(agentJobID string, nodeAlias, status, output, result string) {
 	config, err := rest.InClusterConfig()
 	if err != nil {
 		logger.Slog.Error("Failed to create Kubernetes config", "error", err)
@@ -768,7 +1146,7 @@ func emitK8sEvent(agentJobID string, nodeAlias, status, output string) {
 			Name:      agentJobID,
 		},
 		Reason:  "NodeStatusUpdate",
-		Message: fmt.Sprintf("Node %s completed", nodeAlias),
+		Message: fmt.Sprintf("Node %s %s", nodeAlias, strings.ToLower(status)),
 		Type:    "Normal",
 	}
 
@@ -778,6 +1156,9 @@ func emitK8sEvent(agentJobID string, nodeAlias, status, output string) {
 		"status":    status,
 		"output":    string(output), // JSON string instead of raw map
 	}
+	if result != "" {
+		event.Annotations["result"] = result // Unflattened result, restored by checkpoints
+	}
 
 	_, err = clientset.CoreV1().Events("ea-platform").Create(context.TODO(), event, metav1.CreateOptions{})
 	if err != nil {
@@ -789,25 +1170,44 @@ func extractParameterDependencies(parameters map[string]interface{}) []string {
 	var dependencies []string
 	seen := make(map[string]bool)
 
-	// ✅ Updated regex to capture multiple placeholders in one string
-	placeholderRegex := regexp.MustCompile(`{{\s*([^{}]+?)\s*}}`)
-
-	for key, value := range parameters {
-		if strVal, ok := value.(string); ok {
-			matches := placeholderRegex.FindAllStringSubmatch(strVal, -1)
+	var collect func(key string, value interface{})
+	collect = func(key string, value interface{}) {
+		switch v := value.(type) {
+		case string:
+			matches := placeholderRegex.FindAllStringSubmatch(v, -1)
 			for _, match := range matches {
-				fullReference := match[1]                     // e.g., "noaa.properties.periods[0].detailedForecast"
-				alias := strings.Split(fullReference, ".")[0] // Extract alias before the first dot
+				fullReference := match[1] // e.g., "noaa.properties.periods[0].detailedForecast"
+
+				// Text that does not parse, such as Go template actions ({{ .field }}), is not a state reference
+				expr, err := parseReference(fullReference)
+				if err != nil {
+					continue
+				}
 
-				if !seen[alias] {
-					dependencies = append(dependencies, alias)
-					seen[alias] = true
-					logger.Slog.Info("Detected dependency", "parameter_key", key, "alias", alias, "full_reference", match[0])
+				for _, alias := range expr.aliases() {
+					if !seen[alias] {
+						dependencies = append(dependencies, alias)
+						seen[alias] = true
+						logger.Slog.Info("Detected dependency", "parameter_key", key, "alias", alias, "full_reference", match[0])
+					}
 				}
 			}
+		case map[string]interface{}:
+			// Nested objects (e.g. transform.map fields) may hold references too
+			for nestedKey, nested := range v {
+				collect(key+"."+nestedKey, nested)
+			}
+		case []interface{}:
+			for _, nested := range v {
+				collect(key, nested)
+			}
 		}
 	}
 
+	for key, value := range parameters {
+		collect(key, value)
+	}
+
 	return dependencies
 }
 
@@ -832,14 +1232,3 @@ func contains(slice []string, item string) bool {
 	}
 	return false
 }
-
-func hasIncomingEdges(graph ExecutionGraph, nodeAlias string) bool {
-	for _, targets := range graph.AdjList {
-		for _, target := range targets {
-			if target == nodeAlias {
-				return true
-			}
-		}
-	}
-	return false
-}
@@ -0,0 +1,232 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"ea-job-executor/logger"
+)
+
+//--------------------- Foreach Nodes ---------------------//
+
+// foreachNodeType is the built-in node type used to fan out over an array.
+// A foreach node runs its nested "nodes"/"edges" sub-graph once per element of
+// the array referenced by its "items" parameter and collects the results.
+const foreachNodeType = "control.foreach"
+
+// defaultForeachConcurrency bounds the number of iterations running at once.
+const defaultForeachConcurrency = 4
+
+// ForeachSpec holds the parsed parameters of a foreach node.
+type ForeachSpec struct {
+	Items       interface{}    // Array or {{alias.path}} reference to an array
+	As          string         // Name the current element is bound to inside an iteration
+	Concurrency int            // Maximum number of iterations running at once
+	Output      string         // Alias inside the iteration whose result is collected
+	Nodes       []NodeInstance // Nested node instances run per element
+	Edges       []Edge         // Nested edges between the node instances
+}
+
+// parseForeachSpec reads the parameters of a foreach node.
+func parseForeachSpec(node NodeInstance) (ForeachSpec, error) {
+	spec := ForeachSpec{
+		Items:       node.Parameters["items"],
+		As:          "item",
+		Concurrency: defaultForeachConcurrency,
+	}
+
+	if as, ok := node.Parameters["as"].(string); ok && as != "" {
+		spec.As = as
+	}
+	if output, ok := node.Parameters["output"].(string); ok {
+		spec.Output = output
+	}
+	if concurrency, ok := toFloat(node.Parameters["concurrency"]); ok && concurrency >= 1 {
+		spec.Concurrency = int(concurrency)
+	}
+
+	// Round-trip the nested graph through JSON to decode it into typed structs
+	nestedJSON, err := json.Marshal(map[string]interface{}{
+		"nodes": node.Parameters["nodes"],
+		"edges": node.Parameters["edges"],
+	})
+	if err != nil {
+		return ForeachSpec{}, fmt.Errorf("foreach node %s: invalid nested graph: %w", node.Alias, err)
+	}
+	var nested struct {
+		Nodes []NodeInstance `json:"nodes"`
+		Edges []Edge         `json:"edges"`
+	}
+	if err := json.Unmarshal(nestedJSON, &nested); err != nil {
+		return ForeachSpec{}, fmt.Errorf("foreach node %s: invalid nested graph: %w", node.Alias, err)
+	}
+	if len(nested.Nodes) == 0 {
+		return ForeachSpec{}, fmt.Errorf("foreach node %s has no nested nodes", node.Alias)
+	}
+	for i, nestedNode := range nested.Nodes {
+		if nestedNode.Alias == "" {
+			nested.Nodes[i].Alias = fmt.Sprintf("node-%d", i)
+		}
+	}
+	spec.Nodes = nested.Nodes
+	spec.Edges = nested.Edges
+
+	return spec, nil
+}
+
+// extractForeachDependencies returns the aliases a foreach node depends on: the
+// references in "items" plus references from nested nodes to the enclosing graph.
+func extractForeachDependencies(node NodeInstance) []string {
+	dependencies := extractParameterDependencies(map[string]interface{}{"items": node.Parameters["items"]})
+
+	spec, err := parseForeachSpec(node)
+	if err != nil {
+		// Reported when the node is executed
+		return dependencies
+	}
+
+	// Aliases that are local to an iteration are not dependencies of the foreach node
+	local := map[string]bool{spec.As: true, node.Alias: true}
+	for _, nestedNode := range spec.Nodes {
+		local[nestedNode.Alias] = true
+	}
+
+	seen := make(map[string]bool)
+	for _, dep := range dependencies {
+		seen[dep] = true
+	}
+	for _, nestedNode := range spec.Nodes {
+		nestedDeps := extractParameterDependencies(nestedNode.Parameters)
+		if nestedNode.Type == foreachNodeType {
+			nestedDeps = extractForeachDependencies(nestedNode)
+		}
+		for _, dep := range nestedDeps {
+			if !local[dep] && !seen[dep] {
+				dependencies = append(dependencies, dep)
+				seen[dep] = true
+			}
+		}
+	}
+
+	return dependencies
+}
+
+// executeForeachNode runs the nested graph once per element of the items array,
+// with at most spec.Concurrency iterations in flight, and returns the results in
+// element order as {"items": [...], "count": n}.
+func executeForeachNode(ctx context.Context, agentJobID string, agentCreator string, node NodeInstance, nodesLib []NodeDefinition, state *ExecutionState) (map[string]interface{}, error) {
+	spec, err := parseForeachSpec(node)
+	if err != nil {
+		return nil, err
+	}
+
+	items, err := resolveForeachItems(node.Alias, spec.Items, state)
+	if err != nil {
+		return nil, err
+	}
+
+	graph, err := buildExecutionGraph(Agent{Nodes: spec.Nodes, Edges: spec.Edges})
+	if err != nil {
+		return nil, fmt.Errorf("foreach node %s: failed to build nested graph: %w", node.Alias, err)
+	}
+	if spec.Output != "" {
+		if _, exists := graph.Nodes[spec.Output]; !exists {
+			return nil, fmt.Errorf("foreach node %s: output alias %s is not a nested node", node.Alias, spec.Output)
+		}
+	}
+
+	logger.Slog.Info("Starting foreach iterations", "alias", node.Alias, "items", len(items), "concurrency", spec.Concurrency)
+
+	// Stop the remaining iterations as soon as one of them fails
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]interface{}, len(items))
+	errs := make([]error, len(items))
+	sem := make(chan struct{}, spec.Concurrency)
+	var wg sync.WaitGroup
+
+	for i, item := range items {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			errs[i] = fmt.Errorf("foreach node %s: iteration %d not started: %w", node.Alias, i, ctx.Err())
+			continue
+		}
+		wg.Add(1)
+		go func(index int, element interface{}) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			// Each iteration gets its own scope layered over the enclosing state
+			iterationState := &ExecutionState{
+				Results: map[string]interface{}{
+					spec.As: element,
+					node.Alias: map[string]interface{}{
+						"index": index,
+						"item":  element,
+					},
+				},
+				Parent: state,
+				Scope:  fmt.Sprintf("%s%s.%d.", state.Scope, node.Alias, index),
+			}
+
+			output, err := executeGraph(ctx, agentJobID, agentCreator, graph, nodesLib, iterationState)
+			if err != nil {
+				errs[index] = fmt.Errorf("foreach node %s: iteration %d failed: %w", node.Alias, index, err)
+				cancel()
+				return
+			}
+			if spec.Output != "" {
+				iterationState.Lock.RLock()
+				output = iterationState.Results[spec.Output]
+				iterationState.Lock.RUnlock()
+			}
+			results[index] = output
+		}(i, item)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	logger.Slog.Info("Foreach iterations completed", "alias", node.Alias, "items", len(items))
+
+	return map[string]interface{}{
+		"items": results,
+		"count": len(results),
+	}, nil
+}
+
+// resolveForeachItems resolves the "items" parameter to an array. It accepts an
+// array literal or a string holding exactly one {{alias.path}} reference.
+func resolveForeachItems(alias string, items interface{}, state *ExecutionState) ([]interface{}, error) {
+	switch v := items.(type) {
+	case []interface{}:
+		return v, nil
+	case string:
+		ref := strings.TrimSpace(v)
+		if !strings.HasPrefix(ref, "{{") || !strings.HasSuffix(ref, "}}") {
+			return nil, fmt.Errorf("foreach node %s: 'items' must be a single {{alias.path}} reference", alias)
+		}
+		resolved, err := resolveStateReference(strings.TrimSpace(ref[2:len(ref)-2]), state)
+		if err != nil {
+			return nil, fmt.Errorf("foreach node %s: %w", alias, err)
+		}
+		arr, ok := resolved.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("foreach node %s: 'items' resolved to %T, expected an array", alias, resolved)
+		}
+		return arr, nil
+	case nil:
+		return nil, fmt.Errorf("foreach node %s is missing an 'items' parameter", alias)
+	default:
+		return nil, fmt.Errorf("foreach node %s: unsupported 'items' value of type %T", alias, items)
+	}
+}
@@ -0,0 +1,208 @@
+package executor
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"ea-job-executor/logger"
+)
+
+//--------------------- Output Validation ---------------------//
+
+// Node definitions declare their outputs. Before a result is stored, each
+// declared output is looked up at its path (so {{alias.textoutput}} works no
+// matter what the backing API calls the field), checked for presence and type,
+// and written back under its key. How violations are handled depends on the
+// validation mode.
+
+const (
+	outputValidationStrict = "strict" // Fail the node
+	outputValidationWarn   = "warn"   // Log a warning and keep the result
+	outputValidationOff    = "off"    // Skip validation; paths are still mapped
+)
+
+// outputValidationMode returns the mode for a definition:
+// metadata.additional.output_validation, else the runtime default, else "warn".
+func outputValidationMode(def NodeDefinition) (string, error) {
+	mode := env.OutputValidation
+	if configured, ok := def.Metadata.Additional["output_validation"].(string); ok && configured != "" {
+		mode = configured
+	}
+	switch mode {
+	case "":
+		return outputValidationWarn, nil
+	case outputValidationStrict, outputValidationWarn, outputValidationOff:
+		return mode, nil
+	}
+	return "", fmt.Errorf("node definition %s: invalid output_validation %q (expected strict, warn or off)", def.Type, mode)
+}
+
+// applyOutputs maps and validates the declared outputs of a node result.
+func applyOutputs(alias string, def NodeDefinition, result interface{}) (interface{}, error) {
+	if len(def.Outputs) == 0 {
+		return result, nil
+	}
+
+	mode, err := outputValidationMode(def)
+	if err != nil {
+		return nil, err
+	}
+
+	resMap, ok := result.(map[string]interface{})
+	if !ok {
+		resMap = wrapValue(result)
+	}
+
+	var violations []string
+	for _, output := range def.Outputs {
+		if output.Key == "" {
+			continue
+		}
+
+		path := output.Path
+		if path == "" {
+			path = output.Key
+		}
+		value, found := lookupResultPath(resMap, path)
+		if !found {
+			if !output.Optional {
+				violations = append(violations, fmt.Sprintf("missing output %q (path %q)", output.Key, path))
+			}
+			continue
+		}
+		if err := checkOutputType(output.Type, value); err != nil {
+			violations = append(violations, fmt.Sprintf("output %q: %v", output.Key, err))
+		}
+		resMap[output.Key] = value
+	}
+
+	if len(violations) > 0 && mode != outputValidationOff {
+		if mode == outputValidationStrict {
+			return nil, &permanentError{fmt.Errorf("node %s (%s) output validation failed: %s", alias, def.Type, strings.Join(violations, "; "))}
+		}
+		logger.Slog.Warn("Node output does not match its definition", "alias", alias, "nodeType", def.Type, "violations", violations)
+	}
+
+	return resMap, nil
+}
+
+// lookupResultPath reads a value at a reference path such as "response" or
+// "choices[0].message.content". Missing and null values are not found.
+func lookupResultPath(result map[string]interface{}, path string) (interface{}, bool) {
+	expr, err := parseReference("result." + path)
+	if err != nil {
+		return nil, false
+	}
+	value, err := expr.evaluate(&ExecutionState{Results: map[string]interface{}{"result": result}})
+	if err != nil || value == nil {
+		return nil, false
+	}
+	return value, true
+}
+
+// checkOutputType checks a value against a declared output type. Empty,
+// "any" and unknown types accept everything.
+func checkOutputType(declared string, value interface{}) error {
+	var ok bool
+	switch strings.ToLower(declared) {
+	case "string":
+		_, ok = value.(string)
+	case "number", "float":
+		_, ok = outputNumber(value)
+	case "integer", "int":
+		f, isNumber := outputNumber(value)
+		ok = isNumber && f == math.Trunc(f)
+	case "bool", "boolean":
+		_, ok = value.(bool)
+	case "object":
+		_, ok = value.(map[string]interface{})
+	case "array":
+		_, ok = value.([]interface{})
+	default:
+		return nil
+	}
+	if !ok {
+		return fmt.Errorf("expected %s, got %T", declared, value)
+	}
+	return nil
+}
+
+// outputNumber is toFloat without string parsing: "42" is not a number output.
+func outputNumber(value interface{}) (float64, bool) {
+	if _, isString := value.(string); isString {
+		return 0, false
+	}
+	return toFloat(value)
+}
+
+//--------------------- Dry-Run Outputs ---------------------//
+
+// dryRunOutputs adds a placeholder value for every declared output to the
+// result of a dry-run API node, under the output key and at its path, so
+// references to the outputs resolve downstream. Undeclared fields of the real
+// response stay missing.
+func dryRunOutputs(def NodeDefinition, result map[string]interface{}) {
+	for _, output := range def.Outputs {
+		if output.Key == "" {
+			continue
+		}
+		value := dryRunValue(output.Type)
+		result[output.Key] = value
+		if output.Path == "" || output.Path == output.Key {
+			continue
+		}
+		expr, err := parseReference("result." + output.Path)
+		if err != nil || len(expr.operands) != 1 {
+			continue
+		}
+		setResultPath(result, expr.operands[0].steps, value)
+	}
+}
+
+// dryRunValue returns the placeholder for an output of the declared type.
+func dryRunValue(declared string) interface{} {
+	switch strings.ToLower(declared) {
+	case "number", "float", "integer", "int":
+		return 0
+	case "bool", "boolean":
+		return false
+	case "object":
+		return map[string]interface{}{}
+	case "array":
+		return []interface{}{}
+	}
+	return "dry-run"
+}
+
+// setResultPath writes value at the path steps below container, creating the
+// objects and arrays on the way, and returns the updated container.
+func setResultPath(container interface{}, steps []refStep, value interface{}) interface{} {
+	if len(steps) == 0 {
+		return value
+	}
+	step := steps[0]
+	if !step.isIndex {
+		obj, ok := container.(map[string]interface{})
+		if !ok {
+			obj = make(map[string]interface{})
+		}
+		obj[step.key] = setResultPath(obj[step.key], steps[1:], value)
+		return obj
+	}
+
+	arr, _ := container.([]interface{})
+	index := step.index
+	if index < 0 {
+		// A negative index counts from the end; pad the array so it exists
+		for len(arr) < -index {
+			arr = append(arr, nil)
+		}
+		index += len(arr)
+	}
+	for len(arr) <= index {
+		arr = append(arr, nil)
+	}
+	arr[index] = setResultPath(arr[index], steps[1:], value)
+	return arr
+}
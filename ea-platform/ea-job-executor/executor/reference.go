@@ -0,0 +1,351 @@
+package executor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//--------------------- Reference Expressions ---------------------//
+
+// A reference expression is the text inside a {{ }} placeholder:
+//
+//	alias.key.sub[0][1]          nested keys and multi-dimensional indexes
+//	alias["key.with.dots"].x     quoted keys
+//	alias.items[-1]              negative indexes count from the end
+//	alias.label ?? "unknown"     fallback when a reference is missing or null
+//	alias.name | upper | trim    filters applied left to right
+//
+// Operands of ?? may be references or string, number, true, false and null literals.
+
+// refStep is one traversal step of a reference path.
+type refStep struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// refOperand is a reference path or a literal value.
+type refOperand struct {
+	alias     string
+	steps     []refStep
+	literal   interface{}
+	isLiteral bool
+}
+
+// refExpr is a parsed reference expression.
+type refExpr struct {
+	operands []refOperand // Alternatives separated by ??
+	filters  []string
+}
+
+// referenceFilters are the filters available after a | in a reference.
+var referenceFilters = map[string]func(interface{}) (interface{}, error){
+	"upper": func(v interface{}) (interface{}, error) { return strings.ToUpper(formatPlaceholderValue(v)), nil },
+	"lower": func(v interface{}) (interface{}, error) { return strings.ToLower(formatPlaceholderValue(v)), nil },
+	"trim":  func(v interface{}) (interface{}, error) { return strings.TrimSpace(formatPlaceholderValue(v)), nil },
+	"json": func(v interface{}) (interface{}, error) {
+		encoded, err := json.Marshal(v)
+		return string(encoded), err
+	},
+	"length": func(v interface{}) (interface{}, error) {
+		switch val := v.(type) {
+		case string:
+			return len([]rune(val)), nil
+		case []interface{}:
+			return len(val), nil
+		case map[string]interface{}:
+			return len(val), nil
+		case nil:
+			return 0, nil
+		}
+		return nil, fmt.Errorf("length: unsupported type %T", v)
+	},
+	"first": func(v interface{}) (interface{}, error) {
+		if arr, ok := v.([]interface{}); ok {
+			if len(arr) == 0 {
+				return nil, nil
+			}
+			return arr[0], nil
+		}
+		return nil, fmt.Errorf("first: expected array, got %T", v)
+	},
+	"last": func(v interface{}) (interface{}, error) {
+		if arr, ok := v.([]interface{}); ok {
+			if len(arr) == 0 {
+				return nil, nil
+			}
+			return arr[len(arr)-1], nil
+		}
+		return nil, fmt.Errorf("last: expected array, got %T", v)
+	},
+	"keys": func(v interface{}) (interface{}, error) {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("keys: expected object, got %T", v)
+		}
+		keys := make([]string, 0, len(obj))
+		for key := range obj {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		result := make([]interface{}, len(keys))
+		for i, key := range keys {
+			result[i] = key
+		}
+		return result, nil
+	},
+}
+
+// aliases returns the node aliases the expression reads from.
+func (e *refExpr) aliases() []string {
+	var aliases []string
+	for _, operand := range e.operands {
+		if !operand.isLiteral {
+			aliases = append(aliases, operand.alias)
+		}
+	}
+	return aliases
+}
+
+// evaluate resolves the expression against the execution state.
+func (e *refExpr) evaluate(state *ExecutionState) (interface{}, error) {
+	var value interface{}
+	var lastErr error
+	for i, operand := range e.operands {
+		v, err := operand.resolve(state)
+		last := i == len(e.operands)-1
+		if err != nil {
+			lastErr = err
+			if last {
+				return nil, err
+			}
+			continue
+		}
+		if v == nil && !last {
+			continue
+		}
+		value, lastErr = v, nil
+		break
+	}
+	if lastErr != nil {
+		return nil, lastErr
+	}
+
+	for _, name := range e.filters {
+		filtered, err := referenceFilters[name](value)
+		if err != nil {
+			return nil, err
+		}
+		value = filtered
+	}
+	return value, nil
+}
+
+func (o refOperand) resolve(state *ExecutionState) (interface{}, error) {
+	if o.isLiteral {
+		return o.literal, nil
+	}
+
+	// Fetch value from ExecutionState, falling back to enclosing scopes
+	result, exists := state.lookup(o.alias)
+	if !exists {
+		return nil, fmt.Errorf("invalid reference: %s", o.alias)
+	}
+
+	for _, step := range o.steps {
+		if step.isIndex {
+			arr, ok := result.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("expected array at index [%d] in reference to %s, got %T", step.index, o.alias, result)
+			}
+			index := step.index
+			if index < 0 {
+				index += len(arr)
+			}
+			if index < 0 || index >= len(arr) {
+				return nil, fmt.Errorf("index out of bounds in reference: [%d]", step.index)
+			}
+			result = arr[index]
+			continue
+		}
+
+		obj, ok := result.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected object at key %s in reference to %s, got %T", step.key, o.alias, result)
+		}
+		nested, ok := obj[step.key]
+		if !ok {
+			return nil, fmt.Errorf("invalid nested key: %s", step.key)
+		}
+		result = nested
+	}
+	return result, nil
+}
+
+//--------------------- Reference Parser ---------------------//
+
+// parseReference parses the text of a placeholder into an expression.
+func parseReference(text string) (*refExpr, error) {
+	p := &refParser{src: text}
+	expr := &refExpr{}
+
+	for {
+		operand, err := p.parseOperand()
+		if err != nil {
+			return nil, err
+		}
+		expr.operands = append(expr.operands, operand)
+		p.skipSpace()
+		if !p.consume("??") {
+			break
+		}
+	}
+
+	for p.consume("|") {
+		p.skipSpace()
+		name := p.readIdent()
+		if _, ok := referenceFilters[name]; !ok {
+			return nil, fmt.Errorf("unknown filter %q in reference %q", name, text)
+		}
+		expr.filters = append(expr.filters, name)
+		p.skipSpace()
+	}
+
+	if p.pos < len(p.src) {
+		return nil, fmt.Errorf("unexpected %q in reference %q", p.src[p.pos:], text)
+	}
+	return expr, nil
+}
+
+type refParser struct {
+	src string
+	pos int
+}
+
+func (p *refParser) skipSpace() {
+	for p.pos < len(p.src) && (p.src[p.pos] == ' ' || p.src[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *refParser) consume(token string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.src[p.pos:], token) {
+		p.pos += len(token)
+		return true
+	}
+	return false
+}
+
+func isRefIdentChar(ch byte) bool {
+	return (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z') || (ch >= '0' && ch <= '9') || ch == '_' || ch == '-'
+}
+
+func (p *refParser) readIdent() string {
+	start := p.pos
+	for p.pos < len(p.src) && isRefIdentChar(p.src[p.pos]) {
+		p.pos++
+	}
+	return p.src[start:p.pos]
+}
+
+func (p *refParser) readQuoted() (string, error) {
+	quote := p.src[p.pos]
+	p.pos++
+	var sb strings.Builder
+	for p.pos < len(p.src) && p.src[p.pos] != quote {
+		if p.src[p.pos] == '\\' && p.pos+1 < len(p.src) {
+			p.pos++
+		}
+		sb.WriteByte(p.src[p.pos])
+		p.pos++
+	}
+	if p.pos >= len(p.src) {
+		return "", errors.New("unterminated string in reference")
+	}
+	p.pos++
+	return sb.String(), nil
+}
+
+func (p *refParser) parseOperand() (refOperand, error) {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return refOperand{}, fmt.Errorf("empty reference in %q", p.src)
+	}
+
+	ch := p.src[p.pos]
+	switch {
+	case ch == '"' || ch == '\'':
+		s, err := p.readQuoted()
+		return refOperand{literal: s, isLiteral: true}, err
+	case (ch >= '0' && ch <= '9') || ch == '-':
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.src) && ((p.src[p.pos] >= '0' && p.src[p.pos] <= '9') || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		f, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		if err != nil {
+			return refOperand{}, fmt.Errorf("invalid number %q in reference", p.src[start:p.pos])
+		}
+		return refOperand{literal: f, isLiteral: true}, nil
+	}
+
+	alias := p.readIdent()
+	if alias == "" {
+		return refOperand{}, fmt.Errorf("unexpected %q in reference %q", p.src[p.pos:], p.src)
+	}
+	switch alias {
+	case "true":
+		return refOperand{literal: true, isLiteral: true}, nil
+	case "false":
+		return refOperand{literal: false, isLiteral: true}, nil
+	case "null":
+		return refOperand{literal: nil, isLiteral: true}, nil
+	}
+
+	operand := refOperand{alias: alias}
+	for p.pos < len(p.src) {
+		switch p.src[p.pos] {
+		case '.':
+			p.pos++
+			key := p.readIdent()
+			if key == "" {
+				return refOperand{}, fmt.Errorf("expected key after '.' in reference %q", p.src)
+			}
+			operand.steps = append(operand.steps, refStep{key: key})
+		case '[':
+			p.pos++
+			p.skipSpace()
+			if p.pos < len(p.src) && (p.src[p.pos] == '"' || p.src[p.pos] == '\'') {
+				key, err := p.readQuoted()
+				if err != nil {
+					return refOperand{}, err
+				}
+				operand.steps = append(operand.steps, refStep{key: key})
+			} else {
+				start := p.pos
+				for p.pos < len(p.src) && p.src[p.pos] != ']' {
+					p.pos++
+				}
+				index, err := strconv.Atoi(strings.TrimSpace(p.src[start:p.pos]))
+				if err != nil {
+					return refOperand{}, fmt.Errorf("invalid index in reference: %s", p.src[start:p.pos])
+				}
+				operand.steps = append(operand.steps, refStep{index: index, isIndex: true})
+			}
+			p.skipSpace()
+			if p.pos >= len(p.src) || p.src[p.pos] != ']' {
+				return refOperand{}, fmt.Errorf("missing ']' in reference %q", p.src)
+			}
+			p.pos++
+		default:
+			return operand, nil
+		}
+	}
+	return operand, nil
+}
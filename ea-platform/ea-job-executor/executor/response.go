@@ -0,0 +1,158 @@
+package executor
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"ea-job-executor/logger"
+)
+
+//--------------------- API Responses ---------------------//
+
+// maxErrorBodySize bounds how much of a failed response body is captured.
+const maxErrorBodySize = 64 * 1024
+
+// defaultResponseHeaders are exposed as {{alias.headers.<name>}} unless the
+// definition lists its own in metadata.additional.response_headers.
+var defaultResponseHeaders = []string{"Content-Type", "Content-Length", "ETag", "Location", "Retry-After", "X-Request-Id"}
+
+// apiStatusError is a non-2xx response that is not retried.
+type apiStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *apiStatusError) Error() string {
+	return fmt.Sprintf("API returned status %d: %s", e.StatusCode, e.Body)
+}
+
+// checkResponseStatus turns a non-2xx response into an apiStatusError carrying the body.
+func checkResponseStatus(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	return &permanentError{&apiStatusError{StatusCode: resp.StatusCode, Body: string(body)}}
+}
+
+// decodeResponse converts a 2xx response body into a node result based on its
+// content type:
+//
+//	empty body (e.g. 204)  -> {}
+//	JSON object            -> the object
+//	JSON array             -> {"items": [...]}
+//	other JSON value       -> {"value": v}
+//	text/*, XML            -> {"text": "..."}
+//	anything else          -> {"binary": {"ref", "path", "content_type", "size", "sha256"}}
+func decodeResponse(resp *http.Response) (map[string]interface{}, error) {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return map[string]interface{}{}, nil
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	isJSON := mediaType == "application/json" || strings.HasSuffix(mediaType, "+json")
+	isText := strings.HasPrefix(mediaType, "text/") || mediaType == "application/xml" || strings.HasSuffix(mediaType, "+xml")
+
+	// Untyped or mislabelled responses are sniffed: JSON if it parses, text if printable
+	if mediaType == "text/plain" && json.Valid(body) {
+		isJSON, isText = true, false
+	}
+	if mediaType == "" || mediaType == "application/octet-stream" {
+		if json.Valid(body) {
+			isJSON = true
+		} else if mediaType == "" && strings.HasPrefix(http.DetectContentType(body), "text/") {
+			isText = true
+		}
+	}
+
+	switch {
+	case isJSON:
+		var value interface{}
+		if err := json.Unmarshal(body, &value); err != nil {
+			return nil, &permanentError{fmt.Errorf("invalid JSON response: %w", err)}
+		}
+		switch v := value.(type) {
+		case map[string]interface{}:
+			return v, nil
+		case []interface{}:
+			return map[string]interface{}{"items": v}, nil
+		default:
+			return map[string]interface{}{"value": v}, nil
+		}
+	case isText:
+		return map[string]interface{}{"text": string(body)}, nil
+	default:
+		ref, err := storeBlob(body, mediaType)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"binary": ref}, nil
+	}
+}
+
+// storeBlob writes a binary response to the blob directory, named by its
+// SHA-256, and returns a reference to it.
+func storeBlob(data []byte, contentType string) (map[string]interface{}, error) {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+
+	dir := env.BlobDir
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "ea-job-executor", "blobs")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	path := filepath.Join(dir, hash)
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		return nil, fmt.Errorf("failed to store binary response: %w", err)
+	}
+
+	logger.Slog.Info("Stored binary response", "sha256", hash, "size", len(data), "content_type", contentType)
+
+	return map[string]interface{}{
+		"ref":          "blob://sha256/" + hash,
+		"path":         path,
+		"content_type": contentType,
+		"size":         len(data),
+		"sha256":       hash,
+	}, nil
+}
+
+// addResponseMetadata exposes the HTTP status code and selected headers as
+// "status" and "headers". Body fields of the same name are moved to
+// "body_status" and "body_headers" so the metadata is always available.
+func addResponseMetadata(result map[string]interface{}, resp *http.Response, def NodeDefinition) {
+	names := defaultResponseHeaders
+	if configured, err := aliasList(def.Metadata.Additional["response_headers"]); err == nil && len(configured) > 0 {
+		names = configured
+	}
+
+	headers := make(map[string]interface{})
+	for _, name := range names {
+		if value := resp.Header.Get(name); value != "" {
+			headers[strings.ToLower(name)] = value
+		}
+	}
+
+	for key, value := range map[string]interface{}{"status": resp.StatusCode, "headers": headers} {
+		if bodyValue, exists := result[key]; exists {
+			logger.Slog.Info("Moved response body field that collides with HTTP metadata", "field", key, "moved_to", "body_"+key)
+			result["body_"+key] = bodyValue
+		}
+		result[key] = value
+	}
+}
@@ -0,0 +1,199 @@
+package executor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"ea-job-executor/logger"
+)
+
+//--------------------- Retry Policies ---------------------//
+
+// RetryPolicy controls how often a failed API node call is retried. It can be
+// declared on a NodeDefinition and overridden per NodeInstance.
+type RetryPolicy struct {
+	MaxAttempts          int     `json:"max_attempts,omitempty"`           // Total attempts including the first call
+	InitialBackoff       string  `json:"initial_backoff,omitempty"`        // Delay before the first retry, e.g. "500ms"
+	MaxBackoff           string  `json:"max_backoff,omitempty"`            // Upper bound for the delay between attempts
+	Multiplier           float64 `json:"multiplier,omitempty"`             // Factor applied to the delay after each retry
+	RetryableStatusCodes []int   `json:"retryable_status_codes,omitempty"` // HTTP status codes that trigger a retry
+}
+
+// Defaults applied to the fields a retry policy leaves unset.
+const (
+	defaultRetryMaxAttempts    = 1 // No retries unless a policy asks for them
+	defaultRetryInitialBackoff = time.Second
+	defaultRetryMaxBackoff     = 30 * time.Second
+	defaultRetryMultiplier     = 2.0
+)
+
+var defaultRetryableStatusCodes = []int{429, 502, 503, 504}
+
+// resolvedRetryPolicy is a RetryPolicy with all defaults applied.
+type resolvedRetryPolicy struct {
+	MaxAttempts          int
+	InitialBackoff       time.Duration
+	MaxBackoff           time.Duration
+	Multiplier           float64
+	RetryableStatusCodes []int
+}
+
+// retryableStatusError marks an HTTP response whose status code may be retried.
+type retryableStatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *retryableStatusError) Error() string {
+	return fmt.Sprintf("API returned retryable status %d: %s", e.StatusCode, e.Body)
+}
+
+// resolveRetryPolicy merges the instance override over the definition policy
+// field by field and fills in defaults.
+func resolveRetryPolicy(def *RetryPolicy, override *RetryPolicy) (resolvedRetryPolicy, error) {
+	merged := RetryPolicy{}
+	for _, p := range []*RetryPolicy{def, override} {
+		if p == nil {
+			continue
+		}
+		if p.MaxAttempts > 0 {
+			merged.MaxAttempts = p.MaxAttempts
+		}
+		if p.InitialBackoff != "" {
+			merged.InitialBackoff = p.InitialBackoff
+		}
+		if p.MaxBackoff != "" {
+			merged.MaxBackoff = p.MaxBackoff
+		}
+		if p.Multiplier > 0 {
+			merged.Multiplier = p.Multiplier
+		}
+		if len(p.RetryableStatusCodes) > 0 {
+			merged.RetryableStatusCodes = p.RetryableStatusCodes
+		}
+	}
+
+	policy := resolvedRetryPolicy{
+		MaxAttempts:          defaultRetryMaxAttempts,
+		InitialBackoff:       defaultRetryInitialBackoff,
+		MaxBackoff:           defaultRetryMaxBackoff,
+		Multiplier:           defaultRetryMultiplier,
+		RetryableStatusCodes: defaultRetryableStatusCodes,
+	}
+	if merged.MaxAttempts > 0 {
+		policy.MaxAttempts = merged.MaxAttempts
+	}
+	if merged.InitialBackoff != "" {
+		d, err := time.ParseDuration(merged.InitialBackoff)
+		if err != nil {
+			return resolvedRetryPolicy{}, fmt.Errorf("invalid retry initial_backoff %q: %w", merged.InitialBackoff, err)
+		}
+		policy.InitialBackoff = d
+	}
+	if merged.MaxBackoff != "" {
+		d, err := time.ParseDuration(merged.MaxBackoff)
+		if err != nil {
+			return resolvedRetryPolicy{}, fmt.Errorf("invalid retry max_backoff %q: %w", merged.MaxBackoff, err)
+		}
+		policy.MaxBackoff = d
+	}
+	if merged.Multiplier > 0 {
+		policy.Multiplier = merged.Multiplier
+	}
+	if len(merged.RetryableStatusCodes) > 0 {
+		policy.RetryableStatusCodes = merged.RetryableStatusCodes
+	}
+
+	return policy, nil
+}
+
+// isRetryableStatus reports whether the policy retries the given HTTP status code.
+func (p resolvedRetryPolicy) isRetryableStatus(statusCode int) bool {
+	return containsInt(p.RetryableStatusCodes, statusCode)
+}
+
+// backoff returns the delay before the given retry (1 for the first retry).
+func (p resolvedRetryPolicy) backoff(retry int) time.Duration {
+	delay := float64(p.InitialBackoff) * math.Pow(p.Multiplier, float64(retry-1))
+	if delay > float64(p.MaxBackoff) {
+		return p.MaxBackoff
+	}
+	return time.Duration(delay)
+}
+
+// withRetry runs call until it succeeds, returns a non-retryable error or the
+// policy runs out of attempts. Every failed attempt that will be retried is
+// reported as a "Retrying" node status event.
+func withRetry(ctx context.Context, agentJobID string, eventAlias string, policy resolvedRetryPolicy, call func(attempt int) (interface{}, error)) (interface{}, error) {
+	var lastErr error
+	attempts := 0
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		attempts = attempt
+		result, err := call(attempt)
+		if err == nil {
+			if attempt > 1 {
+				logger.Slog.Info("API call succeeded after retry", "alias", eventAlias, "attempt", attempt)
+			}
+			return result, nil
+		}
+		lastErr = err
+
+		if ctx.Err() != nil || !isRetryableError(err) || attempt == policy.MaxAttempts {
+			break
+		}
+
+		delay := policy.backoff(attempt)
+		logger.Slog.Warn("API call failed, retrying", "alias", eventAlias, "attempt", attempt, "max_attempts", policy.MaxAttempts, "backoff", delay.String(), "error", err)
+
+		attemptJSON, _ := json.Marshal(map[string]interface{}{
+			"attempt":      attempt,
+			"max_attempts": policy.MaxAttempts,
+			"error":        err.Error(),
+			"next_backoff": delay.String(),
+		})
+		emitNodeStatus(agentJobID, eventAlias, "Retrying", string(attemptJSON))
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("retry aborted after %d attempt(s): %w", attempt, ctx.Err())
+		}
+	}
+
+	if policy.MaxAttempts > 1 {
+		return nil, fmt.Errorf("API call failed after %d attempt(s): %w", attempts, lastErr)
+	}
+	return nil, lastErr
+}
+
+// isRetryableError reports whether an attempt error should be retried. Transport
+// errors and retryable status codes are retried; everything else fails fast.
+func isRetryableError(err error) bool {
+	var statusErr *retryableStatusError
+	if errors.As(err, &statusErr) {
+		return true
+	}
+	var permanent *permanentError
+	return !errors.As(err, &permanent)
+}
+
+// permanentError wraps failures that retrying cannot fix (bad requests, missing secrets, undecodable responses).
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+func containsInt(slice []int, item int) bool {
+	for _, v := range slice {
+		if v == item {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"ea-job-executor/logger"
+)
+
+//--------------------- Node Runners ---------------------//
+
+// NodeRequest carries everything a runner needs to execute one node instance.
+type NodeRequest struct {
+	AgentJobID   string
+	AgentCreator string
+	Node         NodeInstance
+	Definition   *NodeDefinition  // Nil when the node type has no definition in the library
+	NodesLib     []NodeDefinition // Full library, for runners that execute nested nodes
+	State        *ExecutionState
+}
+
+// NodeRunner executes a node and returns its result. Map results are flattened
+// into the execution state so later nodes can reference {{alias.path}}.
+// Parameters arrive unresolved; runners that want {{alias.path}} placeholders
+// substituted call injectInputsFromState, while built-ins such as condition
+// nodes evaluate the raw values themselves.
+type NodeRunner interface {
+	Run(ctx context.Context, req NodeRequest) (interface{}, error)
+}
+
+// NodeRunnerFunc adapts a function to the NodeRunner interface.
+type NodeRunnerFunc func(ctx context.Context, req NodeRequest) (interface{}, error)
+
+func (f NodeRunnerFunc) Run(ctx context.Context, req NodeRequest) (interface{}, error) {
+	return f(ctx, req)
+}
+
+// runnerRegistry maps node type patterns to runners. A pattern is either an
+// exact node type ("control.condition") or a prefix ending in ".*" ("worker.api.*").
+var runnerRegistry = struct {
+	sync.RWMutex
+	runners map[string]NodeRunner
+}{runners: make(map[string]NodeRunner)}
+
+func init() {
+	RegisterNodeRunner(conditionNodeType, NodeRunnerFunc(runConditionNode))
+	RegisterNodeRunner(foreachNodeType, NodeRunnerFunc(runForeachNode))
+	RegisterNodeRunner("control.*", NodeRunnerFunc(runUnknownBuiltinNode))
+	RegisterNodeRunner(transformTemplateNodeType, NodeRunnerFunc(runTemplateNode))
+	RegisterNodeRunner(transformJSONPathNodeType, NodeRunnerFunc(runJSONPathNode))
+	RegisterNodeRunner(transformMapNodeType, NodeRunnerFunc(runMapNode))
+	RegisterNodeRunner(transformCoerceNodeType, NodeRunnerFunc(runCoerceNode))
+	RegisterNodeRunner("transform.*", NodeRunnerFunc(runUnknownBuiltinNode))
+	RegisterNodeRunner("worker.api.*", HTTPRunner{})
+	RegisterNodeRunner("internal.*", PassthroughRunner{})
+}
+
+// RegisterNodeRunner registers runner for a node type or ".*" type prefix,
+// replacing any runner previously registered for the same pattern.
+func RegisterNodeRunner(pattern string, runner NodeRunner) {
+	runnerRegistry.Lock()
+	defer runnerRegistry.Unlock()
+	runnerRegistry.runners[pattern] = runner
+}
+
+// UnregisterNodeRunner removes the runner registered for pattern.
+func UnregisterNodeRunner(pattern string) {
+	runnerRegistry.Lock()
+	defer runnerRegistry.Unlock()
+	delete(runnerRegistry.runners, pattern)
+}
+
+// lookupNodeRunner returns the runner for a node type. An exact registration
+// wins; otherwise the longest matching prefix is used.
+func lookupNodeRunner(nodeType string) (NodeRunner, bool) {
+	runnerRegistry.RLock()
+	defer runnerRegistry.RUnlock()
+
+	if runner, ok := runnerRegistry.runners[nodeType]; ok {
+		return runner, true
+	}
+
+	prefixes := make([]string, 0, len(runnerRegistry.runners))
+	for pattern := range runnerRegistry.runners {
+		if strings.HasSuffix(pattern, ".*") {
+			prefixes = append(prefixes, pattern)
+		}
+	}
+	sort.Slice(prefixes, func(i, j int) bool { return len(prefixes[i]) > len(prefixes[j]) })
+
+	for _, pattern := range prefixes {
+		if strings.HasPrefix(nodeType, strings.TrimSuffix(pattern, "*")) {
+			return runnerRegistry.runners[pattern], true
+		}
+	}
+	return nil, false
+}
+
+// resolveNodeRunner picks the runner for a node: a registered runner if one
+// matches, otherwise HTTP for definitions with an API base URL and passthrough
+// for the rest.
+func resolveNodeRunner(nodeType string, def *NodeDefinition) (NodeRunner, error) {
+	if runner, ok := lookupNodeRunner(nodeType); ok {
+		return runner, nil
+	}
+	if def == nil {
+		return nil, fmt.Errorf("node definition not found for type %s", nodeType)
+	}
+	if def.API.BaseURL != "" {
+		return HTTPRunner{}, nil
+	}
+	return PassthroughRunner{}, nil
+}
+
+//--------------------- Built-in Runners ---------------------//
+
+// HTTPRunner calls the API described by the node definition.
+type HTTPRunner struct{}
+
+func (HTTPRunner) Run(ctx context.Context, req NodeRequest) (interface{}, error) {
+	if req.Definition == nil {
+		return nil, fmt.Errorf("node %s: API node type %s has no definition", req.Node.Alias, req.Node.Type)
+	}
+	return executeAPINode(ctx, req.AgentJobID, req.AgentCreator, req.Node, *req.Definition, req.State)
+}
+
+// PassthroughRunner returns the node's parameters with placeholders resolved.
+// It backs input and output nodes that need no external call.
+type PassthroughRunner struct{}
+
+func (PassthroughRunner) Run(ctx context.Context, req NodeRequest) (interface{}, error) {
+	params, err := injectInputsFromState(req.Node.Parameters, req.State)
+	if err != nil {
+		return nil, err
+	}
+	logger.Slog.Info("Parameters after dependency injection", "alias", req.Node.Alias, "injected_parameters", params)
+	return params, nil
+}
+
+func runConditionNode(ctx context.Context, req NodeRequest) (interface{}, error) {
+	return executeConditionNode(req.Node, req.State)
+}
+
+func runForeachNode(ctx context.Context, req NodeRequest) (interface{}, error) {
+	return executeForeachNode(ctx, req.AgentJobID, req.AgentCreator, req.Node, req.NodesLib, req.State)
+}
+
+func runUnknownBuiltinNode(ctx context.Context, req NodeRequest) (interface{}, error) {
+	return nil, fmt.Errorf("unknown built-in node type %s", req.Node.Type)
+}
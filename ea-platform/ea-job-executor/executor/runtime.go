@@ -0,0 +1,272 @@
+package executor
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ea-job-executor/logger"
+)
+
+//--------------------- Runtime Backends ---------------------//
+
+// The executor talks to three external systems: the agent manager for node
+// definitions, Kubernetes Secrets for user credentials and Kubernetes Events
+// for node status updates. Each is behind an interface so a job can run either
+// in-cluster (the default) or entirely on a laptop.
+
+// NodeSource loads the node definitions a job can reference.
+type NodeSource interface {
+	LoadNodes() (NodesLibrary, error)
+}
+
+// SecretSource returns the third-party credentials of an agent creator.
+type SecretSource interface {
+	UserSecrets(agentCreator string) (map[string]string, error)
+}
+
+// EventSink receives node status updates (Completed, Failed, Skipped, ...).
+type EventSink interface {
+	Emit(agentJobID, nodeAlias, status, output string)
+}
+
+// CheckpointSink is an EventSink that also records the raw result of completed
+// nodes, so a restarted job restores them unchanged.
+type CheckpointSink interface {
+	EmitCompleted(agentJobID, nodeAlias, output, result string)
+}
+
+// Runtime bundles the backends and switches used while executing a job.
+type Runtime struct {
+	Nodes            NodeSource
+	Secrets          SecretSource
+	Events           EventSink
+	Resume           bool   // Restore completed nodes from the AgentJob status
+	DryRun           bool   // Resolve API requests without sending them
+	BlobDir          string // Directory binary API responses are stored in
+	OutputValidation string // Default output validation mode: strict, warn or off
+
+	Inputs map[string]interface{} // Job inputs that override those in the job file
+}
+
+// InClusterRuntime returns the runtime used when the executor runs as a
+// Kubernetes Job: agent manager nodes, Kubernetes Secrets and Events.
+func InClusterRuntime(agentManagerURL string, blobDir string) Runtime {
+	return Runtime{
+		Nodes:   AgentManagerNodeSource{URL: agentManagerURL},
+		Secrets: KubernetesSecretSource{},
+		Events:  KubernetesEventSink{},
+		Resume:  true,
+		BlobDir: blobDir,
+	}
+}
+
+// env is the runtime of the job being executed.
+var env = Runtime{
+	Secrets: KubernetesSecretSource{},
+	Events:  KubernetesEventSink{},
+}
+
+// emitNodeStatus reports a node status update through the active event sink.
+func emitNodeStatus(agentJobID, nodeAlias, status, output string) {
+	env.Events.Emit(agentJobID, nodeAlias, status, output)
+}
+
+// emitNodeCompleted reports a completed node with its flattened output and,
+// when the sink records checkpoints, its raw result.
+func emitNodeCompleted(agentJobID, nodeAlias, output, result string) {
+	if sink, ok := env.Events.(CheckpointSink); ok {
+		sink.EmitCompleted(agentJobID, nodeAlias, output, result)
+		return
+	}
+	env.Events.Emit(agentJobID, nodeAlias, "Completed", output)
+}
+
+//--------------------- Node Sources ---------------------//
+
+// AgentManagerNodeSource fetches node definitions from the agent manager API.
+type AgentManagerNodeSource struct {
+	URL string
+}
+
+func (s AgentManagerNodeSource) LoadNodes() (NodesLibrary, error) {
+	return loadNodesLibrary(s.URL)
+}
+
+// DirNodeSource reads node definitions from the *.json files of a directory,
+// in the format of the agent manager's node-presets.
+type DirNodeSource struct {
+	Dir string
+}
+
+func (s DirNodeSource) LoadNodes() (NodesLibrary, error) {
+	files, err := filepath.Glob(filepath.Join(s.Dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	var nodesLib NodesLibrary
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		var nodeDef NodeDefinition
+		if err := json.Unmarshal(data, &nodeDef); err != nil {
+			return nil, fmt.Errorf("invalid node definition %s: %w", file, err)
+		}
+
+		// Presets use "base_url" while definitions stored by the agent manager use "baseurl"
+		var preset struct {
+			API struct {
+				BaseURL string `json:"base_url"`
+			} `json:"api"`
+		}
+		if err := json.Unmarshal(data, &preset); err == nil && nodeDef.API.BaseURL == "" {
+			nodeDef.API.BaseURL = preset.API.BaseURL
+		}
+
+		if nodeDef.Type == "" {
+			return nil, fmt.Errorf("node definition %s is missing a type", file)
+		}
+
+		// Local definitions can be used by any agent
+		nodeDef.Visibility = "public"
+
+		logger.Slog.Info("Loaded local node definition", "file", file, "nodeType", nodeDef.Type)
+		nodesLib = append(nodesLib, nodeDef)
+	}
+
+	if len(nodesLib) == 0 {
+		return nil, fmt.Errorf("no node definitions found in %s", s.Dir)
+	}
+
+	return nodesLib, nil
+}
+
+// visibleNodeDefinitions returns the definitions an agent creator may use: their
+// own, public ones and those shared with one of their orgs.
+func visibleNodeDefinitions(nodesLib NodesLibrary, creator string, groups []string) NodesLibrary {
+	var visible NodesLibrary
+	for _, def := range nodesLib {
+		switch {
+		case def.Creator == creator, def.Visibility == "public":
+			visible = append(visible, def)
+		case def.Visibility == "org" && slices.Contains(groups, def.Org):
+			visible = append(visible, def)
+		default:
+			logger.Slog.Info("Node definition not visible to the agent creator", "nodeType", def.Type, "version", def.Version, "creator", creator)
+		}
+	}
+	return visible
+}
+
+//--------------------- Secret Sources ---------------------//
+
+// KubernetesSecretSource reads the creator's third-party-user-creds Secret.
+type KubernetesSecretSource struct{}
+
+func (KubernetesSecretSource) UserSecrets(agentCreator string) (map[string]string, error) {
+	return fetchUserSecret(agentCreator)
+}
+
+// FileSecretSource reads credentials from a KEY=VALUE env file. The same
+// secrets are returned for every creator.
+type FileSecretSource struct {
+	Path string
+}
+
+func (s FileSecretSource) UserSecrets(agentCreator string) (map[string]string, error) {
+	if s.Path == "" {
+		return nil, fmt.Errorf("no secrets file configured for user %s", agentCreator)
+	}
+	file, err := os.Open(s.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open secrets file: %w", err)
+	}
+	defer file.Close()
+
+	secrets := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", s.Path, lineNumber)
+		}
+		value = strings.TrimSpace(value)
+		if len(value) >= 2 && (value[0] == '"' || value[0] == '\'') && value[len(value)-1] == value[0] {
+			value = value[1 : len(value)-1]
+		}
+		secrets[strings.TrimSpace(key)] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read secrets file: %w", err)
+	}
+
+	return secrets, nil
+}
+
+//--------------------- Event Sinks ---------------------//
+
+// KubernetesEventSink creates a NodeStatusUpdate Event on the AgentJob.
+type KubernetesEventSink struct{}
+
+func (KubernetesEventSink) Emit(agentJobID, nodeAlias, status, output string) {
+	emitK8sEvent(agentJobID, nodeAlias, status, output, "")
+}
+
+func (KubernetesEventSink) EmitCompleted(agentJobID, nodeAlias, output, result string) {
+	emitK8sEvent(agentJobID, nodeAlias, "Completed", output, result)
+}
+
+// WriterEventSink writes each status update as a JSON line.
+type WriterEventSink struct {
+	W    io.Writer
+	lock sync.Mutex
+}
+
+// NewStdoutEventSink returns a sink that prints status updates to stdout.
+func NewStdoutEventSink() *WriterEventSink {
+	return &WriterEventSink{W: os.Stdout}
+}
+
+func (s *WriterEventSink) Emit(agentJobID, nodeAlias, status, output string) {
+	line, err := json.Marshal(map[string]interface{}{
+		"time":       time.Now().Format(time.RFC3339),
+		"agentJobID": agentJobID,
+		"nodeAlias":  nodeAlias,
+		"status":     status,
+		"output":     json.RawMessage(output),
+	})
+	if err != nil {
+		logger.Slog.Error("Failed to marshal node status event", "node", nodeAlias, "error", err)
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	fmt.Fprintln(s.W, string(line))
+}
+
+// DiscardEventSink drops all status updates.
+type DiscardEventSink struct{}
+
+func (DiscardEventSink) Emit(agentJobID, nodeAlias, status, output string) {}
@@ -0,0 +1,242 @@
+package executor
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"ea-job-executor/logger"
+)
+
+//--------------------- Node Versions ---------------------//
+
+// defaultNodeVersion is assigned to node definitions published without a version.
+const defaultNodeVersion = "1.0.0"
+
+// semver is a MAJOR.MINOR.PATCH version.
+type semver struct {
+	Major, Minor, Patch int
+}
+
+func (v semver) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+func (v semver) compare(o semver) int {
+	switch {
+	case v.Major != o.Major:
+		return sign(v.Major - o.Major)
+	case v.Minor != o.Minor:
+		return sign(v.Minor - o.Minor)
+	default:
+		return sign(v.Patch - o.Patch)
+	}
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// parseVersion parses "1.2.3" (an optional leading "v" is allowed). It also
+// returns how many components were given, so "1.2" can act as a range.
+func parseVersion(s string) (semver, int, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if s == "" {
+		return semver{}, 0, fmt.Errorf("empty version")
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return semver{}, 0, fmt.Errorf("invalid version %q", s)
+	}
+	var nums [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return semver{}, 0, fmt.Errorf("invalid version %q", s)
+		}
+		nums[i] = n
+	}
+	return semver{nums[0], nums[1], nums[2]}, len(parts), nil
+}
+
+// parseFullVersion parses a complete MAJOR.MINOR.PATCH version.
+func parseFullVersion(s string) (semver, error) {
+	v, parts, err := parseVersion(s)
+	if err != nil {
+		return semver{}, err
+	}
+	if parts != 3 {
+		return semver{}, fmt.Errorf("version %q must have the form MAJOR.MINOR.PATCH", s)
+	}
+	return v, nil
+}
+
+// versionBound is one comparison of a constraint, e.g. ">=1.2.0".
+type versionBound struct {
+	op string
+	v  semver
+}
+
+// versionConstraint is a conjunction of bounds; an empty constraint matches everything.
+type versionConstraint []versionBound
+
+// parseConstraint parses constraints such as "^1.2", "~1.2.3", ">=1.0 <2",
+// "1.4.2", "1.x" or "*". Bounds separated by spaces or commas must all hold.
+func parseConstraint(s string) (versionConstraint, error) {
+	var c versionConstraint
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool { return r == ' ' || r == ',' }) {
+		if field == "*" || field == "latest" {
+			continue
+		}
+
+		op := ""
+		for _, candidate := range []string{">=", "<=", ">", "<", "=", "^", "~"} {
+			if strings.HasPrefix(field, candidate) {
+				op = candidate
+				break
+			}
+		}
+		text := strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(field, op), ".x"), ".*")
+		if text == "x" {
+			continue
+		}
+		v, parts, err := parseVersion(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid version constraint %q: %w", s, err)
+		}
+
+		// Upper bound of a partial version: "1" -> <2.0.0, "1.2" -> <1.3.0
+		next := func(parts int) semver {
+			if parts <= 1 {
+				return semver{v.Major + 1, 0, 0}
+			}
+			if parts == 2 {
+				return semver{v.Major, v.Minor + 1, 0}
+			}
+			return semver{v.Major, v.Minor, v.Patch + 1}
+		}
+
+		switch op {
+		case "^":
+			upper := semver{v.Major + 1, 0, 0}
+			if v.Major == 0 && parts >= 2 {
+				upper = semver{0, v.Minor + 1, 0}
+				if v.Minor == 0 && parts == 3 {
+					upper = semver{0, 0, v.Patch + 1}
+				}
+			}
+			c = append(c, versionBound{">=", v}, versionBound{"<", upper})
+		case "~":
+			c = append(c, versionBound{">=", v}, versionBound{"<", next(min(parts, 2))})
+		case "", "=":
+			c = append(c, versionBound{">=", v}, versionBound{"<", next(parts)})
+		default:
+			c = append(c, versionBound{op, v})
+		}
+	}
+	return c, nil
+}
+
+// matches reports whether v satisfies every bound of the constraint.
+func (c versionConstraint) matches(v semver) bool {
+	for _, bound := range c {
+		cmp := v.compare(bound.v)
+		var ok bool
+		switch bound.op {
+		case ">=":
+			ok = cmp >= 0
+		case ">":
+			ok = cmp > 0
+		case "<=":
+			ok = cmp <= 0
+		case "<":
+			ok = cmp < 0
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// splitNodeType splits "worker.inference.llm.ollama@^1.2" into the type and the constraint.
+func splitNodeType(nodeType string) (string, string) {
+	base, constraint, _ := strings.Cut(nodeType, "@")
+	return base, strings.TrimSpace(constraint)
+}
+
+// findNodeDefinition returns the definition for a node type. A type may carry a
+// version constraint ("worker.inference.llm.ollama@^1.2"); the highest published
+// version that satisfies it is returned. Definitions without a version count as 1.0.0.
+func findNodeDefinition(nodeType string, nodesLib []NodeDefinition) (NodeDefinition, error) {
+	baseType, constraintText := splitNodeType(nodeType)
+	constraint, err := parseConstraint(constraintText)
+	if err != nil {
+		return NodeDefinition{}, err
+	}
+
+	var best NodeDefinition
+	var bestVersion semver
+	found, typeFound := false, false
+	for _, def := range nodesLib {
+		if def.Type != baseType {
+			continue
+		}
+		typeFound = true
+		version := def.Version
+		if version == "" {
+			version = defaultNodeVersion
+		}
+		v, err := parseFullVersion(version)
+		if err != nil || !constraint.matches(v) {
+			continue
+		}
+		if !found || v.compare(bestVersion) > 0 {
+			best, bestVersion, found = def, v, true
+		}
+	}
+	if !typeFound {
+		return NodeDefinition{}, errors.New("node definition not found")
+	}
+	if !found {
+		return NodeDefinition{}, fmt.Errorf("no version of %s satisfies %q", baseType, constraintText)
+	}
+	if best.Version == "" {
+		best.Version = defaultNodeVersion
+	}
+	return best, nil
+}
+
+// resolveNodeVersions checks before execution starts that every versioned node
+// type, including those in foreach graphs, resolves to a published definition.
+func resolveNodeVersions(nodes []NodeInstance, nodesLib []NodeDefinition) error {
+	for _, node := range nodes {
+		if node.Type == foreachNodeType {
+			if spec, err := parseForeachSpec(node); err == nil {
+				if err := resolveNodeVersions(spec.Nodes, nodesLib); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		baseType, constraint := splitNodeType(node.Type)
+		def, err := findNodeDefinition(node.Type, nodesLib)
+		if err != nil {
+			if constraint != "" {
+				return fmt.Errorf("node %s: %w", node.Alias, err)
+			}
+			// Built-in and registered nodes need no definition
+			continue
+		}
+		logger.Slog.Info("Resolved node version", "alias", node.Alias, "type", baseType, "constraint", constraint, "version", def.Version)
+	}
+	return nil
+}
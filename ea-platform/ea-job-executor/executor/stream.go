@@ -0,0 +1,165 @@
+package executor
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"ea-job-executor/logger"
+)
+
+//--------------------- Streaming Responses ---------------------//
+
+// Streaming inference APIs answer with newline-delimited JSON (Ollama) or
+// server-sent events (OpenAI-style). The executor reads the stream chunk by
+// chunk, accumulates the generated text, publishes throttled "Running" status
+// events with the partial text and returns the combined result once the stream
+// ends, so downstream placeholders see a single object as with plain JSON.
+
+const (
+	streamFormatNDJSON = "ndjson"
+	streamFormatSSE    = "sse"
+)
+
+// streamEventInterval throttles partial output events per node.
+const streamEventInterval = time.Second
+
+// defaultStreamTextPaths are tried in order to find the text delta in a chunk.
+var defaultStreamTextPaths = []string{
+	"response",                 // Ollama /api/generate
+	"message.content",          // Ollama /api/chat
+	"choices[0].delta.content", // OpenAI chat completions
+	"choices[0].text",          // OpenAI completions
+	"delta.text",               // Anthropic content_block_delta
+}
+
+// streamFormat returns the streaming format of a response, or "" when the
+// response is a regular body. The content type decides unless the definition
+// sets metadata.additional.stream_format.
+func streamFormat(resp *http.Response, def NodeDefinition) string {
+	if format, ok := def.Metadata.Additional["stream_format"].(string); ok && format != "" {
+		return format
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	switch mediaType {
+	case "application/x-ndjson", "application/ndjson", "application/jsonl", "application/x-jsonlines":
+		return streamFormatNDJSON
+	case "text/event-stream":
+		return streamFormatSSE
+	}
+	return ""
+}
+
+// readStream consumes a streaming response body and returns the last chunk
+// merged over the earlier ones, with the accumulated text written back at the
+// text path (or under "text" when the path contains an index).
+func readStream(agentJobID string, eventAlias string, format string, body io.Reader, def NodeDefinition) (map[string]interface{}, error) {
+	textPaths := defaultStreamTextPaths
+	if path, ok := def.Metadata.Additional["stream_text_path"].(string); ok && path != "" {
+		textPaths = []string{path}
+	}
+
+	result := make(map[string]interface{})
+	var text strings.Builder
+	textPath := ""
+	chunks := 0
+	lastEmit := time.Now()
+
+	handleChunk := func(data string) error {
+		data = strings.TrimSpace(data)
+		if data == "" || data == "[DONE]" {
+			return nil
+		}
+		chunks++
+
+		var chunk map[string]interface{}
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			// Plain text chunks are appended as-is
+			text.WriteString(data)
+		} else {
+			for _, path := range textPaths {
+				if delta, ok := lookupStreamText(chunk, path); ok {
+					text.WriteString(delta)
+					textPath = path
+					break
+				}
+			}
+			deepMerge(result, chunk)
+
+			if errMsg, ok := chunk["error"]; ok {
+				return &permanentError{fmt.Errorf("stream returned error: %v", errMsg)}
+			}
+		}
+
+		// Publish the partial output, at most once per interval
+		if time.Since(lastEmit) >= streamEventInterval {
+			lastEmit = time.Now()
+			partialJSON, _ := json.Marshal(map[string]interface{}{
+				eventAlias + ".partial": text.String(),
+				eventAlias + ".chunks":  chunks,
+			})
+			emitNodeStatus(agentJobID, eventAlias, "Running", string(partialJSON))
+		}
+		return nil
+	}
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	var event strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		if format == streamFormatNDJSON {
+			if err := handleChunk(line); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		// SSE: data lines accumulate until a blank line ends the event
+		switch {
+		case line == "":
+			if err := handleChunk(event.String()); err != nil {
+				return nil, err
+			}
+			event.Reset()
+		case strings.HasPrefix(line, "data:"):
+			if event.Len() > 0 {
+				event.WriteString("\n")
+			}
+			event.WriteString(strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s stream: %w", format, err)
+	}
+	if err := handleChunk(event.String()); err != nil {
+		return nil, err
+	}
+
+	if chunks == 0 {
+		return nil, &permanentError{fmt.Errorf("%s stream ended without data", format)}
+	}
+
+	if textPath == "" || strings.Contains(textPath, "[") {
+		result["text"] = text.String()
+	} else {
+		setPath(result, textPath, text.String())
+	}
+
+	logger.Slog.Info("Stream completed", "alias", eventAlias, "format", format, "chunks", chunks, "text_length", text.Len())
+	return result, nil
+}
+
+// lookupStreamText reads a string at a reference path such as "choices[0].delta.content".
+func lookupStreamText(chunk map[string]interface{}, path string) (string, bool) {
+	value, _ := lookupResultPath(chunk, path)
+	s, ok := value.(string)
+	return s, ok
+}
@@ -0,0 +1,655 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"ea-job-executor/logger"
+)
+
+//--------------------- Transform Nodes ---------------------//
+
+// Transform nodes reshape data between nodes in-process, without an external call.
+const (
+	transformTemplateNodeType = "transform.template" // Render a Go text/template
+	transformJSONPathNodeType = "transform.jsonpath" // Extract values with a JSONPath expression
+	transformMapNodeType      = "transform.map"      // Build an object from merged inputs and mapped fields
+	transformCoerceNodeType   = "transform.coerce"   // Coerce a value to a JSON schema
+)
+
+// transformInput resolves a required input parameter. Inputs must be given
+// explicitly (usually as a {{alias.path}} reference) so the node's dependencies
+// are known when the graph is built.
+func transformInput(node NodeInstance, key string, state *ExecutionState) (interface{}, error) {
+	raw, ok := node.Parameters[key]
+	if !ok {
+		return nil, fmt.Errorf("%s node %s is missing a '%s' parameter", node.Type, node.Alias, key)
+	}
+	value, err := resolveParameterValue(raw, state)
+	if err != nil {
+		return nil, fmt.Errorf("%s node %s: invalid '%s' parameter: %w", node.Type, node.Alias, key, err)
+	}
+	return value, nil
+}
+
+// wrapValue returns objects unchanged and wraps any other value as {"value": v}
+// so it can be referenced as {{alias.value}}.
+func wrapValue(value interface{}) map[string]interface{} {
+	if obj, ok := value.(map[string]interface{}); ok {
+		return obj
+	}
+	return map[string]interface{}{"value": value}
+}
+
+//--------------------- transform.template ---------------------//
+
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"upper": strings.ToUpper,
+	"lower": strings.ToLower,
+	"trim":  strings.TrimSpace,
+	"join": func(sep string, items []interface{}) string {
+		parts := make([]string, len(items))
+		for i, item := range items {
+			parts[i] = fmt.Sprintf("%v", item)
+		}
+		return strings.Join(parts, sep)
+	},
+	"default": func(fallback, v interface{}) interface{} {
+		if v == nil || v == "" {
+			return fallback
+		}
+		return v
+	},
+}
+
+// runTemplateNode renders the "template" parameter with Go text/template against
+// "data". It returns {"text": rendered}, plus {"value": parsed} when "format" is "json".
+func runTemplateNode(ctx context.Context, req NodeRequest) (interface{}, error) {
+	node := req.Node
+	text, ok := node.Parameters["template"].(string)
+	if !ok {
+		return nil, fmt.Errorf("template node %s is missing a 'template' parameter", node.Alias)
+	}
+
+	var data interface{}
+	if _, ok := node.Parameters["data"]; ok {
+		resolved, err := transformInput(node, "data", req.State)
+		if err != nil {
+			return nil, err
+		}
+		data = resolved
+	}
+
+	tmpl, err := template.New(node.Alias).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("template node %s: invalid template: %w", node.Alias, err)
+	}
+
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, data); err != nil {
+		return nil, fmt.Errorf("template node %s: %w", node.Alias, err)
+	}
+
+	result := map[string]interface{}{"text": rendered.String()}
+	if format, _ := node.Parameters["format"].(string); format == "json" {
+		var value interface{}
+		if err := json.Unmarshal(rendered.Bytes(), &value); err != nil {
+			return nil, fmt.Errorf("template node %s: rendered output is not valid JSON: %w", node.Alias, err)
+		}
+		result["value"] = value
+	}
+
+	logger.Slog.Info("Template rendered", "alias", node.Alias, "length", rendered.Len())
+	return result, nil
+}
+
+//--------------------- transform.jsonpath ---------------------//
+
+// runJSONPathNode evaluates "path" (or each entry of "paths") against "input".
+// A single path returns {"value": v}; "paths" returns an object with one key
+// per entry. Missing values fall back to "default" or fail the node.
+func runJSONPathNode(ctx context.Context, req NodeRequest) (interface{}, error) {
+	node := req.Node
+	input, err := transformInput(node, "input", req.State)
+	if err != nil {
+		return nil, err
+	}
+	fallback, hasFallback := node.Parameters["default"]
+
+	evaluate := func(path string) (interface{}, error) {
+		value, found, err := evaluateJSONPath(path, input)
+		if err != nil {
+			return nil, fmt.Errorf("jsonpath node %s: %w", node.Alias, err)
+		}
+		if !found {
+			if hasFallback {
+				return fallback, nil
+			}
+			return nil, fmt.Errorf("jsonpath node %s: no value at %s", node.Alias, path)
+		}
+		return value, nil
+	}
+
+	if paths, ok := node.Parameters["paths"].(map[string]interface{}); ok {
+		result := make(map[string]interface{}, len(paths))
+		for key, p := range paths {
+			path, ok := p.(string)
+			if !ok {
+				return nil, fmt.Errorf("jsonpath node %s: path for %s must be a string", node.Alias, key)
+			}
+			value, err := evaluate(path)
+			if err != nil {
+				return nil, err
+			}
+			result[key] = value
+		}
+		return result, nil
+	}
+
+	path, ok := node.Parameters["path"].(string)
+	if !ok {
+		return nil, fmt.Errorf("jsonpath node %s is missing a 'path' or 'paths' parameter", node.Alias)
+	}
+	value, err := evaluate(path)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{"value": value}, nil
+}
+
+type jsonPathStep struct {
+	key       string // Object key; "*" with wildcard
+	index     int    // Array index (negative counts from the end)
+	isIndex   bool
+	wildcard  bool
+	recursive bool // ..key: match key at any depth
+}
+
+// evaluateJSONPath supports $, .key, ['key'], [n], [-n], .* / [*] and ..key.
+// Paths with a wildcard or recursive step return an array of all matches.
+func evaluateJSONPath(path string, input interface{}) (interface{}, bool, error) {
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	multi := false
+	current := []interface{}{input}
+	for _, step := range steps {
+		var next []interface{}
+		if step.wildcard || step.recursive {
+			multi = true
+		}
+		for _, value := range current {
+			if step.recursive {
+				collectRecursive(value, step.key, &next)
+				continue
+			}
+			next = append(next, applyJSONPathStep(step, value)...)
+		}
+		current = next
+	}
+
+	if multi {
+		if current == nil {
+			current = []interface{}{}
+		}
+		return current, true, nil
+	}
+	if len(current) == 0 {
+		return nil, false, nil
+	}
+	return current[0], true, nil
+}
+
+func applyJSONPathStep(step jsonPathStep, value interface{}) []interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if step.wildcard {
+			keys := make([]string, 0, len(v))
+			for key := range v {
+				keys = append(keys, key)
+			}
+			sort.Strings(keys)
+			values := make([]interface{}, len(keys))
+			for i, key := range keys {
+				values[i] = v[key]
+			}
+			return values
+		}
+		if step.isIndex {
+			return nil
+		}
+		if item, ok := v[step.key]; ok {
+			return []interface{}{item}
+		}
+	case []interface{}:
+		if step.wildcard {
+			return v
+		}
+		if step.isIndex {
+			index := step.index
+			if index < 0 {
+				index += len(v)
+			}
+			if index >= 0 && index < len(v) {
+				return []interface{}{v[index]}
+			}
+		}
+	}
+	return nil
+}
+
+func collectRecursive(value interface{}, key string, out *[]interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if k == key || key == "*" {
+				*out = append(*out, v[k])
+			}
+			collectRecursive(v[k], key, out)
+		}
+	case []interface{}:
+		for _, item := range v {
+			collectRecursive(item, key, out)
+		}
+	}
+}
+
+func parseJSONPath(path string) ([]jsonPathStep, error) {
+	path = strings.TrimSpace(path)
+	path = strings.TrimPrefix(path, "$")
+
+	var steps []jsonPathStep
+	i := 0
+	for i < len(path) {
+		switch {
+		case strings.HasPrefix(path[i:], ".."):
+			i += 2
+			key, n := readJSONPathKey(path[i:])
+			if key == "" {
+				return nil, fmt.Errorf("invalid path %q: expected key after '..'", path)
+			}
+			steps = append(steps, jsonPathStep{key: key, recursive: true})
+			i += n
+		case path[i] == '.':
+			i++
+			key, n := readJSONPathKey(path[i:])
+			if key == "" {
+				return nil, fmt.Errorf("invalid path %q: expected key after '.'", path)
+			}
+			steps = append(steps, jsonPathStep{key: key, wildcard: key == "*"})
+			i += n
+		case path[i] == '[':
+			end := strings.Index(path[i:], "]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path %q: unterminated '['", path)
+			}
+			inner := strings.TrimSpace(path[i+1 : i+end])
+			i += end + 1
+			switch {
+			case inner == "*":
+				steps = append(steps, jsonPathStep{key: "*", wildcard: true})
+			case len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0]:
+				steps = append(steps, jsonPathStep{key: inner[1 : len(inner)-1]})
+			default:
+				index, err := strconv.Atoi(inner)
+				if err != nil {
+					return nil, fmt.Errorf("invalid path %q: bad index %q", path, inner)
+				}
+				steps = append(steps, jsonPathStep{index: index, isIndex: true})
+			}
+		default:
+			// Allow a leading bare key, e.g. "classifier.response"
+			key, n := readJSONPathKey(path[i:])
+			if key == "" {
+				return nil, fmt.Errorf("invalid path %q at offset %d", path, i)
+			}
+			steps = append(steps, jsonPathStep{key: key, wildcard: key == "*"})
+			i += n
+		}
+	}
+	return steps, nil
+}
+
+func readJSONPathKey(s string) (string, int) {
+	n := 0
+	for n < len(s) && s[n] != '.' && s[n] != '[' {
+		n++
+	}
+	return s[:n], n
+}
+
+//--------------------- transform.map ---------------------//
+
+// runMapNode deep-merges the objects in "merge" (in order), then sets each entry
+// of "fields" (dotted keys create nested objects) and removes the keys in "omit".
+func runMapNode(ctx context.Context, req NodeRequest) (interface{}, error) {
+	node := req.Node
+	result := make(map[string]interface{})
+
+	if raw, ok := node.Parameters["merge"]; ok {
+		sources, err := resolveParameterValue(raw, req.State)
+		if err != nil {
+			return nil, fmt.Errorf("map node %s: invalid 'merge' parameter: %w", node.Alias, err)
+		}
+		list, ok := sources.([]interface{})
+		if !ok {
+			list = []interface{}{sources}
+		}
+		for i, source := range list {
+			obj, ok := source.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("map node %s: merge entry %d is %T, expected an object", node.Alias, i, source)
+			}
+			deepMerge(result, obj)
+		}
+	}
+
+	if raw, ok := node.Parameters["fields"]; ok {
+		fields, ok := raw.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("map node %s: 'fields' must be an object", node.Alias)
+		}
+		keys := make([]string, 0, len(fields))
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			value, err := resolveParameterValue(fields[key], req.State)
+			if err != nil {
+				return nil, fmt.Errorf("map node %s: field %s: %w", node.Alias, key, err)
+			}
+			setPath(result, key, value)
+		}
+	}
+
+	if raw, ok := node.Parameters["omit"]; ok {
+		omit, err := aliasList(raw)
+		if err != nil {
+			return nil, fmt.Errorf("map node %s: invalid 'omit' parameter: %w", node.Alias, err)
+		}
+		for _, key := range omit {
+			deletePath(result, key)
+		}
+	}
+
+	return result, nil
+}
+
+// deepMerge copies src into dst, merging nested objects instead of replacing them.
+func deepMerge(dst, src map[string]interface{}) {
+	for key, value := range src {
+		srcObj, srcIsObj := value.(map[string]interface{})
+		dstObj, dstIsObj := dst[key].(map[string]interface{})
+		if srcIsObj && dstIsObj {
+			deepMerge(dstObj, srcObj)
+			continue
+		}
+		if srcIsObj {
+			copied := make(map[string]interface{}, len(srcObj))
+			deepMerge(copied, srcObj)
+			dst[key] = copied
+			continue
+		}
+		dst[key] = value
+	}
+}
+
+func setPath(obj map[string]interface{}, path string, value interface{}) {
+	parts := strings.Split(path, ".")
+	current := obj
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := current[part].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			current[part] = next
+		}
+		current = next
+	}
+	current[parts[len(parts)-1]] = value
+}
+
+func deletePath(obj map[string]interface{}, path string) {
+	parts := strings.Split(path, ".")
+	current := obj
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := current[part].(map[string]interface{})
+		if !ok {
+			return
+		}
+		current = next
+	}
+	delete(current, parts[len(parts)-1])
+}
+
+//--------------------- transform.coerce ---------------------//
+
+// runCoerceNode converts "input" to match "schema", a JSON schema subset
+// (type, properties, required, default, items, enum, additionalProperties).
+// Object results are returned as-is; other values as {"value": v}.
+func runCoerceNode(ctx context.Context, req NodeRequest) (interface{}, error) {
+	node := req.Node
+	schema, ok := node.Parameters["schema"].(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("coerce node %s is missing a 'schema' object", node.Alias)
+	}
+
+	input, err := transformInput(node, "input", req.State)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := coerceToSchema(input, schema, "$")
+	if err != nil {
+		return nil, fmt.Errorf("coerce node %s: %w", node.Alias, err)
+	}
+	return wrapValue(value), nil
+}
+
+func coerceToSchema(value interface{}, schema map[string]interface{}, path string) (interface{}, error) {
+	if value == nil {
+		if def, ok := schema["default"]; ok {
+			value = def
+		}
+	}
+
+	var types []string
+	switch t := schema["type"].(type) {
+	case string:
+		types = []string{t}
+	case []interface{}:
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				types = append(types, s)
+			}
+		}
+	}
+
+	var coerced interface{}
+	if len(types) == 0 {
+		coerced = value
+	} else {
+		var lastErr error
+		for _, t := range types {
+			c, err := coerceToType(value, t, schema, path)
+			if err == nil {
+				coerced, lastErr = c, nil
+				break
+			}
+			lastErr = err
+		}
+		if lastErr != nil {
+			return nil, lastErr
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		allowed := false
+		for _, option := range enum {
+			if valuesEqual(coerced, option) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return nil, fmt.Errorf("%s: value %v is not one of %v", path, coerced, enum)
+		}
+	}
+
+	return coerced, nil
+}
+
+func coerceToType(value interface{}, typ string, schema map[string]interface{}, path string) (interface{}, error) {
+	switch typ {
+	case "null":
+		if value == nil {
+			return nil, nil
+		}
+	case "string":
+		switch v := value.(type) {
+		case string:
+			return v, nil
+		case float64:
+			return strconv.FormatFloat(v, 'f', -1, 64), nil
+		case bool:
+			return strconv.FormatBool(v), nil
+		case map[string]interface{}, []interface{}:
+			b, err := json.Marshal(v)
+			return string(b), err
+		}
+	case "number":
+		if f, ok := toFloat(value); ok {
+			return f, nil
+		}
+	case "integer":
+		if f, ok := toFloat(value); ok && f == float64(int64(f)) {
+			return int64(f), nil
+		}
+	case "boolean":
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			switch strings.ToLower(strings.TrimSpace(v)) {
+			case "true", "yes", "1":
+				return true, nil
+			case "false", "no", "0":
+				return false, nil
+			}
+		case float64:
+			if v == 0 || v == 1 {
+				return v == 1, nil
+			}
+		}
+	case "array":
+		return coerceArray(value, schema, path)
+	case "object":
+		return coerceObject(value, schema, path)
+	default:
+		return nil, fmt.Errorf("%s: unsupported schema type %q", path, typ)
+	}
+	return nil, fmt.Errorf("%s: cannot coerce %T to %s", path, value, typ)
+}
+
+func coerceArray(value interface{}, schema map[string]interface{}, path string) (interface{}, error) {
+	var items []interface{}
+	switch v := value.(type) {
+	case []interface{}:
+		items = v
+	case nil:
+		return nil, fmt.Errorf("%s: cannot coerce null to array", path)
+	case string:
+		// Accept a JSON-encoded array, otherwise wrap the single value
+		if err := json.Unmarshal([]byte(v), &items); err != nil {
+			items = []interface{}{v}
+		}
+	default:
+		items = []interface{}{v}
+	}
+
+	itemSchema, _ := schema["items"].(map[string]interface{})
+	coerced := make([]interface{}, len(items))
+	for i, item := range items {
+		if itemSchema == nil {
+			coerced[i] = item
+			continue
+		}
+		c, err := coerceToSchema(item, itemSchema, fmt.Sprintf("%s[%d]", path, i))
+		if err != nil {
+			return nil, err
+		}
+		coerced[i] = c
+	}
+	return coerced, nil
+}
+
+func coerceObject(value interface{}, schema map[string]interface{}, path string) (interface{}, error) {
+	obj, ok := value.(map[string]interface{})
+	if s, isString := value.(string); isString {
+		if err := json.Unmarshal([]byte(s), &obj); err == nil {
+			ok = true
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("%s: cannot coerce %T to object", path, value)
+	}
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	required, _ := aliasList(schema["required"])
+	allowExtra := true
+	if additional, ok := schema["additionalProperties"].(bool); ok {
+		allowExtra = additional
+	}
+
+	coerced := make(map[string]interface{})
+	for key, item := range obj {
+		propSchema, declared := properties[key].(map[string]interface{})
+		if !declared {
+			if allowExtra {
+				coerced[key] = item
+			}
+			continue
+		}
+		c, err := coerceToSchema(item, propSchema, path+"."+key)
+		if err != nil {
+			return nil, err
+		}
+		coerced[key] = c
+	}
+
+	// Fill in defaults for missing properties and enforce required ones
+	for key, p := range properties {
+		if _, present := coerced[key]; present {
+			continue
+		}
+		if propSchema, ok := p.(map[string]interface{}); ok {
+			if def, ok := propSchema["default"]; ok {
+				coerced[key] = def
+			}
+		}
+	}
+	for _, key := range required {
+		if _, present := coerced[key]; !present {
+			return nil, fmt.Errorf("%s: missing required property %s", path, key)
+		}
+	}
+
+	return coerced, nil
+}
@@ -1,11 +1,25 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"ea-job-executor/config"
 	"ea-job-executor/executor"
 	"ea-job-executor/logger"
 )
 
 func main() {
+	// `ea-job-executor run ...` executes a job locally; no arguments runs in-cluster
+	if len(os.Args) > 1 && os.Args[1] == "run" {
+		os.Exit(runCommand(os.Args[2:]))
+	}
+
 	// Set up the logger
 	logger.Slog.Info("Starting the application")
 
@@ -13,3 +27,90 @@ func main() {
 	executor.ExecuteAgentJob(filePath)
 
 }
+
+// runCommand implements `ea-job-executor run`, which executes an agent job
+// outside the cluster and prints the final output as JSON on stdout.
+func runCommand(args []string) int {
+	fs := flag.NewFlagSet("run", flag.ContinueOnError)
+	jobFile := fs.String("job", "agentjob.json", "Path to the agent job JSON file")
+	nodesDir := fs.String("nodes-dir", "", "Directory of node definition JSON files (default: fetch from the agent manager)")
+	secretsFile := fs.String("secrets", "", "KEY=VALUE env file with the credentials referenced by ((secret)) headers")
+	events := fs.String("events", "stdout", "Where node status events go: stdout, k8s or none")
+	dryRun := fs.Bool("dry-run", false, "Resolve API requests without sending them")
+	outputValidation := fs.String("output-validation", config.LoadConfig().OutputValidation, "Default node output validation: strict, warn or off")
+	inputs := inputFlags{}
+	fs.Var(inputs, "input", "Job input as KEY=VALUE; JSON values are decoded (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	// Keep stdout for events and the final output
+	logger.SetLogger(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+
+	rt := executor.Runtime{
+		Secrets:          executor.FileSecretSource{Path: *secretsFile},
+		DryRun:           *dryRun,
+		BlobDir:          config.LoadConfig().BlobDir,
+		OutputValidation: *outputValidation,
+		Inputs:           inputs,
+	}
+
+	if *nodesDir != "" {
+		rt.Nodes = executor.DirNodeSource{Dir: *nodesDir}
+	} else {
+		rt.Nodes = executor.AgentManagerNodeSource{URL: config.LoadConfig().AgentManagerUrl}
+	}
+
+	switch *events {
+	case "stdout":
+		rt.Events = executor.NewStdoutEventSink()
+	case "k8s":
+		rt.Events = executor.KubernetesEventSink{}
+	case "none":
+		rt.Events = executor.DiscardEventSink{}
+	default:
+		fmt.Fprintf(os.Stderr, "unknown --events value %q (expected stdout, k8s or none)\n", *events)
+		return 2
+	}
+
+	finalOutput, err := executor.Run(*jobFile, rt)
+	if errors.Is(err, executor.ErrJobCancelled) {
+		logger.Slog.Warn("Agent job cancelled")
+		return executor.ExitCodeCancelled
+	}
+	if err != nil {
+		logger.Slog.Error("Agent job execution failed", "error", err)
+		return 1
+	}
+
+	outputJSON, err := json.Marshal(map[string]interface{}{"output": finalOutput})
+	if err != nil {
+		logger.Slog.Error("Failed to marshal final output", "error", err)
+		return 1
+	}
+	fmt.Println(string(outputJSON))
+
+	return 0
+}
+
+// inputFlags collects repeated --input KEY=VALUE flags. Values that parse as
+// JSON (numbers, booleans, objects, quoted strings) are decoded; anything else
+// is a plain string.
+type inputFlags map[string]interface{}
+
+func (f inputFlags) String() string {
+	return fmt.Sprint(map[string]interface{}(f))
+}
+
+func (f inputFlags) Set(value string) error {
+	key, raw, ok := strings.Cut(value, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected KEY=VALUE, got %q", value)
+	}
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(raw), &decoded); err != nil {
+		decoded = raw
+	}
+	f[key] = decoded
+	return nil
+}
@@ -14,6 +14,9 @@ type Config struct {
 	FeatureCompletedJobs        string
 	FeatureCompletedAgentJobs   string
 	FeatureNodeStatusUpdates    string
+	FeatureCancelledAgentJobs   string
+	FeatureAgentSchedules       string
+	JobApiUrl                   string
 	CompletedCleanupGracePeriod int
 }
 
@@ -32,6 +35,9 @@ func LoadConfig() Config {
 		FeatureCompletedJobs:        getEnv("FEATURE_COMPLETED_JOBS", "true"),
 		FeatureCompletedAgentJobs:   getEnv("FEATURE_COMPLETED_AGENT_JOBS", "true"),
 		FeatureNodeStatusUpdates:    getEnv("FEATURE_NODE_STATUS_UPDATES", "true"),
+		FeatureCancelledAgentJobs:   getEnv("FEATURE_CANCELLED_AGENT_JOBS", "true"),
+		FeatureAgentSchedules:       getEnv("FEATURE_AGENT_SCHEDULES", "true"),
+		JobApiUrl:                   getEnv("JOB_API_URL", "http://ea-job-api:8080/api/v1/jobs"),
 		CompletedCleanupGracePeriod: gracePeriod,
 	}
 }
@@ -9,6 +9,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	_ "time/tzdata" // AgentSchedules may use any IANA time zone, whatever the image ships
 )
 
 func main() {
@@ -0,0 +1,72 @@
+package operator
+
+import (
+	"context"
+	"ea-job-operator/logger"
+	"fmt"
+
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/client-go/kubernetes"
+)
+
+// The executor resumes a restarted job from the node results in its AgentJob's
+// status, reading the AgentJob as the creator's service account. That account
+// is shared by all of the creator's jobs, so each job gets a Role that can only
+// read its own AgentJob. The Role and RoleBinding are owned by the AgentJob and
+// garbage collected with it.
+
+// checkpointRoleName returns the name of the Role that lets the executor of a job read its AgentJob.
+func checkpointRoleName(jobName string) string {
+	return fmt.Sprintf("%s-checkpoint", jobName)
+}
+
+// grantCheckpointAccess lets serviceAccount get the AgentJob job.
+func grantCheckpointAccess(clientset *kubernetes.Clientset, job *unstructured.Unstructured, serviceAccount string) error {
+	name := checkpointRoleName(job.GetName())
+	objectMeta := metav1.ObjectMeta{
+		Name:      name,
+		Namespace: namespace,
+		OwnerReferences: []metav1.OwnerReference{{
+			APIVersion: job.GetAPIVersion(),
+			Kind:       job.GetKind(),
+			Name:       job.GetName(),
+			UID:        job.GetUID(),
+		}},
+	}
+
+	role := &rbacv1.Role{
+		ObjectMeta: objectMeta,
+		Rules: []rbacv1.PolicyRule{{
+			APIGroups:     []string{agentJobGVR.Group},
+			Resources:     []string{agentJobGVR.Resource},
+			ResourceNames: []string{job.GetName()},
+			Verbs:         []string{"get"},
+		}},
+	}
+	if _, err := clientset.RbacV1().Roles(namespace).Create(context.TODO(), role, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create checkpoint Role: %w", err)
+	}
+
+	binding := &rbacv1.RoleBinding{
+		ObjectMeta: objectMeta,
+		Subjects: []rbacv1.Subject{{
+			Kind:      rbacv1.ServiceAccountKind,
+			Name:      serviceAccount,
+			Namespace: namespace,
+		}},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     name,
+		},
+	}
+	if _, err := clientset.RbacV1().RoleBindings(namespace).Create(context.TODO(), binding, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create checkpoint RoleBinding: %w", err)
+	}
+
+	logger.Slog.Info("Granted checkpoint access", "job", job.GetName(), "serviceAccount", serviceAccount)
+	return nil
+}
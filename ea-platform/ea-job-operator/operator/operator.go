@@ -22,6 +22,7 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/retry"
 	"k8s.io/client-go/util/workqueue"
 )
 
@@ -68,6 +69,16 @@ type NodeInstance struct {
 	Alias      string                 `json:"alias,omitempty"`
 	Type       string                 `json:"type"`
 	Parameters map[string]interface{} `json:"parameters,omitempty"`
+	Retry      *RetryPolicy           `json:"retry,omitempty"`
+}
+
+// RetryPolicy overrides the node definition's retry policy for a single node.
+type RetryPolicy struct {
+	MaxAttempts          int     `json:"max_attempts,omitempty" mapstructure:"max_attempts"`
+	InitialBackoff       string  `json:"initial_backoff,omitempty" mapstructure:"initial_backoff"`
+	MaxBackoff           string  `json:"max_backoff,omitempty" mapstructure:"max_backoff"`
+	Multiplier           float64 `json:"multiplier,omitempty" mapstructure:"multiplier"`
+	RetryableStatusCodes []int   `json:"retryable_status_codes,omitempty" mapstructure:"retryable_status_codes"`
 }
 
 // Edge represents a connection between nodes in an agent workflow.
@@ -92,6 +103,13 @@ type Agent struct {
 	Nodes       []NodeInstance `json:"nodes"`
 	Edges       []Edge         `json:"edges"`
 	Metadata    Metadata       `json:"metadata"`
+
+	TimeoutSeconds int `json:"timeout_seconds,omitempty" mapstructure:"timeoutSeconds"` // Job-level deadline enforced by the executor
+	AgentVersion   int `json:"agent_version,omitempty" mapstructure:"agentVersion"`     // Agent revision the job runs
+
+	Inputs map[string]interface{} `json:"inputs,omitempty" mapstructure:"inputs"` // Job inputs the executor seeds its state with
+
+	CreatorGroups []string `json:"creator_groups,omitempty" mapstructure:"creatorGroups"` // Orgs of the creator, for org-visible node definitions
 }
 
 // AgentJob GVR
@@ -111,8 +129,13 @@ var (
 	completedAgentJobQueue = workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
 	errorJobQueue          = workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
 	nodeStatusQueue        = workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	cancelAgentJobQueue    = workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
 )
 
+// executorTerminationGracePeriod is how long a cancelled executor has to report
+// its cancelled nodes after SIGTERM before it is killed.
+const executorTerminationGracePeriod = int64(30)
+
 // StartOperators initializes informers and starts watching resources
 func StartOperators(stopCh <-chan struct{}) {
 	cfg := config.LoadConfig()
@@ -171,6 +194,16 @@ func StartOperators(stopCh <-chan struct{}) {
 		go processNodeStatusQueue(dynamicClient, stopCh)
 	}
 
+	if cfg.FeatureCancelledAgentJobs == "true" {
+		go watchCancelledAgentJobs(dynamicFactory, stopCh)
+		go processCancelQueue(dynamicClient, clientset, stopCh)
+	}
+
+	if cfg.FeatureAgentSchedules == "true" {
+		go watchAgentSchedules(dynamicFactory, stopCh)
+		go processScheduleQueue(dynamicClient, stopCh)
+	}
+
 	// Start and sync factories
 	dynamicFactory.Start(stopCh)
 	k8sFactory.Start(stopCh)
@@ -303,6 +336,36 @@ func watchCompletedAgentJobs(factory dynamicinformer.DynamicSharedInformerFactor
 	informer.Run(stopCh)
 }
 
+// watchCancelledAgentJobs detects AgentJobs with spec.cancelRequested and queues them for cancellation
+func watchCancelledAgentJobs(factory dynamicinformer.DynamicSharedInformerFactory, stopCh <-chan struct{}) {
+	logger.Slog.Info("Starting AgentJob Cancellation Informer")
+
+	informer := factory.ForResource(agentJobGVR).Informer()
+
+	queueIfCancelRequested := func(obj interface{}) {
+		job, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			logger.Slog.Error("Failed to parse AgentJob object")
+			return
+		}
+
+		status, _, _ := unstructured.NestedString(job.Object, "status", "state")
+		if cancelRequested(job) && !isFinalState(status) {
+			cancelAgentJobQueue.Add(job)
+		}
+	}
+
+	// AddFunc also picks up cancellations requested while the operator was down
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: queueIfCancelRequested,
+		UpdateFunc: func(_, newObj interface{}) {
+			queueIfCancelRequested(newObj)
+		},
+	})
+
+	informer.Run(stopCh)
+}
+
 func watchNodeStatus(factory informers.SharedInformerFactory, stopCh <-chan struct{}) {
 	logger.Slog.Info("Starting Node Status Event Informer")
 
@@ -386,6 +449,16 @@ func processInactiveQueue(dynamicClient dynamic.Interface, clientset *kubernetes
 
 				logger.Slog.Info("Processing inactive AgentJob", "job", jobName)
 
+				// The queued copy may predate a cancellation; never start a cancelled job
+				if cancelled, err := isAgentJobCancelled(dynamicClient, jobName); err != nil || cancelled {
+					if err != nil {
+						logger.Slog.Error("Failed to get AgentJob", "job", jobName, "error", err)
+					} else {
+						logger.Slog.Info("Skipping cancelled AgentJob", "job", jobName)
+					}
+					return
+				}
+
 				// Update AgentJob status to "executing"
 				err := updateAgentJobStatus(dynamicClient, job, jobName, "executing", "Job is now executing")
 				if err != nil {
@@ -473,6 +546,12 @@ func processInactiveQueue(dynamicClient dynamic.Interface, clientset *kubernetes
 				// Generate User ServiceAccount Name
 				userServiceAccount := fmt.Sprintf("sa-user-%s", creator)
 
+				// Let the executor read its AgentJob to resume from checkpoints; without
+				// it the job still runs, but a restarted pod runs every node again
+				if err := grantCheckpointAccess(clientset, job, userServiceAccount); err != nil {
+					logger.Slog.Error("Failed to grant checkpoint access", "job", jobName, "error", err)
+				}
+
 				// Create Kubernetes Job for ea-job-executor
 				backoffLimit := int32(5) // Limit retries to 5
 				k8sJob := &batchv1.Job{
@@ -481,7 +560,8 @@ func processInactiveQueue(dynamicClient dynamic.Interface, clientset *kubernetes
 						Namespace: namespace,
 					},
 					Spec: batchv1.JobSpec{
-						BackoffLimit: &backoffLimit,
+						BackoffLimit:          &backoffLimit,
+						ActiveDeadlineSeconds: activeDeadlineSeconds(agent.TimeoutSeconds),
 						Template: corev1.PodTemplateSpec{
 							ObjectMeta: metav1.ObjectMeta{
 								Labels: map[string]string{
@@ -489,8 +569,9 @@ func processInactiveQueue(dynamicClient dynamic.Interface, clientset *kubernetes
 								},
 							},
 							Spec: corev1.PodSpec{
-								ServiceAccountName: userServiceAccount,
-								RestartPolicy:      corev1.RestartPolicyNever,
+								ServiceAccountName:            userServiceAccount,
+								RestartPolicy:                 corev1.RestartPolicyNever,
+								TerminationGracePeriodSeconds: ptrInt64(executorTerminationGracePeriod),
 								Containers: []corev1.Container{{
 									Name:            "executor",
 									Image:           "ea-job-executor:latest",
@@ -533,6 +614,13 @@ func processInactiveQueue(dynamicClient dynamic.Interface, clientset *kubernetes
 				}
 
 				logger.Slog.Info("Successfully created Kubernetes Job", "job", jobName)
+
+				// A cancellation processed while the Job was being created found nothing
+				// to stop, so stop the Job here
+				if cancelled, err := isAgentJobCancelled(dynamicClient, jobName); err == nil && cancelled {
+					logger.Slog.Info("AgentJob was cancelled during startup, stopping Kubernetes Job", "job", jobName)
+					cancelAgentJobQueue.Add(job)
+				}
 			}()
 		}
 	}, time.Second, stopCh)
@@ -684,6 +772,12 @@ func processErrorJobQueue(dynamicClient dynamic.Interface, clientset *kubernetes
 
 				logger.Slog.Info("Processing errored AgentJob", "job", jobName)
 
+				// A cancelled executor exits with an error; keep the cancelled state
+				if cancelled, err := isAgentJobCancelled(dynamicClient, jobName); err == nil && cancelled {
+					logger.Slog.Info("Skipping error state for cancelled AgentJob", "job", jobName)
+					return
+				}
+
 				// Update AgentJob status to "executing"
 				err := updateAgentJobStatus(dynamicClient, job, jobName, "error", "An error occured")
 				if err != nil {
@@ -704,6 +798,60 @@ func processErrorJobQueue(dynamicClient dynamic.Interface, clientset *kubernetes
 	}, time.Second, stopCh) // Runs continuously while stopCh is open
 }
 
+func processCancelQueue(dynamicClient dynamic.Interface, clientset *kubernetes.Clientset, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		if cancelAgentJobQueue.Len() == 0 {
+			return
+		}
+
+		batchSize := min(10, cancelAgentJobQueue.Len())
+
+		for i := 0; i < batchSize; i++ {
+			item, shutdown := cancelAgentJobQueue.Get()
+			if shutdown {
+				return
+			}
+
+			job := item.(*unstructured.Unstructured)
+			jobName := job.GetName()
+			configMapName := fmt.Sprintf("%s-config", jobName)
+
+			go func() {
+				defer cancelAgentJobQueue.Done(job)
+
+				logger.Slog.Info("Processing cancelled AgentJob", "job", jobName)
+
+				// Mark the AgentJob first so the failing executor is not reported as an error
+				if err := markAgentJobCancelled(dynamicClient, jobName); err != nil {
+					if apierrors.IsNotFound(err) {
+						return
+					}
+					logger.Slog.Error("Failed to mark AgentJob cancelled", "job", jobName, "error", err)
+					cancelAgentJobQueue.AddAfter(job, 10*time.Second) // Retry
+					return
+				}
+
+				// Deleting the Job sends SIGTERM to the executor, which cancels its
+				// in-flight nodes and reports them as Cancelled before exiting
+				deletePolicy := metav1.DeletePropagationBackground
+				err := clientset.BatchV1().Jobs(namespace).Delete(context.TODO(), jobName, metav1.DeleteOptions{PropagationPolicy: &deletePolicy})
+				if err != nil && !apierrors.IsNotFound(err) {
+					logger.Slog.Error("Failed to delete Kubernetes Job", "job", jobName, "error", err)
+					cancelAgentJobQueue.AddAfter(job, 10*time.Second) // Retry
+					return
+				}
+
+				err = clientset.CoreV1().ConfigMaps(namespace).Delete(context.TODO(), configMapName, metav1.DeleteOptions{})
+				if err != nil && !apierrors.IsNotFound(err) {
+					logger.Slog.Error("Failed to delete ConfigMap", "configMap", configMapName, "error", err)
+				}
+
+				logger.Slog.Info("Successfully cancelled AgentJob", "job", jobName)
+			}()
+		}
+	}, time.Second, stopCh)
+}
+
 func processNodeStatusQueue(dynamicClient dynamic.Interface, stopCh <-chan struct{}) {
 	wait.Until(func() {
 		for {
@@ -739,17 +887,31 @@ func processNodeStatusQueue(dynamicClient dynamic.Interface, stopCh <-chan struc
 				"output":      outputJSON,
 				"lastUpdated": time.Now().Format(time.RFC3339),
 			}
+			// Completed nodes also carry their unflattened result for checkpoints
+			if resultJSON := event.Annotations["result"]; resultJSON != "" {
+				nodeStatus["result"] = resultJSON
+			}
 
 			existingNodes, _, _ := unstructured.NestedSlice(agentJob.Object, "status", "nodes")
 			found := false
+			stale := false
 			for i, n := range existingNodes {
 				node := n.(map[string]interface{})
 				if node["alias"] == nodeAlias {
+					// Partial output events may arrive after the node already completed
+					if existingStatus, _ := node["status"].(string); status == "Running" && existingStatus == "Completed" {
+						stale = true
+						break
+					}
 					existingNodes[i] = nodeStatus
 					found = true
 					break
 				}
 			}
+			if stale {
+				nodeStatusQueue.Done(event)
+				continue
+			}
 			if !found {
 				existingNodes = append(existingNodes, nodeStatus)
 			}
@@ -774,6 +936,17 @@ func processNodeStatusQueue(dynamicClient dynamic.Interface, stopCh <-chan struc
 // HELPER FUNCTIONS
 //
 
+// activeDeadlineSeconds returns the K8s Job deadline for an AgentJob timeout.
+// The executor enforces the timeout itself; the Job deadline is a backstop with
+// a grace period so the executor can report timed out nodes before the pod dies.
+func activeDeadlineSeconds(timeoutSeconds int) *int64 {
+	if timeoutSeconds <= 0 {
+		return nil
+	}
+	deadline := int64(timeoutSeconds) + 60
+	return &deadline
+}
+
 // updateAgentJobStatus updates an AgentJob's status
 func updateAgentJobStatus(dynamicClient dynamic.Interface, job *unstructured.Unstructured, jobName, state, message string) error {
 	updatedJob := job.DeepCopy()
@@ -813,6 +986,59 @@ func updateAgentJobStatus(dynamicClient dynamic.Interface, job *unstructured.Uns
 	return err
 }
 
+// markAgentJobCancelled sets the state of an AgentJob to cancelled, retrying on
+// conflicts since a lost update would leave the job running. Jobs that already
+// completed or failed keep their state.
+func markAgentJobCancelled(dynamicClient dynamic.Interface, jobName string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		job, err := dynamicClient.Resource(agentJobGVR).Namespace(namespace).Get(context.TODO(), jobName, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+
+		status, _, _ := unstructured.NestedMap(job.Object, "status")
+		if status == nil {
+			status = make(map[string]interface{})
+		}
+		if state, _ := status["state"].(string); isFinalState(state) {
+			return nil
+		}
+		status["state"] = "cancelled"
+		status["message"] = "Job cancelled"
+
+		if err := unstructured.SetNestedMap(job.Object, status, "status"); err != nil {
+			return err
+		}
+		_, err = dynamicClient.Resource(agentJobGVR).Namespace(namespace).UpdateStatus(context.TODO(), job, metav1.UpdateOptions{})
+		return err
+	})
+}
+
+// isAgentJobCancelled reads the latest AgentJob and reports whether it was cancelled.
+func isAgentJobCancelled(dynamicClient dynamic.Interface, jobName string) (bool, error) {
+	job, err := dynamicClient.Resource(agentJobGVR).Namespace(namespace).Get(context.TODO(), jobName, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+	state, _, _ := unstructured.NestedString(job.Object, "status", "state")
+	return cancelRequested(job) || state == "cancelled", nil
+}
+
+// cancelRequested reports whether spec.cancelRequested is set on an AgentJob.
+func cancelRequested(job *unstructured.Unstructured) bool {
+	requested, _, _ := unstructured.NestedBool(job.Object, "spec", "cancelRequested")
+	return requested
+}
+
+// isFinalState reports whether an AgentJob state is final.
+func isFinalState(state string) bool {
+	return state == "completed" || state == "error" || state == "cancelled"
+}
+
+func ptrInt64(v int64) *int64 {
+	return &v
+}
+
 // Find the AgentJob linked to a completed Kubernetes Job
 func findAgentJobByK8sJob(dynamicClient dynamic.Interface, jobName string) (*unstructured.Unstructured, error) {
 	jobList, err := dynamicClient.Resource(agentJobGVR).Namespace(namespace).List(context.TODO(), metav1.ListOptions{})
@@ -0,0 +1,370 @@
+package operator
+
+import (
+	"bytes"
+	"context"
+	"ea-job-operator/config"
+	"ea-job-operator/logger"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/dynamic/dynamicinformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+)
+
+// AgentSchedule GVR
+var agentScheduleGVR = schema.GroupVersionResource{
+	Group:    "ea.erulabs.ai",
+	Version:  "v1",
+	Resource: "agentschedules",
+}
+
+// scheduleLabel is set by the Ea Job API on the AgentJobs a schedule starts.
+const scheduleLabel = "ea.erulabs.ai/schedule"
+
+const defaultStartingDeadlineSeconds = 60
+
+// scheduleQueue holds AgentSchedule names. Names rather than objects, so a
+// schedule that is both updated and waiting for its next run is queued once.
+var scheduleQueue = workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+
+// scheduleClient calls the Ea Job API to start scheduled runs.
+var scheduleClient = &http.Client{Timeout: 30 * time.Second}
+
+// watchAgentSchedules queues AgentSchedules when they are created or changed
+func watchAgentSchedules(factory dynamicinformer.DynamicSharedInformerFactory, stopCh <-chan struct{}) {
+	logger.Slog.Info("Starting AgentSchedule Informer")
+
+	informer := factory.ForResource(agentScheduleGVR).Informer()
+
+	queueSchedule := func(obj interface{}) {
+		schedule, ok := obj.(*unstructured.Unstructured)
+		if !ok {
+			logger.Slog.Error("Failed to parse AgentSchedule object")
+			return
+		}
+		scheduleQueue.Add(schedule.GetName())
+	}
+
+	// AddFunc also picks up every schedule when the operator starts, so runs
+	// missed while it was down are handled then
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: queueSchedule,
+		UpdateFunc: func(_, newObj interface{}) {
+			queueSchedule(newObj)
+		},
+	})
+
+	informer.Run(stopCh)
+}
+
+func processScheduleQueue(dynamicClient dynamic.Interface, stopCh <-chan struct{}) {
+	wait.Until(func() {
+		if scheduleQueue.Len() == 0 {
+			return
+		}
+
+		batchSize := min(10, scheduleQueue.Len())
+
+		for i := 0; i < batchSize; i++ {
+			item, shutdown := scheduleQueue.Get()
+			if shutdown {
+				return
+			}
+
+			name := item.(string)
+
+			go func() {
+				defer scheduleQueue.Done(name)
+
+				next, err := syncSchedule(dynamicClient, name, time.Now())
+				if err != nil {
+					logger.Slog.Error("Failed to sync AgentSchedule", "schedule", name, "error", err)
+					scheduleQueue.AddAfter(name, 10*time.Second) // Retry
+					return
+				}
+				if next > 0 {
+					scheduleQueue.AddAfter(name, next) // Wake up for the next run
+				}
+			}()
+		}
+	}, time.Second, stopCh)
+}
+
+// syncSchedule starts the run of an AgentSchedule that is due at now, if any,
+// and records it in the schedule's status. It returns how long until the next
+// run, or 0 if the schedule has none. An error means the sync must be retried.
+func syncSchedule(dynamicClient dynamic.Interface, name string, now time.Time) (time.Duration, error) {
+	schedule, err := dynamicClient.Resource(agentScheduleGVR).Namespace(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return 0, nil // Deleted
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	spec, _, _ := unstructured.NestedMap(schedule.Object, "spec")
+	status, _, _ := unstructured.NestedMap(schedule.Object, "status")
+	if status == nil {
+		status = make(map[string]interface{})
+	}
+	original := runtime.DeepCopyJSON(status)
+
+	// 🔹 Parse the cron expression in the schedule's time zone
+	expression, _ := spec["schedule"].(string)
+	timeZone, _ := spec["timeZone"].(string)
+	location, err := time.LoadLocation(timeZone)
+	if err != nil {
+		status["message"] = fmt.Sprintf("Invalid time zone %q", timeZone)
+		delete(status, "nextScheduleTime")
+		return 0, updateScheduleStatus(dynamicClient, schedule, original, status)
+	}
+	cronSchedule, err := cron.ParseStandard(expression)
+	if err != nil {
+		status["message"] = fmt.Sprintf("Invalid schedule: %v", err)
+		delete(status, "nextScheduleTime")
+		return 0, updateScheduleStatus(dynamicClient, schedule, original, status)
+	}
+
+	// 🔹 Refresh the runs that have not finished yet
+	activeJobs, err := listActiveScheduledJobs(dynamicClient, name)
+	if err != nil {
+		return 0, err
+	}
+	status["activeJobs"] = toInterfaceSlice(activeJobs)
+
+	suspend, _ := spec["suspend"].(bool)
+	if suspend {
+		status["message"] = "Schedule suspended"
+		delete(status, "nextScheduleTime")
+		return 0, updateScheduleStatus(dynamicClient, schedule, original, status)
+	}
+
+	// 🔹 Find the most recent run that is due; earlier ones were missed
+	from := schedule.GetCreationTimestamp().Time
+	if last, err := time.Parse(time.RFC3339, stringValue(status["lastScheduleTime"])); err == nil {
+		from = last
+	}
+	var due time.Time
+	missed := int64(0)
+	for t := cronSchedule.Next(from.In(location)); !t.After(now); t = cronSchedule.Next(t) {
+		if !due.IsZero() {
+			missed++
+		}
+		due = t
+	}
+
+	if !due.IsZero() {
+		deadline, _, _ := unstructured.NestedInt64(schedule.Object, "spec", "startingDeadlineSeconds")
+		if deadline <= 0 {
+			deadline = defaultStartingDeadlineSeconds
+		}
+		late := now.Sub(due) > time.Duration(deadline)*time.Second
+		missedRunPolicy, _ := spec["missedRunPolicy"].(string)
+		concurrencyPolicy, _ := spec["concurrencyPolicy"].(string)
+
+		// A retried sync may already have started this run; it does not count as earlier
+		jobName := scheduledRunName(name, due)
+		var earlierJobs []string
+		for _, job := range activeJobs {
+			if job != jobName {
+				earlierJobs = append(earlierJobs, job)
+			}
+		}
+
+		switch {
+		case late && missedRunPolicy == "skip":
+			missed++
+			status["message"] = fmt.Sprintf("Skipped the run due at %s: it is more than %ds late", due.UTC().Format(time.RFC3339), deadline)
+
+		case concurrencyPolicy == "forbid" && len(earlierJobs) > 0:
+			missed++
+			status["message"] = fmt.Sprintf("Skipped the run due at %s: %d earlier runs are still active", due.UTC().Format(time.RFC3339), len(earlierJobs))
+
+		default:
+			if concurrencyPolicy == "replace" {
+				for _, job := range earlierJobs {
+					if err := requestAgentJobCancellation(dynamicClient, job); err != nil {
+						return 0, fmt.Errorf("failed to cancel run %s: %w", job, err)
+					}
+					logger.Slog.Info("Cancelled run replaced by a scheduled run", "schedule", name, "job", job)
+				}
+			}
+
+			retryable, err := startScheduledRun(name, spec, due)
+			if err != nil && retryable {
+				return 0, err
+			}
+			if err != nil {
+				// The run cannot start as configured; record why and wait for the next one
+				logger.Slog.Error("Failed to start scheduled run", "schedule", name, "due", due, "error", err)
+				status["message"] = fmt.Sprintf("Failed to start the run due at %s: %v", due.UTC().Format(time.RFC3339), err)
+				break
+			}
+
+			logger.Slog.Info("Started scheduled run", "schedule", name, "job", jobName, "due", due)
+			status["lastJobName"] = jobName
+			status["message"] = fmt.Sprintf("Started the run due at %s", due.UTC().Format(time.RFC3339))
+			if late {
+				status["message"] = fmt.Sprintf("Started the run due at %s late", due.UTC().Format(time.RFC3339))
+			}
+			if !containsString(activeJobs, jobName) {
+				status["activeJobs"] = toInterfaceSlice(append(activeJobs, jobName))
+			}
+		}
+
+		status["lastScheduleTime"] = due.UTC().Format(time.RFC3339)
+		if missed > 0 {
+			previous, _, _ := unstructured.NestedInt64(schedule.Object, "status", "missedRuns")
+			status["missedRuns"] = previous + missed
+		}
+	}
+
+	// 🔹 Record the next run and sleep until then
+	next := cronSchedule.Next(now.In(location))
+	status["nextScheduleTime"] = next.UTC().Format(time.RFC3339)
+	if err := updateScheduleStatus(dynamicClient, schedule, original, status); err != nil {
+		return 0, err
+	}
+	return next.Sub(now), nil
+}
+
+// startScheduledRun asks the Ea Job API to start the run of a schedule due at
+// due. The API names the AgentJob after the schedule and due time, so a
+// repeated request for the same run is answered with a conflict and treated as
+// started. It reports whether a failure is worth retrying.
+func startScheduledRun(name string, spec map[string]interface{}, due time.Time) (bool, error) {
+	cfg := config.LoadConfig()
+
+	request := map[string]interface{}{
+		"agent_id":       spec["agentID"],
+		"user_id":        spec["user"],
+		"schedule":       name,
+		"scheduled_time": due.UTC().Format(time.RFC3339),
+	}
+	for field, key := range map[string]string{"agentVersion": "agent_version", "timeoutSeconds": "timeout_seconds", "inputs": "inputs"} {
+		if value, ok := spec[field]; ok {
+			request[key] = value
+		}
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return false, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, cfg.JobApiUrl, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Consumer-Username", "internal")
+
+	resp, err := scheduleClient.Do(req)
+	if err != nil {
+		return true, fmt.Errorf("failed to reach job API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode < 300, resp.StatusCode == http.StatusConflict:
+		return false, nil
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusTooManyRequests:
+		return true, fmt.Errorf("job API returned status %d", resp.StatusCode)
+	}
+
+	var failure struct {
+		Error   string   `json:"error"`
+		Details []string `json:"details"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&failure); err != nil || failure.Error == "" {
+		return false, fmt.Errorf("job API returned status %d", resp.StatusCode)
+	}
+	if len(failure.Details) > 0 {
+		return false, fmt.Errorf("%s: %v", failure.Error, failure.Details)
+	}
+	return false, fmt.Errorf("%s", failure.Error)
+}
+
+// listActiveScheduledJobs returns the AgentJobs started by a schedule that have not finished.
+func listActiveScheduledJobs(dynamicClient dynamic.Interface, schedule string) ([]string, error) {
+	jobs, err := dynamicClient.Resource(agentJobGVR).Namespace(namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s=%s", scheduleLabel, schedule),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	active := []string{}
+	for _, job := range jobs.Items {
+		state, _, _ := unstructured.NestedString(job.Object, "status", "state")
+		if !isFinalState(state) && !cancelRequested(&job) {
+			active = append(active, job.GetName())
+		}
+	}
+	return active, nil
+}
+
+// requestAgentJobCancellation sets spec.cancelRequested, which the cancellation
+// controller acts on, as the Ea Job API does for POST /jobs/{name}/cancel.
+func requestAgentJobCancellation(dynamicClient dynamic.Interface, jobName string) error {
+	patch := []byte(`{"spec":{"cancelRequested":true}}`)
+	_, err := dynamicClient.Resource(agentJobGVR).Namespace(namespace).Patch(context.TODO(), jobName, types.MergePatchType, patch, metav1.PatchOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// updateScheduleStatus writes status if it differs from original. A conflict
+// is returned as an error so the sync is retried against the new version.
+func updateScheduleStatus(dynamicClient dynamic.Interface, schedule *unstructured.Unstructured, original, status map[string]interface{}) error {
+	if reflect.DeepEqual(original, status) {
+		return nil
+	}
+	if err := unstructured.SetNestedField(schedule.Object, status, "status"); err != nil {
+		return err
+	}
+	_, err := dynamicClient.Resource(agentScheduleGVR).Namespace(namespace).UpdateStatus(context.TODO(), schedule, metav1.UpdateOptions{})
+	return err
+}
+
+// scheduledRunName names the AgentJob of the run of schedule due at t, as the
+// Ea Job API does.
+func scheduledRunName(schedule string, t time.Time) string {
+	return fmt.Sprintf("%s-%d", schedule, t.Unix()/60)
+}
+
+func toInterfaceSlice(values []string) []interface{} {
+	result := make([]interface{}, len(values))
+	for i, v := range values {
+		result[i] = v
+	}
+	return result
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func stringValue(value interface{}) string {
+	s, _ := value.(string)
+	return s
+}